@@ -0,0 +1,258 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/metrics"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+// Gateway exposes the gossip API over HTTP/JSON for modules that can not speak the binary protocol.
+// It is backed by the same announce channel, Dispatcher and DatatypeMapper as the binary Server.
+type Gateway struct {
+	httpAddress     string
+	announceMsgChan chan enum.AnnounceMsg
+	dispatcher      *common.Dispatcher
+	datatypeMapper  *common.DatatypeMapper
+	accessControl   *api.AccessControl
+	logger          *logging.Logger
+}
+
+// httpClientAddr identifies an HTTP client by its host. A client opens a new connection for its subscription and
+// possibly for every validation, so the port does not tell it apart from other clients.
+type httpClientAddr string
+
+func (c httpClientAddr) Network() string { return "http" }
+func (c httpClientAddr) String() string  { return string(c) }
+
+// clientOf returns the host r came from
+func clientOf(r *http.Request) httpClientAddr {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return httpClientAddr(host)
+}
+
+// validationRequest is the JSON body of POST /validate
+type validationRequest struct {
+	MessageID uint16 `json:"message_id"`
+	Valid     bool   `json:"valid"`
+}
+
+func NewGateway(httpAddress string, announceMsgChan chan enum.AnnounceMsg, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper, accessControl *api.AccessControl) *Gateway {
+	return &Gateway{
+		httpAddress:     httpAddress,
+		announceMsgChan: announceMsgChan,
+		dispatcher:      dispatcher,
+		datatypeMapper:  datatypeMapper,
+		accessControl:   accessControl,
+		logger:          logging.NewCustomLogger(),
+	}
+}
+
+// handler routes the requests of the gateway
+func (g *Gateway) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /announce", g.handleAnnounce)
+	mux.HandleFunc("POST /validate", g.handleValidate)
+	mux.HandleFunc("GET /subscribe/{datatype}", g.handleSubscribe)
+	mux.Handle("GET /metrics", metrics.Default)
+	return mux
+}
+
+// Start serves the HTTP gateway until ctx is done, then waits up to enum.APIShutdownTimeout for open requests
+func (g *Gateway) Start(ctx context.Context) {
+	server := &http.Server{
+		Addr:        g.httpAddress,
+		Handler:     g.handler(),
+		ReadTimeout: enum.GatewayReadTimeout,
+	}
+
+	shutdownDone := make(chan struct{})
+	stop := context.AfterFunc(ctx, func() {
+		defer close(shutdownDone)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), enum.APIShutdownTimeout)
+		defer cancel()
+		// Notification streams stay open until they are closed forcibly
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			_ = server.Close()
+		}
+	})
+	defer stop()
+
+	g.logger.InfoF("HTTP Gateway is listening on: %v", g.httpAddress)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		g.logger.ErrorF("HTTP Gateway stopped: %v", err)
+		return
+	}
+	<-shutdownDone
+	g.logger.Info("HTTP Gateway stopped")
+}
+
+// credential authenticates the request by its bearer token. It returns nil if access control is disabled.
+func (g *Gateway) credential(r *http.Request) (*api.Credential, error) {
+	if !g.accessControl.Enabled() {
+		return nil, nil
+	}
+	token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !found {
+		return nil, fmt.Errorf("missing bearer token")
+	}
+	credential, ok := g.accessControl.AuthenticateToken(token)
+	if !ok {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return credential, nil
+}
+
+// handleAnnounce accepts an enum.AnnounceMsg as JSON and hands it to the P2P layer
+func (g *Gateway) handleAnnounce(w http.ResponseWriter, r *http.Request) {
+	var msg enum.AnnounceMsg
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Unable to decode announce message", http.StatusBadRequest)
+		return
+	}
+
+	if len(msg.Data) > enum.MaxAnnounceSize {
+		http.Error(w, fmt.Sprintf("Data exceeds %d bytes", enum.MaxAnnounceSize), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	if msg.TTL == 0 {
+		msg.TTL = 1
+	}
+
+	credential, err := g.credential(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := g.accessControl.Authorize(credential, api.OpAnnounce, msg.DataType); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	g.logger.InfoF("Received HTTP announce message %+v", msg)
+
+	if err := api.SubmitAnnounce(g.announceMsgChan, msg, r.Context().Done()); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// handleValidate marks a message ID invalid, the same way a GOSSIP VALIDATION message does
+func (g *Gateway) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var msg validationRequest
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, "Unable to decode validation message", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := g.credential(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if g.accessControl.Enabled() {
+		datatype, known := g.datatypeMapper.GetMessageDatatype(msg.MessageID)
+		if !known {
+			http.Error(w, "Unknown message ID", http.StatusNotFound)
+			return
+		}
+		if err := g.accessControl.Authorize(credential, api.OpValidate, datatype); err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	g.datatypeMapper.AddValidation(api.ValidatorIdentity(credential, clientOf(r)), msg.MessageID, msg.Valid)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSubscribe streams notifications of the requested datatype as Server-Sent Events
+func (g *Gateway) handleSubscribe(w http.ResponseWriter, r *http.Request) {
+	datatype, err := common.ParseUint16(r.PathValue("datatype"))
+	if err != nil {
+		http.Error(w, "Invalid datatype", http.StatusBadRequest)
+		return
+	}
+
+	credential, err := g.credential(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if err := g.accessControl.Authorize(credential, api.OpNotify, enum.Datatype(datatype)); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	remoteAddr, err := net.ResolveTCPAddr("tcp", r.RemoteAddr)
+	if err != nil {
+		http.Error(w, "Invalid remote address", http.StatusBadRequest)
+		return
+	}
+
+	g.datatypeMapper.Add(remoteAddr, enum.Datatype(datatype))
+	g.datatypeMapper.SetValidator(remoteAddr, api.ValidatorIdentity(credential, clientOf(r)))
+	defer g.datatypeMapper.Remove(remoteAddr)
+
+	subscription := g.dispatcher.Subscribe(enum.Datatype(datatype))
+	defer g.dispatcher.Unsubscribe(subscription)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	g.logger.InfoF("HTTP client %s subscribed to datatype %d", r.RemoteAddr, datatype)
+
+	keepAlive := time.NewTicker(enum.GatewayKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			g.logger.InfoF("HTTP client %s unsubscribed", r.RemoteAddr)
+			return
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case notificationMsg, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			data, err := json.Marshal(notificationMsg)
+			if err != nil {
+				g.logger.ErrorF("Failed to marshal notification: %v", err)
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "id: %d\nevent: notification\ndata: %s\n\n", notificationMsg.MessageID, data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+// newTestGateway serves a gateway with the given credentials, access control is disabled without any
+func newTestGateway(t *testing.T, credentials ...*api.Credential) (*Gateway, *httptest.Server) {
+	gateway := NewGateway("", make(chan enum.AnnounceMsg, 1), common.NewDispatcher(nil), common.NewMap(), api.NewAccessControl(credentials...))
+	server := httptest.NewServer(gateway.handler())
+	t.Cleanup(server.Close)
+	return gateway, server
+}
+
+// TestGatewayAnnounce checks that announces are handed to the P2P layer and checked for size and permissions.
+func TestGatewayAnnounce(t *testing.T) {
+	chat := api.NewCredential("chat")
+	chat.Token = "secret"
+	chat.Allow(api.OpAnnounce, enum.Datatype(1))
+	gateway, server := newTestGateway(t, chat)
+
+	announce := func(token string, body string) int {
+		request, err := http.NewRequest(http.MethodPost, server.URL+"/announce", strings.NewReader(body))
+		require.NoError(t, err)
+		request.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(request)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusAccepted, announce("secret", `{"data_type": 1, "data": "hello"}`))
+	msg := <-gateway.announceMsgChan
+	assert.Equal(t, enum.AnnounceMsg{TTL: 1, DataType: 1, Data: "hello"}, msg)
+
+	assert.Equal(t, http.StatusUnauthorized, announce("wrong", `{"data_type": 1, "data": "hello"}`))
+	assert.Equal(t, http.StatusForbidden, announce("secret", `{"data_type": 2, "data": "hello"}`))
+	assert.Equal(t, http.StatusBadRequest, announce("secret", `{"data_type": `))
+
+	tooLarge, err := json.Marshal(enum.AnnounceMsg{DataType: 1, Data: strings.Repeat("x", enum.MaxAnnounceSize+1)})
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, announce("secret", string(tooLarge)))
+	assert.Empty(t, gateway.announceMsgChan)
+}
+
+// TestGatewayValidate checks that validations are recorded like GOSSIP VALIDATION messages.
+func TestGatewayValidate(t *testing.T) {
+	gateway, server := newTestGateway(t)
+
+	validations := make(chan bool, 1)
+	gateway.datatypeMapper.SetValidationListener(func(validator net.Addr, msgID uint16, valid bool) {
+		assert.Equal(t, uint16(5), msgID)
+		validations <- valid
+	})
+
+	resp, err := http.Post(server.URL+"/validate", "application/json", strings.NewReader(`{"message_id": 5, "valid": false}`))
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.False(t, <-validations)
+	assert.False(t, gateway.datatypeMapper.CheckNotify(5, enum.Datatype(1)))
+}
+
+// TestGatewayValidatesAsClient checks that an HTTP client counts once towards a validation policy, however many
+// connections it opens.
+func TestGatewayValidatesAsClient(t *testing.T) {
+	gateway, server := newTestGateway(t)
+	engine := api.NewPolicyEngine(gateway.datatypeMapper)
+	engine.SetPolicy(7, &api.ValidationPolicy{Mode: api.PolicyQuorum, Timeout: 200 * time.Millisecond, Quorum: 2})
+	other := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	gateway.datatypeMapper.Add(other, 7)
+
+	resp, err := http.Get(server.URL + "/subscribe/7")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	validate := func(msgID uint16) {
+		resp, err := client.Post(server.URL+"/validate", "application/json", strings.NewReader(fmt.Sprintf(`{"message_id": %d, "valid": true}`, msgID)))
+		if assert.NoError(t, err) {
+			_ = resp.Body.Close()
+		}
+	}
+
+	forward := engine.Decide(1, 7, []byte("payload"), func() {
+		go func() {
+			validate(1)
+			validate(1)
+		}()
+	})
+	assert.False(t, forward)
+
+	forward = engine.Decide(2, 7, []byte("payload"), func() {
+		go func() {
+			validate(2)
+			gateway.datatypeMapper.AddValidation(other, 2, true)
+		}()
+	})
+	assert.True(t, forward)
+}
+
+// TestGatewaySubscribe checks that notifications of the subscribed datatype are streamed as Server-Sent Events.
+func TestGatewaySubscribe(t *testing.T) {
+	gateway, server := newTestGateway(t)
+
+	resp, err := http.Get(server.URL + "/subscribe/7")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	// The subscription is registered before the headers are sent
+	require.Len(t, gateway.datatypeMapper.GetAddressesByType(enum.Datatype(7)), 1)
+	gateway.dispatcher.Publish(enum.NotificationMsg{MessageID: 3, DataType: 8, Data: "other"})
+	gateway.dispatcher.Publish(enum.NotificationMsg{MessageID: 4, DataType: 7, Data: "hello"})
+
+	reader := bufio.NewReader(resp.Body)
+	var event []string
+	for len(event) < 3 {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		event = append(event, strings.TrimSuffix(line, "\n"))
+	}
+	assert.Equal(t, "id: 4", event[0])
+	assert.Equal(t, "event: notification", event[1])
+
+	var notification enum.NotificationMsg
+	require.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(event[2], "data: ")), &notification))
+	assert.Equal(t, enum.NotificationMsg{MessageID: 4, DataType: 7, Data: "hello"}, notification)
+
+	resp.Body.Close()
+	assert.Eventually(t, func() bool {
+		return len(gateway.datatypeMapper.GetAddressesByType(enum.Datatype(7))) == 0
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,236 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+const (
+	// moduleIDKey is the gRPC metadata key a module uses to identify itself
+	moduleIDKey = "module-id"
+	// authorizationKey is the gRPC metadata key carrying the module token
+	authorizationKey = "authorization"
+)
+
+// moduleAddr identifies a gRPC module inside the DatatypeMapper, which is keyed by net.Addr
+type moduleAddr string
+
+func (m moduleAddr) Network() string { return "grpc" }
+func (m moduleAddr) String() string  { return string(m) }
+
+// GRPCServer serves the GossipAPI gRPC service next to the binary API Server.
+type GRPCServer struct {
+	pb.UnimplementedGossipAPIServer
+	grpcAddress     string
+	announceMsgChan chan enum.AnnounceMsg
+	dispatcher      *common.Dispatcher
+	datatypeMapper  *common.DatatypeMapper
+	accessControl   *api.AccessControl
+	logger          *logging.Logger
+	// streams numbers the Subscribe streams, a module may open several of them over the same connection
+	streams atomic.Uint64
+}
+
+func NewGRPCServer(grpcAddress string, announceMsgChan chan enum.AnnounceMsg, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper, accessControl *api.AccessControl) *GRPCServer {
+	return &GRPCServer{
+		grpcAddress:     grpcAddress,
+		announceMsgChan: announceMsgChan,
+		dispatcher:      dispatcher,
+		datatypeMapper:  datatypeMapper,
+		accessControl:   accessControl,
+		logger:          logging.NewCustomLogger(),
+	}
+}
+
+// Start serves the gRPC service until ctx is done, then waits up to enum.APIShutdownTimeout for open calls
+func (s *GRPCServer) Start(ctx context.Context) {
+	listener, err := net.Listen("tcp", s.grpcAddress)
+	if err != nil {
+		s.logger.ErrorF("gRPC Server failed to listen on %s: %v", s.grpcAddress, err)
+		return
+	}
+
+	server := grpc.NewServer()
+	pb.RegisterGossipAPIServer(server, s)
+
+	stop := context.AfterFunc(ctx, func() {
+		// Subscribe streams only end when they are stopped forcibly
+		timer := time.AfterFunc(enum.APIShutdownTimeout, server.Stop)
+		defer timer.Stop()
+		server.GracefulStop()
+	})
+	defer stop()
+
+	s.logger.InfoF("gRPC Server is listening on: %v", listener.Addr())
+	if err := server.Serve(listener); err != nil {
+		s.logger.ErrorF("gRPC Server stopped: %v", err)
+		return
+	}
+	s.logger.Info("gRPC Server stopped")
+}
+
+// Announce hands the announced data to the P2P layer
+func (s *GRPCServer) Announce(ctx context.Context, req *pb.AnnounceRequest) (*pb.AnnounceResponse, error) {
+	if req.Ttl > 255 || req.Datatype > 65535 {
+		return nil, status.Error(codes.InvalidArgument, "ttl or datatype out of range")
+	}
+	if len(req.Data) > enum.MaxAnnounceSize {
+		return nil, status.Errorf(codes.InvalidArgument, "data exceeds %d bytes", enum.MaxAnnounceSize)
+	}
+
+	msg := enum.AnnounceMsg{
+		TTL:      uint8(req.Ttl),
+		DataType: enum.Datatype(req.Datatype),
+		Data:     string(req.Data),
+	}
+
+	if err := s.authorize(ctx, api.OpAnnounce, msg.DataType); err != nil {
+		return nil, err
+	}
+
+	s.logger.InfoF("Received gRPC announce message from %s: %+v", moduleIdentity(ctx), msg)
+
+	if err := api.SubmitAnnounce(s.announceMsgChan, msg, ctx.Done()); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &pb.AnnounceResponse{}, nil
+}
+
+// Validate records the validation result of a message, the same way a GOSSIP VALIDATION message does
+func (s *GRPCServer) Validate(ctx context.Context, req *pb.ValidateRequest) (*pb.ValidateResponse, error) {
+	if req.MessageId > 65535 {
+		return nil, status.Error(codes.InvalidArgument, "message id out of range")
+	}
+
+	if s.accessControl.Enabled() {
+		datatype, known := s.datatypeMapper.GetMessageDatatype(uint16(req.MessageId))
+		if !known {
+			return nil, status.Error(codes.NotFound, "unknown message id")
+		}
+		if err := s.authorize(ctx, api.OpValidate, datatype); err != nil {
+			return nil, err
+		}
+	}
+
+	s.datatypeMapper.AddValidation(api.ValidatorIdentity(s.credential(ctx), moduleIdentity(ctx)), uint16(req.MessageId), req.Valid)
+
+	return &pb.ValidateResponse{}, nil
+}
+
+// Subscribe streams notifications of the requested datatypes until the client goes away
+func (s *GRPCServer) Subscribe(req *pb.SubscribeRequest, stream pb.GossipAPI_SubscribeServer) error {
+	if len(req.Datatypes) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one datatype is required")
+	}
+
+	datatypes := make([]enum.Datatype, 0, len(req.Datatypes))
+	for _, datatype := range req.Datatypes {
+		if datatype > 65535 {
+			return status.Error(codes.InvalidArgument, "datatype out of range")
+		}
+		datatypes = append(datatypes, enum.Datatype(datatype))
+		if err := s.authorize(stream.Context(), api.OpNotify, enum.Datatype(datatype)); err != nil {
+			return err
+		}
+	}
+
+	// Every stream registers its datatypes on its own, so that ending it leaves the other streams of the module alone
+	module := moduleAddr(fmt.Sprintf("%s#%d", moduleIdentity(stream.Context()), s.streams.Add(1)))
+	for _, datatype := range datatypes {
+		s.datatypeMapper.Add(module, datatype)
+	}
+	// Validations arrive on the connection, not on the stream, so the stream validates as its module
+	s.datatypeMapper.SetValidator(module, api.ValidatorIdentity(s.credential(stream.Context()), moduleIdentity(stream.Context())))
+	defer s.datatypeMapper.Remove(module)
+
+	subscription := s.dispatcher.Subscribe(datatypes...)
+	defer s.dispatcher.Unsubscribe(subscription)
+
+	s.logger.InfoF("gRPC module %s subscribed to datatypes %v", module, datatypes)
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			s.logger.InfoF("gRPC module %s unsubscribed", module)
+			return nil
+		case notificationMsg, ok := <-subscription.C:
+			if !ok {
+				return status.Error(codes.Unavailable, "notification channel closed")
+			}
+			err := stream.Send(&pb.Notification{
+				MessageId: uint32(notificationMsg.MessageID),
+				Datatype:  uint32(notificationMsg.DataType),
+				Data:      []byte(notificationMsg.Data),
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// authorize checks op on datatype for the module token sent as authorization metadata
+func (s *GRPCServer) authorize(ctx context.Context, op api.Operation, datatype enum.Datatype) error {
+	if !s.accessControl.Enabled() {
+		return nil
+	}
+
+	credential := s.credential(ctx)
+	if credential == nil {
+		return status.Error(codes.Unauthenticated, "missing or invalid token")
+	}
+
+	if err := s.accessControl.Authorize(credential, op, datatype); err != nil {
+		return status.Error(codes.PermissionDenied, err.Error())
+	}
+	return nil
+}
+
+// credential authenticates the module by the token sent as authorization metadata, it is nil if there is none
+func (s *GRPCServer) credential(ctx context.Context) *api.Credential {
+	if !s.accessControl.Enabled() {
+		return nil
+	}
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil
+	}
+	values := md.Get(authorizationKey)
+	if len(values) == 0 {
+		return nil
+	}
+	credential, _ := s.accessControl.AuthenticateToken(strings.TrimPrefix(values[0], "Bearer "))
+	return credential
+}
+
+// moduleIdentity derives the module identity from the stream: the module-id metadata if given, otherwise the peer address
+func moduleIdentity(ctx context.Context) moduleAddr {
+	id := "unknown"
+	if p, ok := peer.FromContext(ctx); ok {
+		id = p.Addr.String()
+	}
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(moduleIDKey); len(values) > 0 && values[0] != "" {
+			id = fmt.Sprintf("%s@%s", values[0], id)
+		}
+	}
+
+	return moduleAddr(id)
+}
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+// newTestGRPCServer serves a GRPCServer over an in-memory connection and returns a client connected to it
+func newTestGRPCServer(t *testing.T, credentials ...*api.Credential) (*GRPCServer, pb.GossipAPIClient) {
+	s := NewGRPCServer("", make(chan enum.AnnounceMsg, 1), common.NewDispatcher(nil), common.NewMap(), api.NewAccessControl(credentials...))
+
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	pb.RegisterGossipAPIServer(server, s)
+	go func() { _ = server.Serve(listener) }()
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return listener.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	return s, pb.NewGossipAPIClient(conn)
+}
+
+// TestGRPCAnnounce checks that announces are handed to the P2P layer and checked for range and permissions.
+func TestGRPCAnnounce(t *testing.T) {
+	chat := api.NewCredential("chat")
+	chat.Token = "secret"
+	chat.Allow(api.OpAnnounce, enum.Datatype(1))
+	s, client := newTestGRPCServer(t, chat)
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), authorizationKey, "Bearer secret")
+	_, err := client.Announce(ctx, &pb.AnnounceRequest{Ttl: 3, Datatype: 1, Data: []byte("hello")})
+	require.NoError(t, err)
+	assert.Equal(t, enum.AnnounceMsg{TTL: 3, DataType: 1, Data: "hello"}, <-s.announceMsgChan)
+
+	_, err = client.Announce(ctx, &pb.AnnounceRequest{Ttl: 3, Datatype: 2, Data: []byte("hello")})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+	_, err = client.Announce(context.Background(), &pb.AnnounceRequest{Ttl: 3, Datatype: 1, Data: []byte("hello")})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+	_, err = client.Announce(ctx, &pb.AnnounceRequest{Ttl: 256, Datatype: 1, Data: []byte("hello")})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+	assert.Empty(t, s.announceMsgChan)
+}
+
+// TestGRPCValidate checks that validations are recorded like GOSSIP VALIDATION messages.
+func TestGRPCValidate(t *testing.T) {
+	s, client := newTestGRPCServer(t)
+
+	validations := make(chan bool, 1)
+	s.datatypeMapper.SetValidationListener(func(validator net.Addr, msgID uint16, valid bool) {
+		assert.Equal(t, uint16(5), msgID)
+		validations <- valid
+	})
+
+	_, err := client.Validate(context.Background(), &pb.ValidateRequest{MessageId: 5, Valid: false})
+	require.NoError(t, err)
+	assert.False(t, <-validations)
+	assert.False(t, s.datatypeMapper.CheckNotify(5, enum.Datatype(1)))
+
+	_, err = client.Validate(context.Background(), &pb.ValidateRequest{MessageId: 65536})
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+}
+
+// TestGRPCSubscribe checks that notifications are streamed, and that ending one stream of a module keeps the
+// datatypes of its other streams registered.
+func TestGRPCSubscribe(t *testing.T) {
+	s, client := newTestGRPCServer(t)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), moduleIDKey, "chat")
+
+	firstCtx, cancelFirst := context.WithCancel(ctx)
+	defer cancelFirst()
+	first, err := client.Subscribe(firstCtx, &pb.SubscribeRequest{Datatypes: []uint32{7}})
+	require.NoError(t, err)
+	second, err := client.Subscribe(ctx, &pb.SubscribeRequest{Datatypes: []uint32{8}})
+	require.NoError(t, err)
+
+	registered := func(datatype enum.Datatype) func() bool {
+		return func() bool { return len(s.datatypeMapper.GetAddressesByType(datatype)) == 1 }
+	}
+	require.Eventually(t, registered(7), time.Second, 10*time.Millisecond)
+	require.Eventually(t, registered(8), time.Second, 10*time.Millisecond)
+
+	s.dispatcher.Publish(enum.NotificationMsg{MessageID: 4, DataType: 7, Data: "hello"})
+	notification, err := first.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(4), notification.MessageId)
+	assert.Equal(t, []byte("hello"), notification.Data)
+
+	cancelFirst()
+	assert.Eventually(t, func() bool { return !registered(7)() }, time.Second, 10*time.Millisecond)
+	assert.True(t, registered(8)())
+
+	s.dispatcher.Publish(enum.NotificationMsg{MessageID: 5, DataType: 8, Data: "still there"})
+	notification, err = second.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, uint32(5), notification.MessageId)
+}
@@ -0,0 +1,37 @@
+//go:build linux
+
+package api
+
+import (
+	"net"
+	"syscall"
+)
+
+// peerCredentials identifies the process on the other end of a unix domain socket
+type peerCredentials struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// readPeerCredentials reads SO_PEERCRED from the socket
+func readPeerCredentials(conn *net.UnixConn) (*peerCredentials, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+
+	var ucred *syscall.Ucred
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		ucred, sockErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if sockErr != nil {
+		return nil, sockErr
+	}
+
+	return &peerCredentials{UID: ucred.Uid, GID: ucred.Gid, PID: ucred.Pid}, nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package api
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials identifies the process on the other end of a unix domain socket
+type peerCredentials struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+// readPeerCredentials is only supported on linux
+func readPeerCredentials(conn *net.UnixConn) (*peerCredentials, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
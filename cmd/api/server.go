@@ -1,7 +1,14 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
 	"net"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
@@ -10,53 +17,147 @@ import (
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
 )
 
+// unixScheme prefixes api_address values that point to a unix domain socket
+const unixScheme = "unix://"
+
 type Server struct {
-	apiAddress          string
-	announceMsgChan     chan enum.AnnounceMsg
-	notificationMsgChan chan enum.NotificationMsg
-	datatypeMapper      *common.DatatypeMapper
+	apiAddress      string
+	socketMode      os.FileMode
+	announceMsgChan chan enum.AnnounceMsg
+	dispatcher      *common.Dispatcher
+	datatypeMapper  *common.DatatypeMapper
+	accessControl   *api.AccessControl
+	durableQueues   *api.DurableQueues
 }
 
-func NewServer(apiAddress string, announceMsgChan chan enum.AnnounceMsg, notificationMsgChan chan enum.NotificationMsg, datatypeMapper *common.DatatypeMapper) *Server {
-	return &Server{apiAddress: apiAddress, announceMsgChan: announceMsgChan, notificationMsgChan: notificationMsgChan, datatypeMapper: datatypeMapper}
+// NewServer creates the binary API server. apiAddress is either host:port or unix:///path/to.sock,
+// in which case the socket file is created with socketMode. durableQueues may be nil if durable queues are disabled.
+func NewServer(apiAddress string, socketMode os.FileMode, announceMsgChan chan enum.AnnounceMsg, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper, accessControl *api.AccessControl, durableQueues *api.DurableQueues) *Server {
+	return &Server{apiAddress: apiAddress, socketMode: socketMode, announceMsgChan: announceMsgChan, dispatcher: dispatcher, datatypeMapper: datatypeMapper, accessControl: accessControl, durableQueues: durableQueues}
 }
 
-func (s *Server) Start() {
-	var wg sync.WaitGroup
+// Start serves API sessions until ctx is done
+func (s *Server) Start(ctx context.Context) {
+	logger := logging.NewCustomLogger()
 
-	listen(s.apiAddress, &wg, s.announceMsgChan, s.notificationMsgChan, s.datatypeMapper)
+	listener, listenerErr := newListener(s.apiAddress, s.socketMode)
+	if listenerErr != nil {
+		logger.ErrorF("failed to open API listener: %v", listenerErr)
+		return
+	}
 
-	// Wait for all goroutines to finish
-	wg.Wait()
+	s.Serve(ctx, listener)
 }
 
-func listen(apiAddress string, wg *sync.WaitGroup, announceMsgChan chan enum.AnnounceMsg, notificationMsgChan chan enum.NotificationMsg, datatypeMapper *common.DatatypeMapper) {
-	listener, listenerErr := net.Listen("tcp", apiAddress)
+// newListener opens a TCP listener, or a unix domain socket listener if apiAddress starts with unix://
+func newListener(apiAddress string, socketMode os.FileMode) (net.Listener, error) {
+	if !strings.HasPrefix(apiAddress, unixScheme) {
+		listener, err := net.Listen("tcp", apiAddress)
+		if err != nil {
+			return net.Listen("tcp", "localhost:0")
+		}
+		return listener, nil
+	}
 
-	logger := logging.NewCustomLogger()
+	socketPath := strings.TrimPrefix(apiAddress, unixScheme)
 
-	if listenerErr != nil {
-		listener, listenerErr = net.Listen("tcp", "localhost:0")
-		if listenerErr != nil {
-			logger.ErrorF("failed to find an available port: %v", listenerErr)
+	// Remove a stale socket left behind by a previous run, but never any other kind of file
+	if info, err := os.Lstat(socketPath); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", socketPath)
+		}
+		if err := os.Remove(socketPath); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
 		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
 	}
 
-	defer func(listener net.Listener) {
-		err := listener.Close()
-		if err != nil {
-			logger.FatalF("Error closing TCP server: %v", err)
+	// The socket is bound inside a directory only this process may enter and moved into place once it has its
+	// permissions, so that no other local user can connect in between
+	privateDir, err := os.MkdirTemp(filepath.Dir(socketPath), ".api-socket-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	defer os.RemoveAll(privateDir)
+
+	boundPath := filepath.Join(privateDir, "api.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: boundPath, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	// The listener would remove the path it was bound to, unixSocketListener removes the socket instead
+	listener.SetUnlinkOnClose(false)
+
+	if err := os.Chmod(boundPath, socketMode); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	if err := os.Rename(boundPath, socketPath); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to move socket into place: %w", err)
+	}
+
+	return &unixSocketListener{UnixListener: listener, path: socketPath}, nil
+}
+
+// unixSocketListener removes its socket file when it is closed
+type unixSocketListener struct {
+	*net.UnixListener
+	path string
+}
+
+func (l *unixSocketListener) Close() error {
+	if err := l.UnixListener.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(l.path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Serve accepts API sessions on listener until it is closed or ctx is done. Then it closes the listener and all open
+// sessions, so that modules cannot announce anything anymore, and returns once the sessions ended.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) {
+	var wg sync.WaitGroup
+	logger := logging.NewCustomLogger()
+
+	var sessionsMutex sync.Mutex
+	sessions := make(map[net.Conn]struct{})
+	stopped := false
+	stop := context.AfterFunc(ctx, func() {
+		_ = listener.Close()
+
+		sessionsMutex.Lock()
+		defer sessionsMutex.Unlock()
+		stopped = true
+		for conn := range sessions {
+			_ = conn.Close()
 		}
-	}(listener)
+	})
+	defer stop()
 
 	logger.InfoF("API Server is listening on: %v", listener.Addr())
 
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
-			logger.InfoF("Error accepting connection: ", err)
+			if errors.Is(err, net.ErrClosed) {
+				break
+			}
+			logger.InfoF("Error accepting connection: %v", err)
+			continue
+		}
+
+		sessionsMutex.Lock()
+		if stopped {
+			sessionsMutex.Unlock()
+			_ = conn.Close()
 			continue
 		}
+		sessions[conn] = struct{}{}
+		sessionsMutex.Unlock()
 
 		// Increment the WaitGroup counter
 		wg.Add(1)
@@ -64,10 +165,31 @@ func listen(apiAddress string, wg *sync.WaitGroup, announceMsgChan chan enum.Ann
 		// handle this request in a different goroutine
 		go func(conn net.Conn) {
 			defer wg.Done() // Decrement the counter when the goroutine completes
-			logger.Host(conn.LocalAddr().String())
-			logger.Client(conn.RemoteAddr().String())
-			handler := api.NewHandler(conn, logger, announceMsgChan, notificationMsgChan, datatypeMapper)
+			defer func() {
+				sessionsMutex.Lock()
+				delete(sessions, conn)
+				sessionsMutex.Unlock()
+			}()
+			sessionLogger := logging.NewCustomLogger()
+			sessionLogger.Host(conn.LocalAddr().String())
+			sessionLogger.Client(conn.RemoteAddr().String())
+
+			handler := api.NewHandler(conn, sessionLogger, s.announceMsgChan, s.dispatcher, s.datatypeMapper, s.accessControl, s.durableQueues)
+
+			if unixConn, ok := conn.(*net.UnixConn); ok {
+				if credentials, err := readPeerCredentials(unixConn); err != nil {
+					sessionLogger.ErrorF("Failed to read peer credentials: %v", err)
+				} else {
+					sessionLogger.InfoF("Unix socket session from uid=%d gid=%d pid=%d", credentials.UID, credentials.GID, credentials.PID)
+					handler.AuthenticateUID(credentials.UID)
+				}
+			}
+
 			handler.Handle()
 		}(conn)
 	}
+
+	// Wait for all sessions to finish
+	wg.Wait()
+	logger.Info("API Server stopped")
 }
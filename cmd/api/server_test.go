@@ -0,0 +1,39 @@
+package api
+
+import (
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestUnixSocketListener checks that the API socket is created with its permissions in place and removed on close.
+func TestUnixSocketListener(t *testing.T) {
+	dir := t.TempDir()
+	socketPath := filepath.Join(dir, "api.sock")
+
+	listener, err := newListener(unixScheme+socketPath, 0600)
+	require.NoError(t, err)
+
+	info, err := os.Lstat(socketPath)
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeSocket, info.Mode().Type())
+	assert.Equal(t, fs.FileMode(0600), info.Mode().Perm())
+
+	conn, err := net.Dial("unix", socketPath)
+	require.NoError(t, err)
+	_ = conn.Close()
+
+	// Only the socket is left, not the directory it was bound in
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	require.NoError(t, listener.Close())
+	_, err = os.Lstat(socketPath)
+	assert.ErrorIs(t, err, fs.ErrNotExist)
+}
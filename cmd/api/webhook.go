@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/config"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/metrics"
+)
+
+// webhookSectionPrefix prefixes config.ini sections that declare a webhook, e.g. [webhook:archive]
+const webhookSectionPrefix = "webhook:"
+
+// webhookAddr identifies a webhook inside the DatatypeMapper, which is keyed by net.Addr
+type webhookAddr string
+
+func (w webhookAddr) Network() string   { return "webhook" }
+func (w webhookAddr) String() string    { return string(w) }
+func (w webhookAddr) AwaitedValidator() {}
+
+var droppedWebhookNotifications = metrics.Default.Counter("api_webhook_dropped_total",
+	"Notifications dead-lettered without a delivery attempt because too many deliveries of their webhook were in flight.")
+
+// errTooManyDeliveries is recorded for notifications a webhook had no room to deliver
+var errTooManyDeliveries = errors.New("too many deliveries in flight")
+
+// deadLetter is one line of the dead-letter file
+type deadLetter struct {
+	Time         time.Time            `json:"time"`
+	Webhook      string               `json:"webhook"`
+	URL          string               `json:"url"`
+	Notification enum.NotificationMsg `json:"notification"`
+	Error        string               `json:"error"`
+}
+
+// Webhook POSTs every notification of one datatype as JSON to a URL. A 2xx response validates the
+// message, a 4xx response invalidates it; other failures are retried and finally dead-lettered.
+type Webhook struct {
+	name           string
+	datatype       enum.Datatype
+	url            string
+	maxRetries     int
+	minBackoff     time.Duration
+	maxBackoff     time.Duration
+	deadLetterPath string
+	deadLetterMu   sync.Mutex
+	// inFlight bounds the deliveries running at the same time, each holds one slot until it is done
+	inFlight       chan struct{}
+	client         *http.Client
+	dispatcher     *common.Dispatcher
+	datatypeMapper *common.DatatypeMapper
+	logger         *logging.Logger
+}
+
+func NewWebhook(name string, datatype enum.Datatype, url string, maxRetries int, deadLetterPath string, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper) *Webhook {
+	return &Webhook{
+		name:           name,
+		datatype:       datatype,
+		url:            url,
+		maxRetries:     maxRetries,
+		minBackoff:     enum.WebhookMinBackoff,
+		maxBackoff:     enum.WebhookMaxBackoff,
+		deadLetterPath: deadLetterPath,
+		inFlight:       make(chan struct{}, enum.WebhookMaxInFlight),
+		client:         &http.Client{Timeout: enum.WebhookTimeout},
+		dispatcher:     dispatcher,
+		datatypeMapper: datatypeMapper,
+		logger:         logging.NewCustomLogger(),
+	}
+}
+
+// LoadWebhooks reads every [webhook:<name>] section of config.ini:
+//
+//	[webhook:archive]
+//	datatype = 1
+//	url = http://localhost:8000/gossip
+//	max_retries = 5
+//	dead_letter = webhook-archive.dead
+func LoadWebhooks(configFile *config.Config, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper) ([]*Webhook, error) {
+	var webhooks []*Webhook
+
+	for _, section := range configFile.Sections() {
+		if !strings.HasPrefix(section, webhookSectionPrefix) {
+			continue
+		}
+		name := strings.TrimPrefix(section, webhookSectionPrefix)
+
+		value, err := configFile.RawString(section, "datatype")
+		if err != nil {
+			return nil, fmt.Errorf("webhook %s needs a datatype", name)
+		}
+		datatype, err := common.ParseUint16(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid datatype for webhook %s: %w", name, err)
+		}
+
+		url, err := configFile.RawString(section, "url")
+		if err != nil || strings.TrimSpace(url) == "" {
+			return nil, fmt.Errorf("webhook %s needs a url", name)
+		}
+
+		maxRetries := enum.WebhookMaxRetries
+		if configFile.HasOption(section, "max_retries") {
+			if maxRetries, err = configFile.Int(section, "max_retries"); err != nil || maxRetries < 0 {
+				return nil, fmt.Errorf("invalid max_retries for webhook %s", name)
+			}
+		}
+
+		deadLetterPath := "webhook-" + name + ".dead"
+		if configFile.HasOption(section, "dead_letter") {
+			if deadLetterPath, err = configFile.RawString(section, "dead_letter"); err != nil {
+				return nil, err
+			}
+			deadLetterPath = strings.TrimSpace(deadLetterPath)
+		}
+
+		webhooks = append(webhooks, NewWebhook(name, enum.Datatype(datatype), strings.TrimSpace(url), maxRetries, deadLetterPath, dispatcher, datatypeMapper))
+	}
+
+	return webhooks, nil
+}
+
+// Start subscribes the webhook to its datatype and delivers notifications until ctx is done or the Dispatcher stops.
+// It returns once the deliveries in flight finished; those still retrying when ctx is done are dead-lettered.
+func (wh *Webhook) Start(ctx context.Context) {
+	subscription := wh.dispatcher.Subscribe(wh.datatype)
+	defer wh.dispatcher.Unsubscribe(subscription)
+
+	addr := webhookAddr(wh.name)
+	wh.datatypeMapper.Add(addr, wh.datatype)
+	defer wh.datatypeMapper.Remove(addr)
+
+	wh.logger.InfoF("Webhook %s delivers datatype %d to %s", wh.name, wh.datatype, wh.url)
+
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for {
+		var notificationMsg enum.NotificationMsg
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok := <-subscription.C:
+			if !ok {
+				return
+			}
+			notificationMsg = msg
+		}
+
+		// Each notification is delivered on its own, so retries do not hold back validation of others. An endpoint
+		// that keeps enum.WebhookMaxInFlight deliveries waiting does not keep up, further notifications are
+		// dead-lettered right away.
+		select {
+		case wh.inFlight <- struct{}{}:
+		default:
+			droppedWebhookNotifications.Add(1)
+			wh.logger.ErrorF("Webhook %s dropped message %d: %v", wh.name, notificationMsg.MessageID, errTooManyDeliveries)
+			wh.writeDeadLetter(notificationMsg, errTooManyDeliveries)
+			continue
+		}
+
+		wg.Add(1)
+		go func(notificationMsg enum.NotificationMsg) {
+			defer wg.Done()
+			defer func() { <-wh.inFlight }()
+			wh.deliver(ctx, notificationMsg)
+		}(notificationMsg)
+	}
+}
+
+// deliver POSTs one notification, retrying with exponential backoff until ctx is done
+func (wh *Webhook) deliver(ctx context.Context, notificationMsg enum.NotificationMsg) {
+	body, err := json.Marshal(notificationMsg)
+	if err != nil {
+		wh.logger.ErrorF("Webhook %s failed to encode notification: %v", wh.name, err)
+		return
+	}
+
+	backoff := wh.minBackoff
+	for attempt := 0; ; attempt++ {
+		status, err := wh.post(ctx, body)
+		switch {
+		case err == nil && status >= 200 && status < 300:
+			wh.datatypeMapper.AddValidation(webhookAddr(wh.name), notificationMsg.MessageID, true)
+			return
+		case err == nil && status >= 400 && status < 500:
+			wh.logger.InfoF("Webhook %s rejected message %d with status %d", wh.name, notificationMsg.MessageID, status)
+			wh.datatypeMapper.AddValidation(webhookAddr(wh.name), notificationMsg.MessageID, false)
+			return
+		case err == nil:
+			err = fmt.Errorf("unexpected status %d", status)
+		}
+
+		if attempt >= wh.maxRetries {
+			wh.logger.ErrorF("Webhook %s gave up on message %d: %v", wh.name, notificationMsg.MessageID, err)
+			wh.writeDeadLetter(notificationMsg, err)
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			wh.logger.ErrorF("Webhook %s stopped retrying message %d: %v", wh.name, notificationMsg.MessageID, ctx.Err())
+			wh.writeDeadLetter(notificationMsg, err)
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > wh.maxBackoff {
+			backoff = wh.maxBackoff
+		}
+	}
+}
+
+func (wh *Webhook) post(ctx context.Context, body []byte) (int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := wh.client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	_ = response.Body.Close()
+	return response.StatusCode, nil
+}
+
+// writeDeadLetter appends a notification that could not be delivered to the dead-letter file
+func (wh *Webhook) writeDeadLetter(notificationMsg enum.NotificationMsg, deliveryErr error) {
+	line, err := json.Marshal(deadLetter{
+		Time:         time.Now(),
+		Webhook:      wh.name,
+		URL:          wh.url,
+		Notification: notificationMsg,
+		Error:        deliveryErr.Error(),
+	})
+	if err != nil {
+		wh.logger.ErrorF("Webhook %s failed to encode dead letter: %v", wh.name, err)
+		return
+	}
+
+	wh.deadLetterMu.Lock()
+	defer wh.deadLetterMu.Unlock()
+
+	file, err := os.OpenFile(wh.deadLetterPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		wh.logger.ErrorF("Webhook %s failed to open dead-letter file: %v", wh.name, err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		wh.logger.ErrorF("Webhook %s failed to write dead letter: %v", wh.name, err)
+	}
+}
@@ -0,0 +1,194 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+// TestWebhookValidation checks that 2xx and 4xx responses are reported as validations.
+func TestWebhookValidation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg enum.NotificationMsg
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		if msg.Data == "bad" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+		}
+	}))
+	defer server.Close()
+
+	validations := make(chan bool, 2)
+	datatypeMapper := common.NewMap()
+	datatypeMapper.SetValidationListener(func(validator net.Addr, msgID uint16, valid bool) { validations <- valid })
+
+	webhook := NewWebhook("test", 1, server.URL, 0, filepath.Join(t.TempDir(), "dead"), common.NewDispatcher(nil), datatypeMapper)
+
+	webhook.deliver(context.Background(), enum.NotificationMsg{MessageID: 1, DataType: 1, Data: "good"})
+	webhook.deliver(context.Background(), enum.NotificationMsg{MessageID: 2, DataType: 1, Data: "bad"})
+
+	assert.True(t, <-validations)
+	assert.False(t, <-validations)
+}
+
+// TestWebhookDecidesForwarding checks that messages are not forwarded before the webhook answered, even without a
+// validation policy.
+func TestWebhookDecidesForwarding(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg enum.NotificationMsg
+		_ = json.NewDecoder(r.Body).Decode(&msg)
+		if msg.Data == "bad" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	datatypeMapper := common.NewMap()
+	engine := api.NewPolicyEngine(datatypeMapper)
+	webhook := NewWebhook("test", 1, server.URL, 0, filepath.Join(t.TempDir(), "dead"), common.NewDispatcher(nil), datatypeMapper)
+	datatypeMapper.Add(webhookAddr("test"), 1)
+
+	for id, data := range map[uint16]string{1: "good", 2: "bad"} {
+		notification := enum.NotificationMsg{MessageID: id, DataType: 1, Data: data}
+		forward := engine.Decide(id, 1, []byte(data), func() { go webhook.deliver(context.Background(), notification) })
+		assert.Equal(t, data == "good", forward, data)
+	}
+}
+
+// TestWebhookDeadLetter checks that failing deliveries are retried and finally dead-lettered.
+func TestWebhookDeadLetter(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead")
+	webhook := NewWebhook("test", 1, server.URL, 2, deadLetterPath, common.NewDispatcher(nil), common.NewMap())
+	webhook.minBackoff = time.Millisecond
+
+	webhook.deliver(context.Background(), enum.NotificationMsg{MessageID: 7, DataType: 1, Data: "lost"})
+	assert.Equal(t, int32(3), attempts.Load())
+
+	raw, err := os.ReadFile(deadLetterPath)
+	require.NoError(t, err)
+
+	var letter deadLetter
+	require.NoError(t, json.Unmarshal(raw, &letter))
+	assert.Equal(t, uint16(7), letter.Notification.MessageID)
+	assert.Equal(t, server.URL, letter.URL)
+}
+
+// TestWebhookBoundsDeliveries checks that notifications beyond the deliveries in flight are dead-lettered right away.
+func TestWebhookBoundsDeliveries(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	notificationMsgChan := make(chan enum.NotificationMsg)
+	dispatcher := common.NewDispatcher(notificationMsgChan)
+	go dispatcher.Run()
+	datatypeMapper := common.NewMap()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead")
+	webhook := NewWebhook("test", 1, server.URL, 0, deadLetterPath, dispatcher, datatypeMapper)
+	webhook.inFlight = make(chan struct{}, 1)
+
+	stopped := make(chan struct{})
+	go func() {
+		webhook.Start(context.Background())
+		close(stopped)
+	}()
+	require.Eventually(t, func() bool {
+		return len(datatypeMapper.GetAddressesByType(1)) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	for id := uint16(1); id <= 3; id++ {
+		notificationMsgChan <- enum.NotificationMsg{MessageID: id, DataType: 1}
+	}
+
+	var letters []deadLetter
+	require.Eventually(t, func() bool {
+		raw, err := os.ReadFile(deadLetterPath)
+		if err != nil {
+			return false
+		}
+		letters = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+			var letter deadLetter
+			require.NoError(t, json.Unmarshal([]byte(line), &letter))
+			letters = append(letters, letter)
+		}
+		return len(letters) == 2
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, uint16(2), letters[0].Notification.MessageID)
+	assert.Equal(t, errTooManyDeliveries.Error(), letters[1].Error)
+
+	close(release)
+	close(notificationMsgChan)
+	<-stopped
+}
+
+// TestWebhookStopsWithContext checks that Start returns once ctx is done, dead-lettering deliveries still retrying.
+func TestWebhookStopsWithContext(t *testing.T) {
+	var attempts atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	notificationMsgChan := make(chan enum.NotificationMsg)
+	defer close(notificationMsgChan)
+	dispatcher := common.NewDispatcher(notificationMsgChan)
+	go dispatcher.Run()
+	datatypeMapper := common.NewMap()
+
+	deadLetterPath := filepath.Join(t.TempDir(), "dead")
+	webhook := NewWebhook("test", 1, server.URL, 5, deadLetterPath, dispatcher, datatypeMapper)
+	webhook.minBackoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		webhook.Start(ctx)
+		close(stopped)
+	}()
+	require.Eventually(t, func() bool {
+		return len(datatypeMapper.GetAddressesByType(1)) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	notificationMsgChan <- enum.NotificationMsg{MessageID: 7, DataType: 1}
+	require.Eventually(t, func() bool { return attempts.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("webhook did not stop")
+	}
+
+	raw, err := os.ReadFile(deadLetterPath)
+	require.NoError(t, err)
+	var letter deadLetter
+	require.NoError(t, json.Unmarshal(raw, &letter))
+	assert.Equal(t, uint16(7), letter.Notification.MessageID)
+	assert.Empty(t, datatypeMapper.GetAddressesByType(1))
+}
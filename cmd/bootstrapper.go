@@ -1,231 +1,84 @@
 package main
 
 import (
-	"encoding/json"
-	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
-	"log"
-	"math/rand"
+	"context"
+	"errors"
+	"flag"
 	"net/http"
-	"sync"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/bootstrapper"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/peerstore"
 )
 
-type Bootstrapper struct {
-	mu                  sync.RWMutex
-	peersTimeoutList    map[string]time.Time
-	seedNodes           []string
-	seedNodeLimit       int
-	timeout             time.Duration
-	cleanupListInterval time.Duration
-}
-
-func NewBootstrapper() *Bootstrapper {
-	return &Bootstrapper{
-		peersTimeoutList:    make(map[string]time.Time),
-		seedNodes:           []string{},
-		seedNodeLimit:       enum.SeedNodeLimit,
-		timeout:             enum.Timeout,
-		cleanupListInterval: enum.CleanupListInterval, // Set a timeout for node inactivity
-	}
-}
-
-func (b *Bootstrapper) RegisterPeer(w http.ResponseWriter, r *http.Request) {
+func main() {
 	logger := logging.NewCustomLogger()
 
-	if err := r.ParseForm(); err != nil {
-		http.Error(w, "Unable to parse form", http.StatusBadRequest)
-		return
-	}
-
-	peer := r.FormValue("peer")
-	if peer == "" {
-		http.Error(w, "Missing peer", http.StatusBadRequest)
+	cfg, err := bootstrapper.ParseConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
 		return
 	}
-
-	b.mu.Lock()
-
-	// Primitive logic to add peer to seednode list
-	if len(b.seedNodes) < b.seedNodeLimit && !contains(b.seedNodes, peer) {
-		logger.InfoF("Peer %s Registered as seed", peer)
-		b.seedNodes = append(b.seedNodes, peer)
-	}
-
-	b.peersTimeoutList[peer] = time.Now()
-	b.mu.Unlock()
-	w.WriteHeader(http.StatusOK)
-	logger.InfoF("Peer %s registered successfully", peer)
-
-	b.printRegisteredPeers()
-	b.printSeedNodes()
-}
-
-func (b *Bootstrapper) DeregisterPeer(w http.ResponseWriter, r *http.Request) {
-	peer := r.URL.Query().Get("peer")
-	if peer == "" {
-		http.Error(w, "Missing peer", http.StatusBadRequest)
-		return
-	}
-
-	b.mu.Lock()
-	delete(b.peersTimeoutList, peer)
-	b.mu.Unlock()
-
-	w.WriteHeader(http.StatusOK)
-}
-
-func (b *Bootstrapper) GetPeers(w http.ResponseWriter, r *http.Request) {
-
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	peers := make([]string, 0, len(b.peersTimeoutList))
-	for peer := range b.peersTimeoutList {
-		peers = append(peers, peer)
-	}
-	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
-
-	subsetSize := 5 // Limit the number of peers returned to a subset, for example, 5 peer
-	if len(peers) < subsetSize {
-		subsetSize = len(peers)
-	}
-
-	requiredSeedCount := len(b.seedNodes)
-	if requiredSeedCount == 0 && len(b.seedNodes) > 0 {
-		requiredSeedCount = 1 // Ensure at least one seed node is added
+	if err != nil {
+		logger.FatalF("Invalid bootstrapper configuration: %v", err)
 	}
 
-	partialPeers := make([]string, 0, subsetSize)
-	seedPeers := make([]string, 0, len(b.seedNodes)) // Separate seedNodes list for response
-
-	seedCount := 0
-
-	for _, seedNode := range b.seedNodes {
-		if seedCount < requiredSeedCount && len(partialPeers) < subsetSize {
-			partialPeers = append(partialPeers, seedNode)
-			seedCount++
+	var store *peerstore.Store
+	if cfg.DataDir != "" {
+		if store, err = peerstore.Open(cfg.DataDir, enum.PeerStoreCompactAfter); err != nil {
+			logger.FatalF("Failed to open peer registry: %v", err)
 		}
-		seedPeers = append(seedPeers, seedNode)
-
+		logger.InfoF("Restored %d peers from %s", len(store.Records()), cfg.DataDir)
 	}
 
-	// Fill remaining partialPeers with non-seed nodes
-	for _, peer := range peers {
-		if !contains(partialPeers, peer) && len(partialPeers) < subsetSize {
-			partialPeers = append(partialPeers, peer)
-		}
-	}
+	b := bootstrapper.New(cfg, store)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	response := map[string][]string{
-		"partialPeers": partialPeers,
-		"seedNodes":    seedPeers,
+	go b.RemoveInactivePeers(ctx)
+	go b.ElectSeedsPeriodically(ctx)
+	if len(cfg.Replicas) > 0 {
+		go b.Replicate(ctx)
 	}
 
-	err := json.NewEncoder(w).Encode(response)
+	handler, err := b.Handler()
 	if err != nil {
-		http.Error(w, "Unable to encode peers", http.StatusInternalServerError)
+		logger.FatalF("Failed to load dashboard: %v", err)
 	}
-}
-
-func (b *Bootstrapper) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
-	logger := logging.NewCustomLogger()
 
-	peer := r.URL.Query().Get("peer")
-	if peer == "" {
-		http.Error(w, "Missing peer", http.StatusBadRequest)
-		return
+	server := &http.Server{
+		Addr:         cfg.Address,
+		Handler:      handler,
+		ReadTimeout:  enum.BootstrapperReadTimeout,
+		WriteTimeout: enum.BootstrapperWriteTimeout,
 	}
 
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	if _, exists := b.peersTimeoutList[peer]; exists {
-		b.peersTimeoutList[peer] = time.Now()
-		w.WriteHeader(http.StatusOK)
-	} else {
-		logger.Error("Peer is not registered with Bootstrapping Server")
-
-		http.Error(w, "Peer not registered", http.StatusBadRequest)
-	}
-}
-
-func (b *Bootstrapper) RemoveInactivePeers() {
-	logger := logging.NewCustomLogger()
-
-	ticker := time.NewTicker(b.cleanupListInterval)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ticker.C:
-			b.mu.Lock()
-			for peer, lastSeen := range b.peersTimeoutList {
-
-				if time.Since(lastSeen) > b.timeout {
-					logger.InfoF("Removing inactive peer: %s", peer)
-					delete(b.peersTimeoutList, peer)
-
-					// Remove peer from also seedNodes
-					for i, seed := range b.seedNodes {
-						if seed == peer {
-							b.seedNodes = append(b.seedNodes[:i], b.seedNodes[i+1:]...)
-							break
-						}
-					}
-				}
-			}
-			b.mu.Unlock()
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		logger.Info("Shutting down bootstrapper")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), enum.BootstrapperShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.ErrorF("Failed to shut down gracefully: %v", err)
 		}
-	}
-}
+	}()
 
-func (b *Bootstrapper) printRegisteredPeers() {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	logger := logging.NewCustomLogger()
-	logger.Info("Current list of registered peers:")
-
-	counter := 1
-	for peer := range b.peersTimeoutList {
-		logger.InfoF("%d: %s", counter, peer)
-		counter++
-	}
-}
-
-func (b *Bootstrapper) printSeedNodes() {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	logger := logging.NewCustomLogger()
-	logger.Info("Current list of seed nodes:")
-
-	for i, peer := range b.seedNodes {
-		logger.InfoF("%d: %s", i+1, peer)
+	logger.InfoF("Bootstrapper server is running on %s", cfg.Address)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.FatalF("Bootstrapper stopped: %v", err)
 	}
-}
-
-func main() {
-	bootstrapper := NewBootstrapper()
-	logger := logging.NewCustomLogger()
-
-	go bootstrapper.RemoveInactivePeers()
-
-	http.HandleFunc("/register", bootstrapper.RegisterPeer)
-	http.HandleFunc("/deregister", bootstrapper.DeregisterPeer)
-	http.HandleFunc("/peers", bootstrapper.GetPeers)
-	http.HandleFunc("/heartbeat", bootstrapper.HandleHeartbeat)
-
-	logger.Info("Bootstrapper server is running on port 8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
+	// Requests still being handled may change the registry until the shutdown completed
+	<-shutdownDone
 
-func contains(peers []string, peer string) bool {
-	for _, p := range peers {
-		if p == peer {
-			return true
+	if store != nil {
+		if err := store.Close(); err != nil {
+			logger.ErrorF("Failed to close peer registry: %v", err)
 		}
 	}
-	return false
 }
@@ -1,262 +1,412 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"net"
+	"io"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
-	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/gossipclient"
 )
 
-func main() {
-
-	logger := logging.NewCustomLogger()
+// errUsage marks errors caused by wrong invocation
+var errUsage = errors.New("usage error")
 
-	var (
-		announce    bool
-		notify      bool
-		destination string
-		port        int
-		message     string
-		ttl         int
-		datatype    int
-	)
+// command is one subcommand of the client
+type command struct {
+	name        string
+	description string
+	run         func(args []string, out *output) error
+}
 
-	flag.BoolVar(&announce, "a", false, "Send a GOSSIP_ANNOUNCE message")
-	flag.BoolVar(&notify, "n", false, "Send a GOSSIP_NOTIFY message")
-	flag.StringVar(&destination, "d", "", "GOSSIP host module IP")
-	flag.IntVar(&port, "p", 0, "GOSSIP host module port")
-	flag.IntVar(&ttl, "ttl", 1, "GOSSIP host module port")
-	flag.StringVar(&message, "m", "", "GOSSIP_ANNOUNCE payload message")
-	flag.IntVar(&datatype, "t", 1, "GOSSIP_NOTIFY datatype")
-
-	flag.Usage = func() {
-		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [options]\n", os.Args[0])
-		_, _ = fmt.Fprintf(os.Stderr, "Options:\n")
-		flag.PrintDefaults()
-		_, _ = fmt.Fprintf(os.Stderr, `
-Examples:
-  Send a GOSSIP_ANNOUNCE message:
-    %s -a -d 127.0.0.1 -p 9001 announce_message
+var commands = []command{
+	{name: "announce", description: "Send a GOSSIP ANNOUNCE message", run: runAnnounce},
+	{name: "subscribe", description: "Send GOSSIP NOTIFY messages and print notifications until interrupted", run: runSubscribe},
+	{name: "validate", description: "Send a GOSSIP VALIDATION message", run: runValidate},
+	{name: "bench", description: "Send a number of GOSSIP ANNOUNCE messages at a fixed rate", run: runBench},
+}
 
-  Send a GOSSIP_NOTIFY message:
-    %s -n -d 127.0.0.1 -p 9001 -m notify_message
-`, os.Args[0], os.Args[0])
-	}
+// commonFlags are accepted by every subcommand
+type commonFlags struct {
+	address string
+	token   string
+	json    bool
+}
 
-	flag.Parse()
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.address, "addr", "127.0.0.1:9001", "GOSSIP API address, host:port or unix:///path/to.sock")
+	fs.StringVar(&c.token, "token", "", "Module token for authentication")
+	fs.BoolVar(&c.json, "json", false, "Print results as JSON lines")
+}
 
-	if destination == "" {
-		logger.Info("Destination must be specified.")
-		os.Exit(1)
+// client creates the API client; a non-empty resumeIdentity enables the durable queue of that identity
+func (c *commonFlags) client(out *output, resumeIdentity string) *gossipclient.Client {
+	opts := []gossipclient.Option{
+		gossipclient.WithErrorHandler(func(err error) {
+			out.error(err)
+		}),
 	}
-
-	if port == 0 {
-		logger.Info("Port must be specified.")
-		os.Exit(1)
+	if c.token != "" {
+		opts = append(opts, gossipclient.WithToken(c.token))
 	}
-
-	if announce == true && notify == true {
-		logger.Info("Only announce or notify.")
-		os.Exit(1)
+	if resumeIdentity != "" {
+		opts = append(opts, gossipclient.WithResume(resumeIdentity))
 	}
+	return gossipclient.New(c.address, opts...)
+}
 
-	if announce && message == "" {
-		logger.Info("Empty message for announce.")
-		os.Exit(1)
-	}
+// output prints results either as text or as JSON lines on stdout, errors go to stderr
+type output struct {
+	json   bool
+	stdout io.Writer
+	stderr io.Writer
+}
 
-	address := fmt.Sprintf("%s:%d", destination, port)
-	conn, err := net.Dial("tcp", address)
-	if err != nil {
-		logger.ErrorF("Failed to connect to %s: %v\n", address, err)
-		os.Exit(1)
+func (o *output) print(text string, record map[string]interface{}) {
+	if o.json {
+		line, _ := json.Marshal(record)
+		_, _ = fmt.Fprintln(o.stdout, string(line))
+		return
 	}
+	_, _ = fmt.Fprintln(o.stdout, text)
+}
 
-	if announce {
-		sendMessage(conn, enum.GossipAnnounce, createAnnounceMessage(message, uint8(ttl), datatype), logger)
-	} else if notify {
-		handleConnection(conn, datatype, logger)
+func (o *output) error(err error) {
+	if o.json {
+		line, _ := json.Marshal(map[string]interface{}{"event": "error", "error": err.Error()})
+		_, _ = fmt.Fprintln(o.stderr, string(line))
+		return
 	}
+	_, _ = fmt.Fprintf(o.stderr, "error: %v\n", err)
 }
 
-func handleConnection(conn net.Conn, datatype int, logger *logging.Logger) {
-	defer func(conn net.Conn) {
-		err := conn.Close()
-		if err != nil {
-			logger.ErrorF("Failed to close connection: %v\n", err)
-		}
-	}(conn)
-
-	logger.InfoF("Accepted connection from %s\n", conn.RemoteAddr())
-
-	message := createNotifyMessage(datatype)
-	sendMessageAndWaitForResponse(conn, enum.GossipNotify, message, logger)
+func main() {
+	os.Exit(run(os.Args[1:]))
 }
 
-func sendMessageAndWaitForResponse(conn net.Conn, messageType uint16, message []byte, logger *logging.Logger) {
-	var writeBuffer bytes.Buffer
-
-	_ = binary.Write(&writeBuffer, binary.BigEndian, uint16(len(message)+4))
-	_ = binary.Write(&writeBuffer, binary.BigEndian, messageType)
-	_ = binary.Write(&writeBuffer, binary.BigEndian, message)
-	_, err := conn.Write(writeBuffer.Bytes())
-	if err != nil {
-		logger.InfoF("Failed to send message: %v\n", err)
-		return
-	} else {
-		logger.InfoF("Sent %d bytes for message type %d.\n", writeBuffer.Len(), messageType)
+func run(args []string) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage()
+		if len(args) == 0 {
+			return enum.ExitUsage
+		}
+		return enum.ExitOK
 	}
 
-	for {
-		messageBuffer := make([]byte, 1024)
-		_, err := conn.Read(messageBuffer)
-
-		logger.DebugF("Reading from %s\n", conn.RemoteAddr())
+	out := &output{stdout: os.Stdout, stderr: os.Stderr}
+	for _, cmd := range commands {
+		if cmd.name != args[0] {
+			continue
+		}
 
-		if err != nil {
-			if err.Error() == "EOF" {
-				logger.Info("Connection closed by peer.")
-				return
-			}
-			logger.ErrorF("Error reading response: %v\n", err)
-			return
+		err := cmd.run(args[1:], out)
+		switch {
+		case err == nil:
+			return enum.ExitOK
+		case errors.Is(err, flag.ErrHelp):
+			return enum.ExitOK
+		case errors.Is(err, errUsage):
+			out.error(err)
+			return enum.ExitUsage
+		default:
+			out.error(err)
+			return enum.ExitError
 		}
+	}
 
-		reader := bytes.NewReader(messageBuffer)
+	_, _ = fmt.Fprintf(os.Stderr, "unknown command %q\n\n", args[0])
+	usage()
+	return enum.ExitUsage
+}
 
-		var size uint16
-		var messageType uint16
+func usage() {
+	_, _ = fmt.Fprintf(os.Stderr, "Usage: %s <command> [options]\n\nCommands:\n", os.Args[0])
+	for _, cmd := range commands {
+		_, _ = fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.description)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, `
+Run '%s <command> -h' for the options of a command.
 
-		var msg enum.NotificationMsg
+Examples:
+  %s announce -addr 127.0.0.1:9001 -t 1 -ttl 3 -m hello
+  %s subscribe -addr 127.0.0.1:9001 -t 1,2 -json
+  %s validate -addr 127.0.0.1:9001 -id 4711 -invalid
+  %s bench -addr 127.0.0.1:9001 -n 1000 -rate 200
+`, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
+}
 
-		// Read the size
-		if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
-			logger.ErrorF("Error reading size: %v\n", err)
-		}
+// newFlagSet creates the flag set of a subcommand with the common flags registered
+func newFlagSet(name string, flags *commonFlags) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.register(fs)
+	return fs
+}
 
-		// Read the message type
-		if err = binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-			logger.ErrorF("Error reading message type: %v\n", err)
+func parseFlags(fs *flag.FlagSet, args []string, out *output, flags *commonFlags) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
 		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("%w: unexpected arguments %v", errUsage, fs.Args())
+	}
+	out.json = flags.json
+	return nil
+}
 
-		// marshallNotification parses the JSON data manually, and populates the NotificationMsg struct
-		if err := binary.Read(reader, binary.BigEndian, &msg.MessageID); err != nil {
-			logger.ErrorF("Error reading message type:", err)
-		}
+func parseDatatype(value int) (enum.Datatype, error) {
+	if value < 0 || value > 65535 {
+		return 0, fmt.Errorf("%w: datatype %d out of range", errUsage, value)
+	}
+	return enum.Datatype(value), nil
+}
 
-		if err := binary.Read(reader, binary.BigEndian, &msg.DataType); err != nil {
-			logger.ErrorF("Error reading message type:", err)
-		}
+func parseTTL(value int) (uint8, error) {
+	if value < 0 || value > 255 {
+		return 0, fmt.Errorf("%w: ttl %d out of range", errUsage, value)
+	}
+	return uint8(value), nil
+}
 
-		msgBuf := make([]byte, reader.Len())
+func runAnnounce(args []string, out *output) error {
+	var (
+		flags    commonFlags
+		ttl      int
+		datatype int
+		message  string
+	)
 
-		if err := binary.Read(reader, binary.BigEndian, &msgBuf); err != nil {
-			logger.ErrorF("Error reading message type:", err)
-		} else {
-			msg.Data = string(msgBuf)
-		}
+	fs := newFlagSet("announce", &flags)
+	fs.IntVar(&ttl, "ttl", 1, "Number of hops the message is spread")
+	fs.IntVar(&datatype, "t", 1, "Datatype of the message")
+	fs.StringVar(&message, "m", "", "Payload of the message")
+	if err := parseFlags(fs, args, out, &flags); err != nil {
+		return err
+	}
 
-		logger.InfoF("Received message %+v", msg)
+	if message == "" {
+		return fmt.Errorf("%w: empty message for announce", errUsage)
+	}
+	ttlValue, err := parseTTL(ttl)
+	if err != nil {
+		return err
+	}
+	datatypeValue, err := parseDatatype(datatype)
+	if err != nil {
+		return err
 	}
-}
 
-func sendMessage(conn net.Conn, messageType uint16, message []byte, logger *logging.Logger) {
-	var writeBuffer bytes.Buffer
+	client := flags.client(out, "")
+	defer client.Close()
 
-	_ = binary.Write(&writeBuffer, binary.BigEndian, uint16(len(message)+4))
-	_ = binary.Write(&writeBuffer, binary.BigEndian, messageType)
-	_ = binary.Write(&writeBuffer, binary.BigEndian, message)
-	_, err := conn.Write(writeBuffer.Bytes())
-	if err != nil {
-		logger.InfoF("Failed to send message: %v\n", err)
-		return
-	} else {
-		logger.InfoF("Sent %d bytes for message type %d.\n", writeBuffer.Len(), messageType)
+	if err := client.Announce(context.Background(), ttlValue, datatypeValue, []byte(message)); err != nil {
+		return err
 	}
-}
 
-func createAnnounceMessage(message string, ttl uint8, datatype int) []byte {
+	out.print(fmt.Sprintf("announced %d bytes of datatype %d with ttl %d", len(message), datatypeValue, ttlValue), map[string]interface{}{
+		"event":    "announced",
+		"datatype": datatypeValue,
+		"ttl":      ttlValue,
+		"size":     len(message),
+	})
+	return nil
+}
 
+func runSubscribe(args []string, out *output) error {
 	var (
-		TTL      = ttl
-		RESERVED = uint8(1)
-		DATATYPE = enum.Datatype(datatype)
+		flags     commonFlags
+		datatypes string
+		validate  bool
+		resume    string
 	)
 
-	var buffer bytes.Buffer
+	fs := newFlagSet("subscribe", &flags)
+	fs.StringVar(&datatypes, "t", "1", "Comma separated datatypes to subscribe to")
+	fs.BoolVar(&validate, "validate", false, "Validate every received notification as valid")
+	fs.StringVar(&resume, "resume", "", "Module identity whose durable queue is used across reconnects")
+	if err := parseFlags(fs, args, out, &flags); err != nil {
+		return err
+	}
+
+	var subscribed []enum.Datatype
+	for _, entry := range strings.Split(datatypes, ",") {
+		datatype, err := common.ParseUint16(strings.TrimSpace(entry))
+		if err != nil {
+			return fmt.Errorf("%w: invalid datatype %q", errUsage, entry)
+		}
+		subscribed = append(subscribed, enum.Datatype(datatype))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	_ = binary.Write(&buffer, binary.BigEndian, TTL)
-	_ = binary.Write(&buffer, binary.BigEndian, RESERVED)
-	_ = binary.Write(&buffer, binary.BigEndian, DATATYPE)
-	_ = binary.Write(&buffer, binary.BigEndian, []byte(message))
+	client := flags.client(out, resume)
+	defer client.Close()
 
-	return buffer.Bytes()
+	for notification := range client.Subscribe(ctx, subscribed...) {
+		out.print(fmt.Sprintf("notification %d of datatype %d: %s", notification.MessageID, notification.Datatype, notification.Data), map[string]interface{}{
+			"event":      "notification",
+			"message_id": notification.MessageID,
+			"datatype":   notification.Datatype,
+			"data":       string(notification.Data),
+			"sequence":   notification.Sequence,
+		})
+
+		if validate {
+			if err := client.Validate(notification.MessageID, true); err != nil {
+				out.error(err)
+			}
+		}
+	}
+
+	return nil
 }
 
-func createNotifyMessage(datatype int) []byte {
+func runValidate(args []string, out *output) error {
 	var (
-		RESERVED = uint16(1)
-		DATATYPE = enum.Datatype(datatype)
+		flags   commonFlags
+		id      int
+		invalid bool
 	)
 
-	var buffer bytes.Buffer
+	fs := newFlagSet("validate", &flags)
+	fs.IntVar(&id, "id", -1, "ID of the message to validate")
+	fs.BoolVar(&invalid, "invalid", false, "Mark the message invalid instead of valid")
+	if err := parseFlags(fs, args, out, &flags); err != nil {
+		return err
+	}
+
+	if id < 0 || id > 65535 {
+		return fmt.Errorf("%w: message id must be between 0 and 65535", errUsage)
+	}
 
-	_ = binary.Write(&buffer, binary.BigEndian, RESERVED)
-	_ = binary.Write(&buffer, binary.BigEndian, DATATYPE)
+	client := flags.client(out, "")
+	defer client.Close()
 
-	return buffer.Bytes()
+	if err := client.Validate(uint16(id), !invalid); err != nil {
+		return err
+	}
+
+	out.print(fmt.Sprintf("validated message %d as valid=%t", id, !invalid), map[string]interface{}{
+		"event":      "validated",
+		"message_id": id,
+		"valid":      !invalid,
+	})
+	return nil
 }
 
-func unmarshallMessage(conn net.Conn, logger *logging.Logger) error {
-	messageBuffer := make([]byte, 1024)
-	_, err := conn.Read(messageBuffer)
+func runBench(args []string, out *output) error {
+	var (
+		flags    commonFlags
+		count    int
+		rate     float64
+		ttl      int
+		datatype int
+		size     int
+	)
 
-	logger.DebugF("Reading from %s\n", conn.RemoteAddr())
+	fs := newFlagSet("bench", &flags)
+	fs.IntVar(&count, "n", 100, "Number of announce messages to send")
+	fs.Float64Var(&rate, "rate", 10, "Announce messages per second, 0 sends as fast as possible")
+	fs.IntVar(&ttl, "ttl", 1, "Number of hops the messages are spread")
+	fs.IntVar(&datatype, "t", 1, "Datatype of the messages")
+	fs.IntVar(&size, "size", 32, "Payload size in bytes")
+	if err := parseFlags(fs, args, out, &flags); err != nil {
+		return err
+	}
 
+	if count <= 0 || rate < 0 || size <= 0 {
+		return fmt.Errorf("%w: -n and -size must be positive, -rate must not be negative", errUsage)
+	}
+	ttlValue, err := parseTTL(ttl)
 	if err != nil {
-		return fmt.Errorf("Error reading from connection: %v\n", err)
+		return err
+	}
+	datatypeValue, err := parseDatatype(datatype)
+	if err != nil {
+		return err
 	}
 
-	reader := bytes.NewReader(messageBuffer)
-
-	var size uint16
-	var messageType uint16
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	var msg enum.NotificationMsg
+	client := flags.client(out, "")
+	defer client.Close()
 
-	// Read the size
-	if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
-		logger.ErrorF("Error reading size: %v\n", err)
+	var ticker *time.Ticker
+	if rate > 0 {
+		ticker = time.NewTicker(time.Duration(float64(time.Second) / rate))
+		defer ticker.Stop()
 	}
 
-	// Read the message type
-	if err = binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-		return fmt.Errorf("Error reading message type: %v\n", err)
-	}
+	latencies := make([]time.Duration, 0, count)
+	failed := 0
+	start := time.Now()
 
-	// marshallNotification parses the JSON data manually, and populates the NotificationMsg struct
-	if err := binary.Read(reader, binary.BigEndian, &msg.MessageID); err != nil {
-		return fmt.Errorf("Error reading message type:", err)
-	}
+	for i := 0; i < count && ctx.Err() == nil; i++ {
+		if ticker != nil {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+			}
+		}
+		// An interrupt ends the benchmark, announces cut short by it are not failures
+		if ctx.Err() != nil {
+			break
+		}
+
+		payload := []byte(fmt.Sprintf("bench-%d-", i))
+		for len(payload) < size {
+			payload = append(payload, 'x')
+		}
 
-	if err := binary.Read(reader, binary.BigEndian, &msg.DataType); err != nil {
-		return fmt.Errorf("Error reading message type:", err)
+		sent := time.Now()
+		if err := client.Announce(ctx, ttlValue, datatypeValue, payload[:size]); err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			failed++
+			out.error(err)
+			continue
+		}
+		latencies = append(latencies, time.Since(sent))
 	}
 
-	msgBuf := make([]byte, reader.Len())
+	elapsed := time.Since(start)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
 
-	if err := binary.Read(reader, binary.BigEndian, &msgBuf); err != nil {
-		return fmt.Errorf("Error reading message type:", err)
-	} else {
-		msg.Data = string(msgBuf)
+	percentile := func(p float64) time.Duration {
+		if len(latencies) == 0 {
+			return 0
+		}
+		return latencies[int(p*float64(len(latencies)-1))]
 	}
 
-	logger.InfoF("Received message %v", msg)
-
+	achievedRate := float64(len(latencies)) / elapsed.Seconds()
+	out.print(fmt.Sprintf("sent %d, failed %d in %v (%.1f msg/s), latency p50 %v p99 %v", len(latencies), failed, elapsed.Round(time.Millisecond), achievedRate, percentile(0.5), percentile(0.99)), map[string]interface{}{
+		"event":          "bench",
+		"sent":           len(latencies),
+		"failed":         failed,
+		"elapsed_ms":     elapsed.Milliseconds(),
+		"rate":           achievedRate,
+		"p50_latency_us": percentile(0.5).Microseconds(),
+		"p99_latency_us": percentile(0.99).Microseconds(),
+	})
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d announces failed", failed, count)
+	}
 	return nil
 }
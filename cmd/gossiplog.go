@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	var (
+		dir       string
+		datatype  int
+		messageID int64
+		since     string
+		until     string
+		jsonLines bool
+	)
+
+	fs := flag.NewFlagSet("gossip-log", flag.ContinueOnError)
+	fs.StringVar(&dir, "dir", "", "Message log directory of the node (message_log_dir in config.ini)")
+	fs.IntVar(&datatype, "t", -1, "Only show messages of this datatype")
+	fs.Int64Var(&messageID, "id", -1, "Only show messages with this message ID")
+	fs.StringVar(&since, "since", "", "Only show messages received at or after this time (RFC 3339)")
+	fs.StringVar(&until, "until", "", "Only show messages received at or before this time (RFC 3339)")
+	fs.BoolVar(&jsonLines, "json", false, "Export matching entries as JSON lines")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s -dir <message_log_dir> [options]\n\nOptions:\n", os.Args[0])
+		fs.PrintDefaults()
+		_, _ = fmt.Fprintf(os.Stderr, `
+Examples:
+  %s -dir messages -t 1 -since 2024-07-01T12:00:00Z
+  %s -dir messages -id 4711 -json
+`, os.Args[0], os.Args[0])
+	}
+
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return enum.ExitOK
+		}
+		return enum.ExitUsage
+	}
+	if dir == "" || fs.NArg() > 0 {
+		fs.Usage()
+		return enum.ExitUsage
+	}
+
+	query := msglog.Query{}
+	if datatype >= 0 {
+		query.Datatype, query.HasDatatype = int32(datatype), true
+	}
+	if messageID >= 0 {
+		query.MessageID, query.HasMessageID = uint32(messageID), true
+	}
+
+	var err error
+	if since != "" {
+		if query.Since, err = time.Parse(time.RFC3339, since); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error: invalid -since: %v\n", err)
+			return enum.ExitUsage
+		}
+	}
+	if until != "" {
+		if query.Until, err = time.Parse(time.RFC3339, until); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "error: invalid -until: %v\n", err)
+			return enum.ExitUsage
+		}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	err = msglog.Read(dir, query, func(entry msglog.Entry) error {
+		if jsonLines {
+			return encoder.Encode(entry)
+		}
+		_, err := fmt.Printf("%s  id=%-5d type=%-5d ttl=%-3d %-11s from=%s origin=%s  %q\n",
+			entry.Received.Format(time.RFC3339Nano), entry.MessageID, entry.Datatype, entry.TTL, entry.Decision, entry.Sender, entry.Originator, entry.Payload)
+		return err
+	})
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return enum.ExitError
+	}
+
+	return enum.ExitOK
+}
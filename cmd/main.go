@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"os"
 	"os/signal"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/robfig/config"
 
@@ -15,13 +18,21 @@ import (
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
+	protocol "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
 )
 
 type Server struct {
 	apiServer           *api.Server
+	gateway             *api.Gateway
+	grpcServer          *api.GRPCServer
+	webhooks            []*api.Webhook
+	durableQueues       *protocol.DurableQueues
+	messageLog          *msglog.Log
 	p2pServer           *p2p.GossipNode
 	announceMsgChan     chan enum.AnnounceMsg
 	notificationMsgChan chan enum.NotifyMsg
+	dispatcher          *common.Dispatcher
 	datatypeMapper      *common.DatatypeMapper
 }
 
@@ -55,9 +66,15 @@ func NewServer() *Server {
 		logger.FatalF("Can not read from config.ini %v", parseErr)
 	}
 
-	bootstrapperAddress, parseErr := configFile.String("gossip", "bootstrapper_address")
-	if parseErr != nil {
-		logger.FatalF("Can not read bootstrapper_address from config.ini %v", parseErr)
+	// Several bootstrappers of a replicated cluster are separated by commas. The bootstrapper is optional if static
+	// peers are configured or a peer table was saved.
+	bootstrapperURLs := readList(configFile, "bootstrapper_address")
+	staticPeers := readList(configFile, "static_peers")
+	staticSeeds := readList(configFile, "static_seeds")
+
+	peerCachePath := enum.PeerCacheFile
+	if configFile.HasOption("gossip", "peer_cache") {
+		peerCachePath, _ = configFile.String("gossip", "peer_cache")
 	}
 
 	cacheSize, parseErr := configFile.Int("gossip", "cache_size")
@@ -74,38 +91,205 @@ func NewServer() *Server {
 	notificationMsgChan := make(chan enum.NotificationMsg)
 
 	datatypeMapper := common.NewMap()
+	dispatcher := common.NewDispatcher(notificationMsgChan)
 
-	apiServer := api.NewServer(apiAddress, announceMsgChan, notificationMsgChan, datatypeMapper)
+	socketMode := os.FileMode(enum.APISocketMode)
+	if mode, parseErr := configFile.String("gossip", "api_socket_mode"); parseErr == nil && mode != "" {
+		parsedMode, err := strconv.ParseUint(mode, 8, 32)
+		if err != nil {
+			logger.FatalF("Invalid api_socket_mode in config.ini: %v", err)
+		}
+		socketMode = os.FileMode(parsedMode)
+	}
 
-	p2pServer := p2p.NewGossipNode(p2pAddress, []string{}, []string{}, false, announceMsgChan, notificationMsgChan, datatypeMapper, bootstrapperAddress, cacheSize, degree)
-	return &Server{apiServer: apiServer, p2pServer: p2pServer, announceMsgChan: announceMsgChan, datatypeMapper: datatypeMapper}
+	accessControl, err := protocol.LoadAccessControl(configFile)
+	if err != nil {
+		logger.FatalF("Invalid module credentials in config.ini: %v", err)
+	}
 
-}
+	// Durable notification queues are optional and only kept when queue_dir is configured
+	var durableQueues *protocol.DurableQueues
+	if queueDir, parseErr := configFile.String("gossip", "queue_dir"); parseErr == nil && queueDir != "" {
+		maxEntries := enum.DurableQueueMaxEntries
+		if configFile.HasOption("gossip", "queue_max_entries") {
+			if maxEntries, err = configFile.Int("gossip", "queue_max_entries"); err != nil || maxEntries < 1 {
+				logger.FatalF("Invalid queue_max_entries in config.ini")
+			}
+		}
+
+		maxAge := enum.DurableQueueMaxAge
+		if configFile.HasOption("gossip", "queue_max_age") {
+			value, _ := configFile.String("gossip", "queue_max_age")
+			if maxAge, err = time.ParseDuration(value); err != nil {
+				logger.FatalF("Invalid queue_max_age in config.ini: %v", err)
+			}
+		}
+
+		durableQueues = protocol.NewDurableQueues(queueDir, maxEntries, maxAge, dispatcher, datatypeMapper)
+	}
+
+	apiServer := api.NewServer(apiAddress, socketMode, announceMsgChan, dispatcher, datatypeMapper, accessControl, durableQueues)
+
+	// The HTTP gateway is optional and only started when http_address is configured
+	var gateway *api.Gateway
+	if httpAddress, parseErr := configFile.String("gossip", "http_address"); parseErr == nil && httpAddress != "" {
+		gateway = api.NewGateway(httpAddress, announceMsgChan, dispatcher, datatypeMapper, accessControl)
+	}
+
+	// The gRPC service is optional and only started when grpc_address is configured
+	var grpcServer *api.GRPCServer
+	if grpcAddress, parseErr := configFile.String("gossip", "grpc_address"); parseErr == nil && grpcAddress != "" {
+		grpcServer = api.NewGRPCServer(grpcAddress, announceMsgChan, dispatcher, datatypeMapper, accessControl)
+	}
+
+	webhooks, err := api.LoadWebhooks(configFile, dispatcher, datatypeMapper)
+	if err != nil {
+		logger.FatalF("Invalid webhook in config.ini: %v", err)
+	}
+
+	policyEngine, err := protocol.LoadPolicyEngine(configFile, datatypeMapper)
+	if err != nil {
+		logger.FatalF("Invalid validation policy in config.ini: %v", err)
+	}
+
+	// The message log is optional and only written when message_log_dir is configured
+	var messageLog *msglog.Log
+	if messageLogDir, parseErr := configFile.String("gossip", "message_log_dir"); parseErr == nil && messageLogDir != "" {
+		segmentSize := enum.MessageLogSegmentSize
+		if configFile.HasOption("gossip", "message_log_segment_size") {
+			if segmentSize, err = configFile.Int("gossip", "message_log_segment_size"); err != nil || segmentSize < 1 {
+				logger.FatalF("Invalid message_log_segment_size in config.ini")
+			}
+		}
+
+		segments := enum.MessageLogSegments
+		if configFile.HasOption("gossip", "message_log_segments") {
+			if segments, err = configFile.Int("gossip", "message_log_segments"); err != nil || segments < 1 {
+				logger.FatalF("Invalid message_log_segments in config.ini")
+			}
+		}
+
+		if messageLog, err = msglog.Open(messageLogDir, int64(segmentSize), segments); err != nil {
+			logger.FatalF("Failed to open message log: %v", err)
+		}
+	}
+
+	lazyThreshold := enum.LazyPayloadThreshold
+	if configFile.HasOption("gossip", "lazy_payload_threshold") {
+		if lazyThreshold, err = configFile.Int("gossip", "lazy_payload_threshold"); err != nil || lazyThreshold < 0 {
+			logger.FatalF("Invalid lazy_payload_threshold in config.ini")
+		}
+	}
+
+	compressionThreshold := enum.CompressionThreshold
+	if configFile.HasOption("gossip", "compression_threshold") {
+		if compressionThreshold, err = configFile.Int("gossip", "compression_threshold"); err != nil || compressionThreshold < 0 {
+			logger.FatalF("Invalid compression_threshold in config.ini")
+		}
+	}
 
-func (s *Server) Start() {
-	wg := &sync.WaitGroup{}
-	wg.Add(2)
+	batchWindow := enum.BatchWindow
+	if configFile.HasOption("gossip", "batch_window_ms") {
+		windowMs, parseErr := configFile.Int("gossip", "batch_window_ms")
+		if parseErr != nil || windowMs < 0 {
+			logger.FatalF("Invalid batch_window_ms in config.ini")
+		}
+		batchWindow = time.Duration(windowMs) * time.Millisecond
+	}
 
-	go s.apiServer.Start()
-	go s.p2pServer.Start()
+	p2pServer, err := p2p.NewGossipNode(p2pAddress, staticPeers, staticSeeds, slices.Contains(staticSeeds, p2pAddress), announceMsgChan, notificationMsgChan, datatypeMapper, policyEngine, messageLog, bootstrapperURLs, peerCachePath, cacheSize, degree, lazyThreshold, compressionThreshold, batchWindow)
+	if err != nil {
+		logger.FatalF("Failed to create P2P node: %v", err)
+	}
+	return &Server{apiServer: apiServer, gateway: gateway, grpcServer: grpcServer, webhooks: webhooks, durableQueues: durableQueues, messageLog: messageLog, p2pServer: p2pServer, announceMsgChan: announceMsgChan, dispatcher: dispatcher, datatypeMapper: datatypeMapper}
 
-	wg.Wait()
 }
 
-func main() {
-	server := NewServer()
+// readList reads a comma-separated list from the gossip section, which is empty if the option is not set
+func readList(configFile *config.Config, option string) []string {
+	var items []string
+	value, err := configFile.String("gossip", option)
+	if err != nil {
+		return items
+	}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Start runs all servers until ctx is done. Then it stops the API servers first, so that no module can announce
+// anything anymore, and lets the node leave the network afterwards. Once the node stopped, nothing is notified
+// anymore: the webhooks finish their deliveries, the durable queues and the message log are closed.
+func (s *Server) Start(ctx context.Context) {
 	logger := logging.NewCustomLogger()
 
-	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM)
+	go s.dispatcher.Run()
 
-	go server.Start()
+	var apiServers sync.WaitGroup
+	startAPI := func(start func(context.Context)) {
+		apiServers.Add(1)
+		go func() {
+			defer apiServers.Done()
+			start(ctx)
+		}()
+	}
+	startAPI(s.apiServer.Start)
+	if s.gateway != nil {
+		startAPI(s.gateway.Start)
+	}
+	if s.grpcServer != nil {
+		startAPI(s.grpcServer.Start)
+	}
 
-	<-signalChan
+	webhooksCtx, stopWebhooks := context.WithCancel(context.Background())
+	defer stopWebhooks()
+	var webhooks sync.WaitGroup
+	for _, webhook := range s.webhooks {
+		webhooks.Add(1)
+		go func(webhook *api.Webhook) {
+			defer webhooks.Done()
+			webhook.Start(webhooksCtx)
+		}(webhook)
+	}
+
+	nodeCtx, stopNode := context.WithCancel(context.Background())
+	defer stopNode()
+	nodeDone := make(chan struct{})
+	go func() {
+		defer close(nodeDone)
+		s.p2pServer.Start(nodeCtx)
+	}()
+
+	<-ctx.Done()
 	logger.Info("Received termination signal. Initiating shutdown...")
 
-	server.p2pServer.ShutDown()
+	apiServers.Wait()
+	stopNode()
+	<-nodeDone
+
+	stopWebhooks()
+	webhooks.Wait()
+	if s.durableQueues != nil {
+		s.durableQueues.Close()
+	}
+	if s.messageLog != nil {
+		if err := s.messageLog.Close(); err != nil {
+			logger.ErrorF("Failed to close message log: %v", err)
+		}
+	}
+}
+
+func main() {
+	server := NewServer()
+	logger := logging.NewCustomLogger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server.Start(ctx)
 
 	logger.Info("Server shutdown completed.")
-	os.Exit(0)
 }
@@ -0,0 +1,177 @@
+package p2p
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/metrics"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* --------------------------------- BATCHING ---------------------------------- */
+
+// batchOverhead is the space reserved for the fields of the MessageBatch message wrapping the batched messages
+const batchOverhead = 128
+
+var (
+	batchLatency = metrics.Default.Histogram("p2p_batch_latency_seconds",
+		"Time from queueing a gossip message until it was sent to a peer.",
+		[]float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1})
+	batchSize = metrics.Default.Histogram("p2p_batch_size_messages",
+		"Number of gossip messages sent to a peer in one P2P message.",
+		[]float64{1, 2, 4, 8, 16, 32, 64})
+)
+
+// queuedMessage is a gossip message waiting in the queue of a peerSender
+type queuedMessage struct {
+	msg    *pb.GossipMessage
+	queued time.Time
+}
+
+// peerSender sends the gossip messages queued for one peer. Messages queued within the batch window are coalesced
+// into one MessageBatch message, as long as it fits into a P2P message.
+type peerSender struct {
+	node  *GossipNode
+	peer  string
+	queue chan queuedMessage
+}
+
+// enqueue queues msg for peer, starting the sender of the peer if needed. msg must not be changed afterwards.
+func (node *GossipNode) enqueue(peer string, msg *pb.GossipMessage, logger *logging.Logger) {
+	node.sendersMutex.Lock()
+	defer node.sendersMutex.Unlock()
+
+	sender, exists := node.senders[peer]
+	if !exists {
+		sender = &peerSender{node: node, peer: peer, queue: make(chan queuedMessage, enum.SendQueueSize)}
+		node.senders[peer] = sender
+		go sender.run(logger)
+	}
+
+	// Queueing never blocks, so a sender can only stop while no message is being queued
+	select {
+	case sender.queue <- queuedMessage{msg: msg, queued: time.Now()}:
+		node.pendingSends.Add(1)
+	default:
+		logger.ErrorF("Send queue to %s is full, dropping message %d", peer, msg.MessageId)
+	}
+}
+
+// run sends queued messages until the sender was idle for enum.SenderIdleTimeout
+func (s *peerSender) run(logger *logging.Logger) {
+	idle := time.NewTimer(enum.SenderIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case first := <-s.queue:
+			s.flush(s.collect(first, logger), logger)
+			idle.Reset(enum.SenderIdleTimeout)
+		case <-idle.C:
+			s.node.sendersMutex.Lock()
+			if len(s.queue) == 0 {
+				delete(s.node.senders, s.peer)
+				s.node.sendersMutex.Unlock()
+				return
+			}
+			s.node.sendersMutex.Unlock()
+			idle.Reset(enum.SenderIdleTimeout)
+		}
+	}
+}
+
+// collect gathers the messages queued within the batch window after first, up to the size of a P2P message
+func (s *peerSender) collect(first queuedMessage, logger *logging.Logger) []queuedMessage {
+	batch := []queuedMessage{first}
+	if s.node.batchWindow <= 0 {
+		return batch
+	}
+
+	size := batchEntrySize(first.msg)
+	window := time.NewTimer(s.node.batchWindow)
+	defer window.Stop()
+
+	for {
+		select {
+		case next := <-s.queue:
+			nextSize := batchEntrySize(next.msg)
+			if size+nextSize > enum.MaxP2PMessageSize-batchOverhead {
+				// Sent right away, the message that did not fit starts the next batch
+				s.flush(batch, logger)
+				batch, size = nil, 0
+			}
+			batch = append(batch, next)
+			size += nextSize
+		case <-window.C:
+			return batch
+		}
+	}
+}
+
+// batchEntrySize is the size msg takes in a serialized Batch
+func batchEntrySize(msg *pb.GossipMessage) int {
+	n := proto.Size(msg)
+	return n + proto.SizeVarint(uint64(n)) + 1
+}
+
+// flush sends the given messages to the peer, as one MessageBatch message if there is more than one
+func (s *peerSender) flush(batch []queuedMessage, logger *logging.Logger) {
+	defer s.node.pendingSends.Add(-int64(len(batch)))
+
+	msg := batch[0].msg
+	if len(batch) > 1 {
+		messages := make([]*pb.GossipMessage, 0, len(batch))
+		for _, queued := range batch {
+			messages = append(messages, queued.msg)
+		}
+		data, err := proto.Marshal(&pb.Batch{Messages: messages})
+		if err != nil {
+			logger.ErrorF("Failed to serialize batch for %s: %v", s.peer, err)
+			return
+		}
+		msg = &pb.GossipMessage{
+			MessageId: uint32(generate16BitRandomInteger()),
+			From:      s.node.p2pAddress,
+			Type:      int32(enum.MessageBatch),
+			Payload:   data,
+		}
+	}
+
+	if err := s.node.send(s.peer, msg); err != nil {
+		logger.ErrorF("Failed to send %d message(s) to %s: %v", len(batch), s.peer, err)
+		return
+	}
+
+	batchSize.Observe(float64(len(batch)))
+	for _, queued := range batch {
+		batchLatency.Observe(time.Since(queued.queued).Seconds())
+	}
+}
+
+// handleBatch unpacks a MessageBatch message received from sender and handles every batched message on its own.
+// The batch itself carries no proof of work, each batched message is validated instead.
+func (node *GossipNode) handleBatch(msg *pb.GossipMessage, sender string, logger *logging.Logger) {
+	var batch pb.Batch
+	if err := proto.Unmarshal(msg.Payload, &batch); err != nil {
+		logger.ErrorF("Failed to deserialize batch: %v", err)
+		return
+	}
+
+	for _, batched := range batch.Messages {
+		if !pow.Validate(batched) {
+			logger.Error("Failed to validate nonce of batched message")
+			node.logMessage(batched, sender, node.knownPayload(batched), msglog.DecisionDroppedPoW, logger)
+			continue
+		}
+		// Only gossip messages are batched, anything else is not expected here
+		if batched.Type == int32(enum.PayloadRequest) || batched.Type == int32(enum.MessageBatch) {
+			continue
+		}
+		node.handleGossipMessage(batched, sender, logger)
+	}
+}
@@ -0,0 +1,43 @@
+package p2p
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// TestBatching checks that messages queued within the batch window reach the peer in one batch
+// and are handled there one by one.
+func TestBatching(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	receiver := newTestNode(listener.Addr().String(), 0)
+	serveTestNode(t, receiver, listener)
+
+	sender := newTestNode("127.0.0.1:1", 0)
+	sender.batchWindow = 100 * time.Millisecond
+
+	var msgs []*pb.GossipMessage
+	for id := uint32(1); id <= 3; id++ {
+		msg := &pb.GossipMessage{MessageId: id, From: sender.p2pAddress, Type: 1, Payload: []byte("small"), Ttl: 1}
+		pow.CalculateAndAddNonce(msg)
+		msgs = append(msgs, msg)
+	}
+
+	batches := batchSize.Count()
+	for _, msg := range msgs {
+		sender.enqueue(receiver.p2pAddress, msg, logging.NewCustomLogger())
+	}
+
+	assert.Eventually(t, func() bool { return len(receiver.history.since(time.Time{})) == 3 }, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, batches+1, batchSize.Count())
+}
@@ -1,24 +1,135 @@
 package p2p
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 	"time"
 )
 
 /* --------------------------------- BOOTSTRAPPING ---------------------------------- */
 
-func (node *GossipNode) registerWithBootstrapper(p2pAddress string) error {
+// bootstrapClient sends all requests of a node to the bootstrappers, so that none of them hangs on an unresponsive one
+var bootstrapClient = &http.Client{Timeout: enum.BootstrapRequestTimeout}
+
+// tryBootstrappers runs request against the configured bootstrappers, starting with the one that answered last.
+// If all of them fail, it backs off and starts over, up to the given number of rounds, unless ctx is done.
+func (node *GossipNode) tryBootstrappers(ctx context.Context, rounds int, request func(bootstrapURL string) error) error {
 	logger := logging.NewCustomLogger()
-	logger.InfoF("Registering with: %v", node.bootstrapURL)
+	if len(node.bootstrapURLs) == 0 {
+		return fmt.Errorf("no bootstrapper configured")
+	}
+
+	backoff := enum.BootstrapMinBackoff
+	var err error
+	for round := 0; round < rounds; round++ {
+		if round > 0 {
+			logger.InfoF("No bootstrapper reachable, retrying in %v", backoff)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return err
+			}
+			backoff = min(2*backoff, enum.BootstrapMaxBackoff)
+		}
+
+		node.bootstrapMutex.Lock()
+		preferred := node.bootstrapIndex
+		node.bootstrapMutex.Unlock()
+
+		for i := range node.bootstrapURLs {
+			index := (preferred + i) % len(node.bootstrapURLs)
+			if err = request(node.bootstrapURLs[index]); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logger.ErrorF("Bootstrapper %s failed: %v", node.bootstrapURLs[index], err)
+				continue
+			}
+
+			node.bootstrapMutex.Lock()
+			node.bootstrapIndex = index
+			node.bootstrapMutex.Unlock()
+			return nil
+		}
+	}
+	return err
+}
 
-	resp, err := http.PostForm(node.bootstrapURL+"/register", url.Values{"peer": {p2pAddress}})
+// sleepContext waits for d, or fails with the error of ctx if it is done first
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func (node *GossipNode) registerWithBootstrapper(ctx context.Context, p2pAddress string) error {
+	return node.tryBootstrappers(ctx, enum.BootstrapAttempts, func(bootstrapURL string) error {
+		return node.registerAt(ctx, bootstrapURL, p2pAddress)
+	})
+}
+
+// registrationChallenge is the proof-of-work challenge the bootstrapper issues for a registration
+type registrationChallenge struct {
+	Challenge  string `json:"challenge"`
+	Difficulty int    `json:"difficulty"`
+}
+
+// registerAt registers p2pAddress with the bootstrapper at bootstrapURL. It solves the challenge issued for the
+// address and sends the public identity key, with which the bootstrapper verifies the answer to its reachability probe.
+func (node *GossipNode) registerAt(ctx context.Context, bootstrapURL string, p2pAddress string) error {
+	logger := logging.NewCustomLogger()
+	logger.InfoF("Registering with: %v", bootstrapURL)
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, bootstrapURL+"/challenge?peer="+url.QueryEscape(p2pAddress), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := bootstrapClient.Do(request)
+	if err != nil {
+		return err
+	}
+	var challenge registrationChallenge
+	err = json.NewDecoder(resp.Body).Decode(&challenge)
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get registration challenge, status code: %d", resp.StatusCode)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid registration challenge: %w", err)
+	}
+
+	nonce := pow.SolveChallenge(challenge.Challenge+p2pAddress, strings.Repeat("0", challenge.Difficulty))
+
+	form := url.Values{
+		"peer":       {p2pAddress},
+		"challenge":  {challenge.Challenge},
+		"nonce":      {strconv.FormatUint(nonce, 10)},
+		"public_key": {base64.StdEncoding.EncodeToString(node.identityKey.Public().(ed25519.PublicKey))},
+	}
+	request, err = http.NewRequestWithContext(ctx, http.MethodPost, bootstrapURL+"/register", strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err = bootstrapClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -30,16 +141,46 @@ func (node *GossipNode) registerWithBootstrapper(p2pAddress string) error {
 	}(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
-		logger.ErrorF("failed to register with bootstrapper, status code: %d", resp.StatusCode)
-		return nil
+		reason, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("failed to register with bootstrapper, status code: %d: %s", resp.StatusCode, strings.TrimSpace(string(reason)))
 	}
 	return nil
 }
 
-func (node *GossipNode) getInitialPeers() error {
+// answerProbe signs the nonce of a reachability probe together with the address of this node
+func (node *GossipNode) answerProbe(conn net.Conn, msg *pb.GossipMessage, logger *logging.Logger) {
+	signature := ed25519.Sign(node.identityKey, append(msg.Payload, node.p2pAddress...))
+
+	_ = conn.SetWriteDeadline(time.Now().Add(enum.ProbeTimeout))
+	if _, err := conn.Write(signature); err != nil {
+		logger.ErrorF("Failed to answer reachability probe: %v", err)
+	}
+}
+
+func (node *GossipNode) getInitialPeers(ctx context.Context) error {
+	return node.tryBootstrappers(ctx, enum.BootstrapAttempts, func(bootstrapURL string) error {
+		return node.getPeersFrom(ctx, bootstrapURL)
+	})
+}
+
+// getPeersFrom fetches peers and seed nodes from the bootstrapper at bootstrapURL
+func (node *GossipNode) getPeersFrom(ctx context.Context, bootstrapURL string) error {
 
 	logger := logging.NewCustomLogger()
-	resp, err := http.Get(node.bootstrapURL + "/peers")
+
+	// The bootstrapper should not hand out this node itself or peers it is connected to already
+	exclude := []string{node.p2pAddress}
+	node.peersMutex.RLock()
+	for peer := range node.peers {
+		exclude = append(exclude, peer)
+	}
+	node.peersMutex.RUnlock()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, bootstrapURL+"/peers?exclude="+url.QueryEscape(strings.Join(exclude, ",")), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := bootstrapClient.Do(request)
 	if err != nil {
 		return err
 	}
@@ -80,39 +221,100 @@ func (node *GossipNode) getInitialPeers() error {
 			node.seedNodes[seed] = struct{}{}
 		}
 	}
-	node.isSeedNode = contains(peerResponse.SeedNodes, node.p2pAddress)
+	node.isSeedNode = node.staticSeed || contains(peerResponse.SeedNodes, node.p2pAddress)
 
 	if node.isSeedNode {
 		logger.InfoF("This node (%s) is a SeedNode", node.p2pAddress)
 	} else {
 		logger.InfoF("This node (%s) is NOT a SeedNode", node.p2pAddress)
 	}
-	logger.InfoF("Peers list updated successfully with partial peers and seed nodes from: %v", node.bootstrapURL)
+	logger.InfoF("Peers list updated successfully with partial peers and seed nodes from: %v", bootstrapURL)
 	return nil
 }
 
 // TODO: remove this later cuz not needed after nodes automatically exchnage peerlist
-func (node *GossipNode) periodicBootstrapping() {
+func (node *GossipNode) periodicBootstrapping(ctx context.Context) {
 	ticker := time.NewTicker(enum.PeriodicBootstrapTicker)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			logger := logging.NewCustomLogger()
 
-			if err := node.getInitialPeers(); err != nil {
+			if err := node.getInitialPeers(ctx); err != nil {
 				logger.ErrorF("Failed to fetch peers from bootstrapper: %v", err)
 			}
 		}
 	}
 }
-func (node *GossipNode) sendHeartbeat() {
+
+// status reports the current state of the node as the given address. The load is the fill level of the send queues.
+func (node *GossipNode) status(address string) enum.NodeStatus {
+	status := enum.NodeStatus{
+		Peer:            address,
+		MessagesRelayed: relayedMessages.Value(),
+		Version:         enum.Version,
+		Features:        node.features(),
+		Neighbours:      []string{},
+	}
+
+	node.peersMutex.RLock()
+	status.PeerCount = len(node.peers)
+	for peer := range node.peers {
+		if peer != address {
+			status.Neighbours = append(status.Neighbours, peer)
+		}
+	}
+	status.Seed = node.isSeedNode
+	node.peersMutex.RUnlock()
+
+	node.messageIDCacheMutex.Lock()
+	status.CacheSize = len(node.messageIDCache)
+	node.messageIDCacheMutex.Unlock()
+
+	node.sendersMutex.Lock()
+	queued := 0
+	for _, sender := range node.senders {
+		queued += len(sender.queue)
+	}
+	if len(node.senders) > 0 {
+		status.Load = float64(queued) / float64(len(node.senders)*enum.SendQueueSize)
+	}
+	node.sendersMutex.Unlock()
+
+	return status
+}
+
+// features lists the optional protocol features this node has enabled
+func (node *GossipNode) features() []string {
+	features := []string{"history", "fragmentation"}
+	if node.lazyThreshold > 0 {
+		features = append(features, "lazy_payload")
+	}
+	if node.compression.threshold > 0 {
+		features = append(features, "compression")
+	}
+	if node.batchWindow > 0 {
+		features = append(features, "batching")
+	}
+	return features
+}
+
+func (node *GossipNode) sendHeartbeat(ctx context.Context) {
+	if len(node.bootstrapURLs) == 0 {
+		return
+	}
+
 	ticker := time.NewTicker(enum.HeartbeatTicker)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			logger := logging.NewCustomLogger()
 
@@ -128,34 +330,59 @@ func (node *GossipNode) sendHeartbeat() {
 
 			address := net.JoinHostPort(host, port)
 
-			heartBeatURL := fmt.Sprintf("%s/heartbeat?peer=%s", node.bootstrapURL, address)
-			resp, err := http.Get(heartBeatURL)
+			body, err := json.Marshal(node.status(address))
 			if err != nil {
-				logger.ErrorF("Failed to send heartbeat to bootstrapper: %v", err)
+				logger.ErrorF("Failed to encode heartbeat: %v", err)
 				continue
 			}
 
-			defer func(Body io.ReadCloser) {
-				if err := Body.Close(); err != nil {
+			err = node.tryBootstrappers(ctx, 1, func(bootstrapURL string) error {
+				request, err := http.NewRequestWithContext(ctx, http.MethodPost, bootstrapURL+"/heartbeat", bytes.NewReader(body))
+				if err != nil {
+					return err
+				}
+				request.Header.Set("Content-Type", "application/json")
+				resp, err := bootstrapClient.Do(request)
+				if err != nil {
+					return err
+				}
+				if err := resp.Body.Close(); err != nil {
 					logger.ErrorF("Failed to close heartbeat response body: %v", err)
 				}
-			}(resp.Body)
 
-			if resp.StatusCode != http.StatusOK {
-				logger.ErrorF("Heartbeat response status code not OK: %d", resp.StatusCode)
-			} else {
-				logger.DebugF("Heartbeat successfully sent to bootstrapper for peer: %s", address)
+				switch resp.StatusCode {
+				case http.StatusOK:
+					logger.DebugF("Heartbeat successfully sent to %s for peer: %s", bootstrapURL, address)
+					return nil
+				case http.StatusBadRequest:
+					// The bootstrapper lost or dropped the registration, e.g. after a restart
+					logger.InfoF("Bootstrapper %s does not know peer %s, registering again", bootstrapURL, address)
+					return node.registerAt(ctx, bootstrapURL, node.p2pAddress)
+				default:
+					return fmt.Errorf("heartbeat response status code not OK: %d", resp.StatusCode)
+				}
+			})
+			if err != nil && ctx.Err() == nil {
+				logger.ErrorF("Failed to send heartbeat to any bootstrapper: %v", err)
 			}
 		}
 	}
 }
 
 func (node *GossipNode) PrintPeerLists() {
-	logger := logging.NewCustomLogger()
-
 	node.peersMutex.RLock()
 	defer node.peersMutex.RUnlock()
 
+	node.printPeerLists()
+}
+
+// printPeerLists prints the peers and seed nodes. It must be called with peersMutex held.
+func (node *GossipNode) printPeerLists() {
+	logger := logging.NewCustomLogger()
+
+	node.seedNodesMutex.RLock()
+	defer node.seedNodesMutex.RUnlock()
+
 	// Print peers list
 	logger.Info("Node's network view:")
 	if len(node.peers) == 0 {
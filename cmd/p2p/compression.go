@@ -0,0 +1,121 @@
+package p2p
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+
+	"github.com/golang/protobuf/proto"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/metrics"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* --------------------------------- COMPRESSION ---------------------------------- */
+
+// encodingGzip is the only payload encoding supported so far
+const encodingGzip = "gzip"
+
+var (
+	compressedMessages = metrics.Default.Counter("p2p_compressed_messages_total",
+		"Outgoing P2P messages sent with a compressed payload.")
+	compressionBytesSaved = metrics.Default.Counter("p2p_compression_bytes_saved_total",
+		"Payload bytes saved by compressing outgoing P2P messages.")
+)
+
+// compression negotiates payload compression with peers. Every message a node sends lists the encodings it can
+// decode, and payloads are only compressed for peers that listed the encoding in their latest message.
+type compression struct {
+	threshold     int
+	mu            sync.RWMutex
+	peerEncodings map[string][]string
+}
+
+// newCompression compresses payloads larger than threshold bytes; 0 disables compression
+func newCompression(threshold int) *compression {
+	return &compression{threshold: threshold, peerEncodings: make(map[string][]string)}
+}
+
+// learn records the encodings accepted by the node that sent msg
+func (c *compression) learn(msg *pb.GossipMessage) {
+	if msg.Sender == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peerEncodings[msg.Sender] = slices.Clone(msg.AcceptEncodings)
+}
+
+func (c *compression) accepts(peer string, encoding string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return slices.Contains(c.peerEncodings[peer], encoding)
+}
+
+// encode returns a copy of msg as it is sent from sender to peer: with the hop fields set, and with a
+// compressed payload if the peer accepts it and compression saves space. msg itself is left unchanged.
+func (c *compression) encode(msg *pb.GossipMessage, sender string, peer string) (*pb.GossipMessage, error) {
+	wireMsg := proto.Clone(msg).(*pb.GossipMessage)
+	wireMsg.Sender = sender
+	if c.threshold <= 0 {
+		return wireMsg, nil
+	}
+	wireMsg.AcceptEncodings = []string{encodingGzip}
+
+	if len(msg.Payload) <= c.threshold || !c.accepts(peer, encodingGzip) {
+		return wireMsg, nil
+	}
+
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	if _, err := writer.Write(msg.Payload); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	if compressed.Len() >= len(msg.Payload) {
+		return wireMsg, nil
+	}
+
+	wireMsg.Payload = compressed.Bytes()
+	wireMsg.Encoding = encodingGzip
+	compressedMessages.Add(1)
+	compressionBytesSaved.Add(uint64(len(msg.Payload) - compressed.Len()))
+	return wireMsg, nil
+}
+
+// decode uncompresses the payload of a received msg and clears the hop fields, which restores the message
+// the proof of work was computed over
+func decode(msg *pb.GossipMessage) error {
+	encoding := msg.Encoding
+	msg.Encoding, msg.AcceptEncodings, msg.Sender = "", nil, ""
+
+	switch encoding {
+	case "":
+		return nil
+	case encodingGzip:
+		reader, err := gzip.NewReader(bytes.NewReader(msg.Payload))
+		if err != nil {
+			return err
+		}
+		defer reader.Close()
+
+		// Uncompressed messages are bound by the same limit as messages sent without compression
+		payload, err := io.ReadAll(io.LimitReader(reader, enum.MaxP2PMessageSize+1))
+		if err != nil {
+			return err
+		}
+		if len(payload) > enum.MaxP2PMessageSize {
+			return fmt.Errorf("uncompressed payload exceeds %d bytes", enum.MaxP2PMessageSize)
+		}
+		msg.Payload = payload
+		return nil
+	default:
+		return fmt.Errorf("unsupported payload encoding %q", encoding)
+	}
+}
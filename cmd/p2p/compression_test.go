@@ -0,0 +1,44 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// TestCompressionNegotiation checks that payloads are only compressed for peers that announced gzip
+// and that the decoded message still carries a valid proof of work.
+func TestCompressionNegotiation(t *testing.T) {
+	c := newCompression(16)
+	payload := bytes.Repeat([]byte(`{"key":"value"}`), 50)
+	msg := &pb.GossipMessage{MessageId: 7, From: "127.0.0.1:7001", Type: 1, Payload: payload, Ttl: 3}
+	pow.CalculateAndAddNonce(msg)
+
+	// The peer has not announced any encoding yet
+	wireMsg, err := c.encode(msg, "127.0.0.1:7001", "127.0.0.1:7002")
+	require.NoError(t, err)
+	assert.Empty(t, wireMsg.Encoding)
+	assert.Equal(t, []string{encodingGzip}, wireMsg.AcceptEncodings)
+
+	c.learn(&pb.GossipMessage{Sender: "127.0.0.1:7002", AcceptEncodings: []string{encodingGzip}})
+	saved := compressionBytesSaved.Value()
+	wireMsg, err = c.encode(msg, "127.0.0.1:7001", "127.0.0.1:7002")
+	require.NoError(t, err)
+	assert.Equal(t, encodingGzip, wireMsg.Encoding)
+	assert.Less(t, len(wireMsg.Payload), len(payload))
+	assert.Equal(t, uint64(len(payload)-len(wireMsg.Payload)), compressionBytesSaved.Value()-saved)
+	assert.Equal(t, payload, msg.Payload)
+
+	require.NoError(t, decode(wireMsg))
+	assert.Equal(t, payload, wireMsg.Payload)
+	assert.Empty(t, wireMsg.Sender)
+	assert.True(t, pow.Validate(wireMsg))
+
+	wireMsg.Encoding = "br"
+	assert.Error(t, decode(wireMsg))
+}
@@ -0,0 +1,167 @@
+package p2p
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+)
+
+/* --------------------------------- DISCOVERY ---------------------------------- */
+
+// peerTable is the peer table a node saves on shutdown, so that it can rejoin without a bootstrapper
+type peerTable struct {
+	SavedAt time.Time `json:"saved_at"`
+	Peers   []string  `json:"peers"`
+	Seeds   []string  `json:"seeds"`
+}
+
+// discoverySource is one way of finding peers; discover adds the peers it found to the node and fails if the
+// source could not be used
+type discoverySource struct {
+	name     string
+	discover func(ctx context.Context) error
+}
+
+// discoverySources lists the sources tried in turn when joining: the bootstrappers, then the peer table saved
+// on the last shutdown. Static peers from the configuration are known from the start.
+func (node *GossipNode) discoverySources() []discoverySource {
+	var sources []discoverySource
+	if len(node.bootstrapURLs) > 0 {
+		sources = append(sources, discoverySource{name: "bootstrapper", discover: func(ctx context.Context) error {
+			return node.tryBootstrappers(ctx, 1, func(bootstrapURL string) error {
+				return node.getPeersFrom(ctx, bootstrapURL)
+			})
+		}})
+	}
+	if node.peerCachePath != "" {
+		sources = append(sources, discoverySource{name: "peer cache", discover: func(context.Context) error {
+			return node.loadPeerTable()
+		}})
+	}
+	return sources
+}
+
+// discoverPeers tries the discovery sources in turn until one of them answers. If none does, it backs off and
+// starts over, up to enum.BootstrapAttempts rounds, unless static peers are configured. It fails only if the node
+// knows no peer at all by then, or if ctx is done before.
+func (node *GossipNode) discoverPeers(ctx context.Context, logger *logging.Logger) error {
+	sources := node.discoverySources()
+
+	backoff := enum.BootstrapMinBackoff
+	for round := 0; round < enum.BootstrapAttempts && len(sources) > 0; round++ {
+		if round > 0 {
+			// Static peers are enough to join, waiting for other sources would only delay it
+			if node.requireKnownPeers() == nil {
+				return nil
+			}
+			logger.InfoF("No discovery source answered, retrying in %v", backoff)
+			if err := sleepContext(ctx, backoff); err != nil {
+				return err
+			}
+			backoff = min(2*backoff, enum.BootstrapMaxBackoff)
+		}
+
+		for _, source := range sources {
+			if err := source.discover(ctx); err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				logger.ErrorF("Discovery via %s failed: %v", source.name, err)
+				continue
+			}
+			logger.InfoF("Discovered peers via %s", source.name)
+			return nil
+		}
+	}
+	return node.requireKnownPeers()
+}
+
+// requireKnownPeers fails if the node knows neither peers nor seed nodes
+func (node *GossipNode) requireKnownPeers() error {
+	node.peersMutex.RLock()
+	defer node.peersMutex.RUnlock()
+	node.seedNodesMutex.RLock()
+	defer node.seedNodesMutex.RUnlock()
+
+	if len(node.peers) == 0 && len(node.seedNodes) == 0 {
+		return fmt.Errorf("no peers known")
+	}
+	return nil
+}
+
+// loadPeerTable adds the peers saved on the last shutdown, unless the table is older than enum.PeerCacheMaxAge
+func (node *GossipNode) loadPeerTable() error {
+	data, err := os.ReadFile(node.peerCachePath)
+	if err != nil {
+		return err
+	}
+
+	var table peerTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return fmt.Errorf("invalid peer cache: %w", err)
+	}
+	if time.Since(table.SavedAt) > enum.PeerCacheMaxAge {
+		return fmt.Errorf("peer cache saved at %s is outdated", table.SavedAt.Format(time.RFC3339))
+	}
+	if len(table.Peers) == 0 && len(table.Seeds) == 0 {
+		return fmt.Errorf("peer cache is empty")
+	}
+
+	node.peersMutex.Lock()
+	defer node.peersMutex.Unlock()
+	node.seedNodesMutex.Lock()
+	defer node.seedNodesMutex.Unlock()
+
+	for _, peer := range table.Peers {
+		if peer != node.p2pAddress && len(node.peers) < node.degree {
+			node.peers[peer] = struct{}{}
+		}
+	}
+	for _, seed := range table.Seeds {
+		if seed != node.p2pAddress {
+			node.seedNodes[seed] = struct{}{}
+		}
+	}
+	return nil
+}
+
+// savePeerTable writes the current peers and seed nodes to the peer cache, replacing it atomically
+func (node *GossipNode) savePeerTable() error {
+	if node.peerCachePath == "" {
+		return nil
+	}
+
+	table := peerTable{SavedAt: time.Now()}
+	node.peersMutex.RLock()
+	for peer := range node.peers {
+		if peer != node.p2pAddress {
+			table.Peers = append(table.Peers, peer)
+		}
+	}
+	node.peersMutex.RUnlock()
+	node.seedNodesMutex.RLock()
+	for seed := range node.seedNodes {
+		table.Seeds = append(table.Seeds, seed)
+	}
+	node.seedNodesMutex.RUnlock()
+
+	data, err := json.Marshal(table)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := node.peerCachePath + ".tmp"
+	if err := os.MkdirAll(filepath.Dir(node.peerCachePath), 0700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, node.peerCachePath)
+}
@@ -0,0 +1,64 @@
+package p2p
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+)
+
+func TestPeerTableRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers", "peer-cache.json")
+
+	saved := newTestNode("127.0.0.1:7000", 0)
+	saved.peerCachePath = path
+	saved.peers["127.0.0.1:7001"] = struct{}{}
+	saved.seedNodes["127.0.0.1:7002"] = struct{}{}
+	require.NoError(t, saved.savePeerTable())
+
+	loaded := newTestNode("127.0.0.1:7001", 0)
+	loaded.peerCachePath = path
+	require.NoError(t, loaded.discoverPeers(context.Background(), logging.NewCustomLogger()))
+
+	// The node does not add itself
+	assert.Empty(t, loaded.peers)
+	assert.Contains(t, loaded.seedNodes, "127.0.0.1:7002")
+}
+
+func TestOutdatedPeerTableIsIgnored(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peer-cache.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"saved_at":"`+time.Now().Add(-48*time.Hour).Format(time.RFC3339)+`","peers":["127.0.0.1:7001"]}`), 0600))
+
+	node := newTestNode("127.0.0.1:7000", 0)
+	node.peerCachePath = path
+	assert.Error(t, node.loadPeerTable())
+	assert.Empty(t, node.peers)
+}
+
+func TestDiscoverPeersStopsWhenCancelled(t *testing.T) {
+	// The bootstrapper accepts the request but never answers
+	bootstrapper := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer bootstrapper.Close()
+
+	node := newTestNode("127.0.0.1:7000", 0)
+	node.bootstrapURLs = []string{bootstrapper.URL}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := node.discoverPeers(ctx, logging.NewCustomLogger())
+
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), enum.BootstrapMinBackoff)
+}
@@ -0,0 +1,184 @@
+package p2p
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* --------------------------------- FRAGMENTATION ---------------------------------- */
+
+// nonceOverhead is the space reserved for the nonce, which is only added right before sending
+const nonceOverhead = 11
+
+// fragment splits msg into MessageFragment messages if it does not fit into one P2P message.
+// Messages that fit are returned unchanged.
+func fragment(msg *pb.GossipMessage) ([]*pb.GossipMessage, error) {
+	if proto.Size(msg)+nonceOverhead <= enum.MaxP2PMessageSize {
+		return []*pb.GossipMessage{msg}, nil
+	}
+	if len(msg.Payload) > enum.MaxFragmentedPayloadSize {
+		return nil, fmt.Errorf("payload of %d bytes exceeds %d bytes", len(msg.Payload), enum.MaxFragmentedPayloadSize)
+	}
+
+	count := (len(msg.Payload) + enum.FragmentChunkSize - 1) / enum.FragmentChunkSize
+	messageHash := contentHash(msg.Payload)
+	fragments := make([]*pb.GossipMessage, 0, count)
+	for index := 0; index < count; index++ {
+		chunk := msg.Payload[index*enum.FragmentChunkSize : min((index+1)*enum.FragmentChunkSize, len(msg.Payload))]
+
+		data, err := proto.Marshal(&pb.Fragment{
+			MessageId:   msg.MessageId,
+			Type:        msg.Type,
+			Index:       uint32(index),
+			Count:       uint32(count),
+			Chunk:       chunk,
+			ChunkHash:   contentHash(chunk),
+			MessageHash: messageHash,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		fragments = append(fragments, &pb.GossipMessage{
+			MessageId: uint32(generate16BitRandomInteger()),
+			From:      msg.From,
+			Type:      int32(enum.MessageFragment),
+			Payload:   data,
+			Ttl:       msg.Ttl,
+		})
+	}
+
+	return fragments, nil
+}
+
+// pendingMessage collects the fragments of one message
+type pendingMessage struct {
+	started     time.Time
+	sender      string
+	msgType     int32
+	messageHash []byte
+	chunks      [][]byte
+	fragments   []*pb.GossipMessage
+	received    int
+	bytes       int
+}
+
+// reassembler rebuilds messages from their fragments and drops incomplete ones after timeout. Every peer may start
+// at most maxPerPeer incomplete messages, and at most maxBytes of fragments are buffered in total.
+type reassembler struct {
+	mu         sync.Mutex
+	timeout    time.Duration
+	maxPerPeer int
+	maxBytes   int
+	pending    map[string]*pendingMessage
+	// perPeer counts the incomplete messages started by each sending peer
+	perPeer map[string]int
+	bytes   int
+}
+
+func newReassembler(timeout time.Duration, maxPerPeer int, maxBytes int) *reassembler {
+	return &reassembler{
+		timeout:    timeout,
+		maxPerPeer: maxPerPeer,
+		maxBytes:   maxBytes,
+		pending:    make(map[string]*pendingMessage),
+		perPeer:    make(map[string]int),
+	}
+}
+
+// remove forgets the pending message stored under key
+func (r *reassembler) remove(key string, pending *pendingMessage) {
+	delete(r.pending, key)
+	r.bytes -= pending.bytes
+	r.perPeer[pending.sender]--
+	if r.perPeer[pending.sender] <= 0 {
+		delete(r.perPeer, pending.sender)
+	}
+}
+
+// add adds a fragment received in msg from sender. Once all fragments of a message arrived and the reassembled
+// payload matches the message hash, it returns the reassembled message together with the fragment messages in order,
+// so they can be forwarded.
+func (r *reassembler) add(msg *pb.GossipMessage, sender string, logger *logging.Logger) (*pb.GossipMessage, []*pb.GossipMessage, error) {
+	var f pb.Fragment
+	if err := proto.Unmarshal(msg.Payload, &f); err != nil {
+		return nil, nil, fmt.Errorf("invalid fragment: %w", err)
+	}
+
+	maxCount := uint32((enum.MaxFragmentedPayloadSize + enum.FragmentChunkSize - 1) / enum.FragmentChunkSize)
+	if f.Count == 0 || f.Count > maxCount || f.Index >= f.Count {
+		return nil, nil, fmt.Errorf("fragment %d of %d is out of range", f.Index, f.Count)
+	}
+	if len(f.Chunk) > enum.FragmentChunkSize || !bytes.Equal(contentHash(f.Chunk), f.ChunkHash) {
+		return nil, nil, fmt.Errorf("fragment %d of message %d failed the integrity check", f.Index, f.MessageId)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for key, pending := range r.pending {
+		if now.Sub(pending.started) > r.timeout {
+			logger.InfoF("Dropping incomplete message %s after %d of %d fragments", key, pending.received, len(pending.chunks))
+			r.remove(key, pending)
+		}
+	}
+
+	key := fmt.Sprintf("%s/%d", msg.From, f.MessageId)
+	pending, exists := r.pending[key]
+	if !exists {
+		if r.perPeer[sender] >= r.maxPerPeer {
+			return nil, nil, fmt.Errorf("%s has %d incomplete messages already", sender, r.perPeer[sender])
+		}
+		pending = &pendingMessage{
+			started:     now,
+			sender:      sender,
+			msgType:     f.Type,
+			messageHash: f.MessageHash,
+			chunks:      make([][]byte, f.Count),
+			fragments:   make([]*pb.GossipMessage, f.Count),
+		}
+		r.pending[key] = pending
+		r.perPeer[sender]++
+	}
+	if int(f.Count) != len(pending.chunks) || f.Type != pending.msgType || !bytes.Equal(f.MessageHash, pending.messageHash) {
+		return nil, nil, fmt.Errorf("fragment %d does not match message %s", f.Index, key)
+	}
+	if pending.chunks[f.Index] != nil {
+		return nil, nil, nil
+	}
+	if r.bytes+len(f.Chunk) > r.maxBytes {
+		return nil, nil, fmt.Errorf("fragment buffer of %d bytes is full", r.maxBytes)
+	}
+
+	pending.chunks[f.Index] = f.Chunk
+	pending.fragments[f.Index] = msg
+	pending.received++
+	pending.bytes += len(f.Chunk)
+	r.bytes += len(f.Chunk)
+	if pending.received < len(pending.chunks) {
+		return nil, nil, nil
+	}
+
+	r.remove(key, pending)
+	payload := bytes.Join(pending.chunks, nil)
+	if !bytes.Equal(contentHash(payload), pending.messageHash) {
+		return nil, nil, fmt.Errorf("message %s failed the integrity check after reassembly", key)
+	}
+	original := &pb.GossipMessage{
+		MessageId: f.MessageId,
+		From:      msg.From,
+		Type:      f.Type,
+		Payload:   payload,
+		Ttl:       msg.Ttl,
+	}
+	return original, pending.fragments, nil
+}
@@ -0,0 +1,108 @@
+package p2p
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// TestFragmentReassembly checks that a large message is split into fragments that fit into a P2P message
+// and reassembled in any order, and that corrupted fragments are rejected.
+func TestFragmentReassembly(t *testing.T) {
+	logger := logging.NewCustomLogger()
+	payload := bytes.Repeat([]byte("0123456789"), 1000)
+	msg := &pb.GossipMessage{MessageId: 42, From: "127.0.0.1:7001", Type: 1, Payload: payload, Ttl: 5}
+
+	fragments, err := fragment(msg)
+	require.NoError(t, err)
+	require.Len(t, fragments, 5)
+	for _, fragmentMsg := range fragments {
+		data, err := serialize(fragmentMsg)
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(data)+nonceOverhead, enum.MaxP2PMessageSize)
+		assert.Equal(t, int32(enum.MessageFragment), fragmentMsg.Type)
+	}
+
+	r := newReassembler(time.Minute, 1, 1024*1024)
+	for i := len(fragments) - 1; i > 0; i-- {
+		original, _, err := r.add(fragments[i], "127.0.0.1:7002", logger)
+		require.NoError(t, err)
+		assert.Nil(t, original)
+	}
+	original, forwarded, err := r.add(fragments[0], "127.0.0.1:7002", logger)
+	require.NoError(t, err)
+	require.NotNil(t, original)
+	assert.Equal(t, payload, original.Payload)
+	assert.Equal(t, msg.MessageId, original.MessageId)
+	assert.Equal(t, msg.Type, original.Type)
+	assert.Equal(t, fragments, forwarded)
+
+	// Small messages are sent as they are
+	small := &pb.GossipMessage{MessageId: 43, Type: 1, Payload: []byte("small")}
+	fragments, err = fragment(small)
+	require.NoError(t, err)
+	assert.Equal(t, []*pb.GossipMessage{small}, fragments)
+
+	fragments, err = fragment(msg)
+	require.NoError(t, err)
+	var f pb.Fragment
+	require.NoError(t, proto.Unmarshal(fragments[0].Payload, &f))
+	f.Chunk[0] ^= 0xff
+	fragments[0].Payload, err = proto.Marshal(&f)
+	require.NoError(t, err)
+	_, _, err = r.add(fragments[0], "127.0.0.1:7002", logger)
+	assert.Error(t, err)
+}
+
+// TestReassemblerBounds checks the limits on incomplete messages and that a reassembled message whose payload does not
+// match its message hash is dropped.
+func TestReassemblerBounds(t *testing.T) {
+	logger := logging.NewCustomLogger()
+	large := func(id uint32) []*pb.GossipMessage {
+		fragments, err := fragment(&pb.GossipMessage{MessageId: id, From: "127.0.0.1:7001", Type: 1, Payload: bytes.Repeat([]byte{byte(id)}, 3*enum.FragmentChunkSize)})
+		require.NoError(t, err)
+		return fragments
+	}
+
+	r := newReassembler(time.Minute, 1, 3*enum.FragmentChunkSize)
+	_, _, err := r.add(large(1)[0], "127.0.0.1:7002", logger)
+	require.NoError(t, err)
+	_, _, err = r.add(large(2)[0], "127.0.0.1:7002", logger)
+	assert.Error(t, err, "a peer may only start one incomplete message")
+	_, _, err = r.add(large(2)[0], "127.0.0.1:7003", logger)
+	require.NoError(t, err)
+	_, _, err = r.add(large(2)[1], "127.0.0.1:7003", logger)
+	require.NoError(t, err)
+	_, _, err = r.add(large(1)[1], "127.0.0.1:7002", logger)
+	assert.Error(t, err, "the buffer holds three chunks")
+
+	// Every chunk is intact, but the message hash belongs to another payload
+	r = newReassembler(time.Minute, 1, 1024*1024)
+	fragments := large(3)
+	for i, fragmentMsg := range fragments {
+		var f pb.Fragment
+		require.NoError(t, proto.Unmarshal(fragmentMsg.Payload, &f))
+		f.MessageHash = contentHash([]byte("other"))
+		fragmentMsg.Payload, err = proto.Marshal(&f)
+		require.NoError(t, err)
+
+		original, _, err := r.add(fragmentMsg, "127.0.0.1:7002", logger)
+		assert.Nil(t, original)
+		if i < len(fragments)-1 {
+			require.NoError(t, err)
+		} else {
+			assert.Error(t, err)
+		}
+	}
+	assert.Empty(t, r.pending)
+	assert.Zero(t, r.bytes)
+	assert.Empty(t, r.perPeer)
+}
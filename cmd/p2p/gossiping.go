@@ -1,6 +1,7 @@
 package p2p
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
@@ -12,11 +13,15 @@ import (
 	"time"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/metrics"
 	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
 )
 
 /* --------------------------------- GOSSIPPING ---------------------------------- */
 
+var relayedMessages = metrics.Default.Counter("p2p_relayed_messages_total",
+	"Received gossip messages this node accepted and forwarded to its peers.")
+
 func (node *GossipNode) gossip(msg *pb.GossipMessage, logger *logging.Logger) {
 	pow.CalculateAndAddNonce(msg)
 
@@ -46,22 +51,18 @@ func (node *GossipNode) gossip(msg *pb.GossipMessage, logger *logging.Logger) {
 	for i := 0; i < fanout; i++ {
 		peer := peerList[i]
 		//logger.InfoF("Gossiping with: %s", peer)
-		go func(peer string) {
-			if err := send(peer, msg); err != nil {
-				logger.ErrorF("Failed to send message to %s: %v", peer, err)
-			} else {
-				//logger.InfoF("Message sent to %s", peer)
-			}
-		}(peer)
+		node.enqueue(peer, msg, logger)
 	}
 }
 
-func (node *GossipNode) periodicPeerListRequest() {
+func (node *GossipNode) periodicPeerListRequest(ctx context.Context) {
 	ticker := time.NewTicker(node.gossipInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
 			logger := logging.NewCustomLogger()
 
@@ -128,7 +129,7 @@ func (node *GossipNode) requestPeerList(targetPeer string, logger *logging.Logge
 	}
 	pow.CalculateAndAddNonce(requestMsg)
 
-	err := send(targetPeer, requestMsg)
+	err := node.send(targetPeer, requestMsg)
 	if err != nil {
 		logger.ErrorF("Failed to request peer list from %s: %v", targetPeer, err)
 	} else {
@@ -160,7 +161,7 @@ func (node *GossipNode) respondWithPeerList(targetPeer string, logger *logging.L
 	}
 	pow.CalculateAndAddNonce(responseMsg)
 
-	err = send(targetPeer, responseMsg)
+	err = node.send(targetPeer, responseMsg)
 	if err != nil {
 		logger.ErrorF("Failed to send peer list to %s: %v", targetPeer, err)
 	} else {
@@ -171,6 +172,7 @@ func (node *GossipNode) respondWithPeerList(targetPeer string, logger *logging.L
 func (node *GossipNode) updateByPeerListResponse(receivedPeers []string, logger *logging.Logger) {
 	node.peersMutex.Lock()
 	defer node.peersMutex.Unlock()
+	defer node.catchUpIfReconnected(len(node.peers), logger)
 	for _, peer := range receivedPeers {
 		if _, exists := node.peers[peer]; !exists {
 			node.peers[peer] = struct{}{}
@@ -181,7 +183,7 @@ func (node *GossipNode) updateByPeerListResponse(receivedPeers []string, logger
 		}
 	}
 	node.removeNodeByExceedDegree(logger)
-	node.PrintPeerLists()
+	node.printPeerLists()
 }
 
 func (node *GossipNode) removeNodeByExceedDegree(logger *logging.Logger) {
@@ -211,30 +213,25 @@ func (node *GossipNode) announceNewPeer() {
 
 func (node *GossipNode) updateByPeerJoin(peerAddress string, logger *logging.Logger) {
 	node.peersMutex.Lock()
+	previousPeers := len(node.peers)
 	if _, exists := node.peers[peerAddress]; !exists {
 		node.peers[peerAddress] = struct{}{}
 		logger.InfoF("New peer announced and added: %s", peerAddress)
 	} else {
 		logger.InfoF("Peer %s already known", peerAddress)
 	}
-	node.PrintPeerLists()
+	node.printPeerLists()
 	node.removeNodeByExceedDegree(logger)
+	node.catchUpIfReconnected(previousPeers, logger)
 	node.peersMutex.Unlock()
 }
 
-func (node *GossipNode) announceLeave() {
-	logger := logging.NewCustomLogger()
-	logger.InfoF("Peer %s sends announceLeave", node.p2pAddress)
-
-	leaveMsg := &pb.GossipMessage{
-		MessageId: uint32(generate16BitRandomInteger()),
-		Payload:   []byte(node.p2pAddress),
-		From:      node.p2pAddress,
-		Type:      int32(enum.PeerLeaveAnnounce),
-		Ttl:       int32(5),
+// catchUpIfReconnected requests history when the node had no peers before, e.g. after a partition.
+// It must be called with peersMutex held.
+func (node *GossipNode) catchUpIfReconnected(previousPeers int, logger *logging.Logger) {
+	if previousPeers == 0 && len(node.peers) > 0 {
+		go node.RequestHistory(node.catchUpSince(), logger)
 	}
-
-	node.gossip(leaveMsg, logger)
 }
 
 func (node *GossipNode) updateByPeerLeave(peerAddress string, logger *logging.Logger) {
@@ -244,12 +241,3 @@ func (node *GossipNode) updateByPeerLeave(peerAddress string, logger *logging.Lo
 
 	logger.InfoF("Peer %s left and removed from peer list", peerAddress)
 }
-
-func (node *GossipNode) ShutDown() {
-	logger := logging.NewCustomLogger()
-	logger.Info("Shutting down gracefully...")
-
-	node.announceLeave()
-
-	logger.Info("Node has shut down successfully.")
-}
@@ -0,0 +1,237 @@
+package p2p
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* --------------------------------- HISTORY ---------------------------------- */
+
+// historyEntry is an application message retained exactly as it was received, so that its proof of work stays valid
+type historyEntry struct {
+	received time.Time
+	msg      *pb.GossipMessage
+}
+
+// messageHistory retains recent application messages per datatype, bounded by count, payload bytes and age
+type messageHistory struct {
+	mu           sync.Mutex
+	maxMessages  int
+	maxBytes     int
+	maxAge       time.Duration
+	entries      map[int32][]historyEntry
+	bytes        map[int32]int
+	lastReceived time.Time
+}
+
+func newMessageHistory(maxMessages int, maxBytes int, maxAge time.Duration) *messageHistory {
+	return &messageHistory{
+		maxMessages: maxMessages,
+		maxBytes:    maxBytes,
+		maxAge:      maxAge,
+		entries:     make(map[int32][]historyEntry),
+		bytes:       make(map[int32]int),
+	}
+}
+
+// add retains a copy of msg
+func (h *messageHistory) add(msg *pb.GossipMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	h.lastReceived = now
+	h.entries[msg.Type] = append(h.entries[msg.Type], historyEntry{received: now, msg: proto.Clone(msg).(*pb.GossipMessage)})
+	h.bytes[msg.Type] += len(msg.Payload)
+	h.prune(msg.Type, now)
+}
+
+// prune drops the oldest messages of datatype until all bounds hold
+func (h *messageHistory) prune(datatype int32, now time.Time) {
+	entries := h.entries[datatype]
+	for len(entries) > 0 && (len(entries) > h.maxMessages || h.bytes[datatype] > h.maxBytes || now.Sub(entries[0].received) > h.maxAge) {
+		h.bytes[datatype] -= len(entries[0].msg.Payload)
+		entries = entries[1:]
+	}
+
+	if len(entries) == 0 {
+		delete(h.entries, datatype)
+		delete(h.bytes, datatype)
+		return
+	}
+	h.entries[datatype] = entries
+}
+
+// since returns the retained messages received at or after since, oldest first
+func (h *messageHistory) since(since time.Time) []*pb.GossipMessage {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	var selected []historyEntry
+	for datatype := range h.entries {
+		h.prune(datatype, now)
+		for _, entry := range h.entries[datatype] {
+			if !entry.received.Before(since) {
+				selected = append(selected, entry)
+			}
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool { return selected[i].received.Before(selected[j].received) })
+
+	msgs := make([]*pb.GossipMessage, 0, len(selected))
+	for _, entry := range selected {
+		msgs = append(msgs, entry.msg)
+	}
+	return msgs
+}
+
+// last returns the time the newest message was retained, or the zero time
+func (h *messageHistory) last() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.lastReceived
+}
+
+// isProtocolMessage reports whether msgType is used by the P2P protocol itself rather than by applications
+func isProtocolMessage(msgType int32) bool {
+	switch uint16(msgType) {
+	case enum.PeerJoinAnnounce, enum.PeerLeaveAnnounce, enum.PeerListRequest, enum.PeerListResponse,
+		enum.HistoryRequest, enum.HistoryResponse, enum.PayloadRequest, enum.MessageFragment, enum.MessageBatch:
+		return true
+	default:
+		return false
+	}
+}
+
+// catchUpSince is the point in time history is requested from: the newest retained message, or as far back as peers retain
+func (node *GossipNode) catchUpSince() time.Time {
+	if last := node.history.last(); !last.IsZero() {
+		return last
+	}
+	return time.Now().Add(-enum.HistoryMaxAge)
+}
+
+// RequestHistory asks up to fanout peers for the application messages they retained since the given time
+func (node *GossipNode) RequestHistory(since time.Time, logger *logging.Logger) {
+	node.peersMutex.RLock()
+	peerList := make([]string, 0, len(node.peers))
+	for peer := range node.peers {
+		peerList = append(peerList, peer)
+	}
+	node.peersMutex.RUnlock()
+
+	if len(peerList) > node.fanout {
+		peerList = peerList[:node.fanout]
+	}
+
+	for _, peer := range peerList {
+		requestMsg := &pb.GossipMessage{
+			MessageId: uint32(generate16BitRandomInteger()),
+			From:      node.p2pAddress,
+			Type:      int32(enum.HistoryRequest),
+			Payload:   []byte(since.Format(time.RFC3339Nano)),
+			Ttl:       1,
+		}
+		pow.CalculateAndAddNonce(requestMsg)
+
+		if err := node.send(peer, requestMsg); err != nil {
+			logger.ErrorF("Failed to request history from %s: %v", peer, err)
+		} else {
+			logger.InfoF("HistoryRequest since %s sent to %s", since.Format(time.RFC3339), peer)
+		}
+	}
+}
+
+// respondWithHistory sends every retained message requested by msg back to its sender, one HistoryResponse each
+func (node *GossipNode) respondWithHistory(msg *pb.GossipMessage, logger *logging.Logger) {
+	since, err := time.Parse(time.RFC3339Nano, string(msg.Payload))
+	if err != nil {
+		logger.ErrorF("Invalid HistoryRequest from %s: %v", msg.From, err)
+		return
+	}
+
+	for _, retained := range node.history.since(since) {
+		data, err := serialize(retained)
+		if err != nil {
+			logger.ErrorF("Failed to serialize retained message: %v", err)
+			continue
+		}
+
+		responseMsg := &pb.GossipMessage{
+			MessageId: uint32(generate16BitRandomInteger()),
+			From:      node.p2pAddress,
+			Type:      int32(enum.HistoryResponse),
+			Payload:   data,
+			Ttl:       1,
+		}
+		pow.CalculateAndAddNonce(responseMsg)
+
+		if err := node.send(msg.From, responseMsg); err != nil {
+			logger.ErrorF("Failed to send history to %s: %v", msg.From, err)
+			// Retained messages too large for a single response are skipped, others are still sent
+			if errors.Is(err, errMessageTooLarge) {
+				continue
+			}
+			return
+		}
+	}
+}
+
+// handleHistoryResponse revalidates a retained message of a peer and delivers it locally. It is not gossiped again.
+// History responses are sent directly, so the retained message is logged as received from the responding peer.
+func (node *GossipNode) handleHistoryResponse(msg *pb.GossipMessage, logger *logging.Logger) {
+	retained, err := deserialize(msg.Payload)
+	if err != nil {
+		logger.ErrorF("Failed to deserialize retained message: %v", err)
+		return
+	}
+	if isProtocolMessage(retained.Type) {
+		return
+	}
+
+	if !pow.Validate(retained) {
+		logger.Error("Failed to validate nonce of retained message")
+		node.logMessage(retained, msg.From, node.knownPayload(retained), msglog.DecisionDroppedPoW, logger)
+		return
+	}
+
+	if node.isMessageCached(strconv.Itoa(int(retained.MessageId))) {
+		node.logMessage(retained, msg.From, node.knownPayload(retained), msglog.DecisionDuplicate, logger)
+		return
+	}
+	node.addToCache(strconv.Itoa(int(retained.MessageId)))
+
+	payload, err := node.resolvePayload(retained, []string{msg.From, retained.From}, logger)
+	if err != nil {
+		logger.ErrorF("Dropping retained message %d: %v", retained.MessageId, err)
+		return
+	}
+
+	notify := func() { node.notifyModules(retained, payload) }
+	accepted := true
+	if node.validator != nil {
+		accepted = node.validator.Decide(uint16(retained.MessageId), enum.Datatype(retained.Type), payload, notify)
+	} else {
+		notify()
+	}
+
+	if !accepted {
+		node.logMessage(retained, msg.From, payload, msglog.DecisionInvalid, logger)
+		return
+	}
+
+	node.logMessage(retained, msg.From, payload, msglog.DecisionReplayed, logger)
+	node.history.add(retained)
+}
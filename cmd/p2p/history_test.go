@@ -0,0 +1,29 @@
+package p2p
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// TestMessageHistoryBounds checks that retained messages are bounded per datatype by count and bytes.
+func TestMessageHistoryBounds(t *testing.T) {
+	history := newMessageHistory(3, 10, time.Hour)
+	start := time.Now()
+
+	for i := 0; i < 5; i++ {
+		history.add(&pb.GossipMessage{Type: 1, MessageId: uint32(i), Payload: []byte("ab")})
+	}
+	history.add(&pb.GossipMessage{Type: 2, MessageId: 10, Payload: []byte("0123456789")})
+	history.add(&pb.GossipMessage{Type: 2, MessageId: 11, Payload: []byte("xyz")})
+
+	var ids []uint32
+	for _, msg := range history.since(start) {
+		ids = append(ids, msg.MessageId)
+	}
+	assert.Equal(t, []uint32{2, 3, 4, 11}, ids)
+	assert.Empty(t, history.since(time.Now().Add(time.Second)))
+}
@@ -0,0 +1,180 @@
+package p2p
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* ----------------------------- LAZY PAYLOAD TRANSFER ----------------------------- */
+
+// payloadCache keeps payloads by content hash to serve lazy transfers, evicting the oldest beyond maxBytes
+type payloadCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	bytes    int
+	payloads map[string][]byte
+	order    []string
+}
+
+func newPayloadCache(maxBytes int) *payloadCache {
+	return &payloadCache{maxBytes: maxBytes, payloads: make(map[string][]byte)}
+}
+
+func (c *payloadCache) add(hash []byte, payload []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := hex.EncodeToString(hash)
+	if _, exists := c.payloads[key]; exists {
+		return
+	}
+
+	c.payloads[key] = payload
+	c.order = append(c.order, key)
+	c.bytes += len(payload)
+
+	for c.bytes > c.maxBytes && len(c.order) > 1 {
+		oldest := c.order[0]
+		c.bytes -= len(c.payloads[oldest])
+		delete(c.payloads, oldest)
+		c.order = c.order[1:]
+	}
+}
+
+func (c *payloadCache) get(hash []byte) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	payload, exists := c.payloads[hex.EncodeToString(hash)]
+	return payload, exists
+}
+
+func contentHash(payload []byte) []byte {
+	sum := sha256.Sum256(payload)
+	return sum[:]
+}
+
+// makeLazy replaces the payload of msg by its content hash and size if it exceeds the lazy threshold
+func (node *GossipNode) makeLazy(msg *pb.GossipMessage) {
+	if node.lazyThreshold <= 0 || len(msg.Payload) <= node.lazyThreshold || len(msg.Payload) > enum.MaxLazyPayloadSize {
+		return
+	}
+
+	msg.ContentHash = contentHash(msg.Payload)
+	msg.ContentSize = uint32(len(msg.Payload))
+	node.payloadCache.add(msg.ContentHash, msg.Payload)
+	msg.Payload = nil
+}
+
+// knownPayload returns the payload of msg without fetching it: nil for lazily transferred payloads not cached yet
+func (node *GossipNode) knownPayload(msg *pb.GossipMessage) []byte {
+	if len(msg.ContentHash) == 0 {
+		return msg.Payload
+	}
+	payload, _ := node.payloadCache.get(msg.ContentHash)
+	return payload
+}
+
+// resolvePayload returns the payload of msg. Lazily transferred payloads are taken from the cache or fetched
+// from the given sources first and then from any peer, and cached for other peers.
+func (node *GossipNode) resolvePayload(msg *pb.GossipMessage, sources []string, logger *logging.Logger) ([]byte, error) {
+	if len(msg.ContentHash) == 0 {
+		return msg.Payload, nil
+	}
+	if payload, exists := node.payloadCache.get(msg.ContentHash); exists {
+		return payload, nil
+	}
+	if msg.ContentSize > enum.MaxLazyPayloadSize {
+		return nil, fmt.Errorf("lazy payload of %d bytes exceeds %d bytes", msg.ContentSize, enum.MaxLazyPayloadSize)
+	}
+
+	node.peersMutex.RLock()
+	for peer := range node.peers {
+		sources = append(sources, peer)
+	}
+	node.peersMutex.RUnlock()
+
+	tried := make(map[string]bool)
+	for _, source := range sources {
+		if source == node.p2pAddress || tried[source] {
+			continue
+		}
+		tried[source] = true
+
+		payload, err := node.fetchPayload(source, msg.ContentHash, msg.ContentSize)
+		if err != nil {
+			logger.DebugF("Failed to fetch payload %x from %s: %v", msg.ContentHash, source, err)
+			continue
+		}
+
+		node.payloadCache.add(msg.ContentHash, payload)
+		return payload, nil
+	}
+
+	return nil, fmt.Errorf("payload %x not available from any peer", msg.ContentHash)
+}
+
+// fetchPayload requests a payload by content hash from address and verifies it
+func (node *GossipNode) fetchPayload(address string, hash []byte, size uint32) ([]byte, error) {
+	requestMsg := &pb.GossipMessage{
+		MessageId: uint32(generate16BitRandomInteger()),
+		From:      node.p2pAddress,
+		Type:      int32(enum.PayloadRequest),
+		Payload:   hash,
+	}
+	pow.CalculateAndAddNonce(requestMsg)
+
+	data, err := serialize(requestMsg)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("tcp", address, enum.PayloadFetchTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(enum.PayloadFetchTimeout))
+
+	if _, err := conn.Write(data); err != nil {
+		return nil, err
+	}
+	// The peer reads the request until EOF before it answers
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+
+	payload, err := io.ReadAll(io.LimitReader(conn, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) != int(size) || !bytes.Equal(contentHash(payload), hash) {
+		return nil, fmt.Errorf("payload does not match its content hash")
+	}
+
+	return payload, nil
+}
+
+// servePayload answers a PayloadRequest on the connection it arrived on with the raw payload, if cached
+func (node *GossipNode) servePayload(conn net.Conn, msg *pb.GossipMessage, logger *logging.Logger) {
+	payload, exists := node.payloadCache.get(msg.Payload)
+	if !exists {
+		logger.DebugF("Requested payload %x is not cached", msg.Payload)
+		return
+	}
+
+	_ = conn.SetWriteDeadline(time.Now().Add(enum.PayloadFetchTimeout))
+	if _, err := conn.Write(payload); err != nil {
+		logger.ErrorF("Failed to serve payload to %s: %v", msg.From, err)
+	}
+}
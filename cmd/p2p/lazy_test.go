@@ -0,0 +1,66 @@
+package p2p
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+func newTestNode(address string, lazyThreshold int) *GossipNode {
+	node, err := NewGossipNode(address, nil, nil, false, nil, nil, common.NewMap(), nil, nil, nil, "", 5, 30, lazyThreshold, 0, 0)
+	if err != nil {
+		panic(err)
+	}
+	return node
+}
+
+func serveTestNode(t *testing.T, node *GossipNode, listener net.Listener) {
+	t.Helper()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go node.HandleConnection(conn, logging.NewCustomLogger())
+		}
+	}()
+}
+
+// TestLazyPayloadTransfer checks that a lazily announced payload can be fetched and verified by another node.
+func TestLazyPayloadTransfer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	origin := newTestNode(listener.Addr().String(), 16)
+	serveTestNode(t, origin, listener)
+
+	payload := bytes.Repeat([]byte("large"), 100)
+	msg := &pb.GossipMessage{Type: 1, From: origin.p2pAddress, Payload: payload}
+	origin.makeLazy(msg)
+
+	assert.Empty(t, msg.Payload)
+	assert.Equal(t, uint32(len(payload)), msg.ContentSize)
+
+	receiver := newTestNode("127.0.0.1:1", 16)
+	resolved, err := receiver.resolvePayload(msg, []string{msg.From}, logging.NewCustomLogger())
+	require.NoError(t, err)
+	assert.Equal(t, payload, resolved)
+
+	// The receiver now serves the payload from its cache
+	cached, exists := receiver.payloadCache.get(msg.ContentHash)
+	assert.True(t, exists)
+	assert.Equal(t, payload, cached)
+
+	msg.ContentHash = contentHash([]byte("something else"))
+	_, err = receiver.resolvePayload(msg, []string{origin.p2pAddress}, logging.NewCustomLogger())
+	assert.Error(t, err)
+}
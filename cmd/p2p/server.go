@@ -1,20 +1,33 @@
 package p2p
 
 import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net"
 	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
 	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
 )
 
+// MessageValidator decides whether a received message is forwarded to other peers.
+// notify hands the message to the local modules and must be called at most once.
+type MessageValidator interface {
+	Decide(msgID uint16, datatype enum.Datatype, payload []byte, notify func()) bool
+}
+
 type GossipNode struct {
 	p2pAddress          string
 	peers               map[string]struct{}
@@ -23,6 +36,7 @@ type GossipNode struct {
 	seedNodesMutex      sync.RWMutex
 	isSeedNode          bool
 	messageIDCache      []string
+	messageIDCacheMutex sync.Mutex
 	cacheSize           int
 	degree              int
 	fanout              int
@@ -30,7 +44,24 @@ type GossipNode struct {
 	announceMsgChan     chan enum.AnnounceMsg
 	notificationMsgChan chan enum.NotificationMsg
 	datatypeMapper      *common.DatatypeMapper
-	bootstrapURL        string
+	history             *messageHistory
+	validator           MessageValidator
+	messageLog          *msglog.Log
+	payloadCache        *payloadCache
+	reassembler         *reassembler
+	compression         *compression
+	senders             map[string]*peerSender
+	sendersMutex        sync.Mutex
+	pendingSends        atomic.Int64
+	connections         sync.WaitGroup
+	batchWindow         time.Duration
+	lazyThreshold       int
+	bootstrapURLs       []string
+	peerCachePath       string
+	staticSeed          bool
+	bootstrapIndex      int
+	bootstrapMutex      sync.Mutex
+	identityKey         ed25519.PrivateKey
 }
 
 const (
@@ -46,14 +77,22 @@ func NewGossipNode(
 	announceMsgChan chan enum.AnnounceMsg,
 	notificationMsgChan chan enum.NotificationMsg,
 	datatypeMapper *common.DatatypeMapper,
-	bootstrapURL string,
-	cacheSize int, degree int) *GossipNode {
+	validator MessageValidator,
+	messageLog *msglog.Log,
+	bootstrapURLs []string,
+	peerCachePath string,
+	cacheSize int, degree int, lazyThreshold int, compressionThreshold int, batchWindow time.Duration) (*GossipNode, error) {
 	peers := make(map[string]struct{})
 	seedNodeMap := make(map[string]struct{})
 
 	for _, peer := range initialPeers {
 		peers[peer] = struct{}{}
 	}
+	// The identity key only lives as long as the process, it proves to the bootstrapper that this node owns its address
+	_, identityKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity key: %w", err)
+	}
 	for _, seedNode := range seedNodes {
 		seedNodeMap[seedNode] = struct{}{}
 	}
@@ -70,62 +109,102 @@ func NewGossipNode(
 		fanout:              fanout,
 		gossipInterval:      gossipInterval,
 		datatypeMapper:      datatypeMapper,
-		bootstrapURL:        bootstrapURL,
-	}
+		history:             newMessageHistory(enum.HistoryMaxMessages, enum.HistoryMaxBytes, enum.HistoryMaxAge),
+		validator:           validator,
+		messageLog:          messageLog,
+		payloadCache:        newPayloadCache(enum.PayloadCacheBytes),
+		reassembler:         newReassembler(enum.FragmentTimeout, enum.FragmentPendingPerPeer, enum.FragmentPendingBytes),
+		compression:         newCompression(compressionThreshold),
+		senders:             make(map[string]*peerSender),
+		batchWindow:         batchWindow,
+		lazyThreshold:       lazyThreshold,
+		bootstrapURLs:       bootstrapURLs,
+		peerCachePath:       peerCachePath,
+		staticSeed:          isSeedNode,
+		identityKey:         identityKey,
+	}, nil
 }
 
-func (node *GossipNode) Start() {
+// Start joins the network and runs the node until ctx is done, then leaves the network again. The node also leaves
+// if ctx is done while it is still joining.
+func (node *GossipNode) Start(ctx context.Context) {
 	logger := logging.NewCustomLogger()
 
-	var wg sync.WaitGroup
-	wg.Add(4)
+	ln := node.openListener(logger)
+	accepting := make(chan struct{})
 	go func() {
-		defer wg.Done()
-		node.listen(node.p2pAddress, &wg)
+		defer close(accepting)
+		node.acceptConnections(ln)
 	}()
 
-	time.Sleep(1 * time.Second)
+	var wg sync.WaitGroup
+	// The bootstrapper dials this node to check its address while registering, so connections must be accepted already.
+	// Without a bootstrapper, the node still joins through its other discovery sources; it registers again as soon as
+	// a heartbeat reaches a bootstrapper.
+	if len(node.bootstrapURLs) > 0 {
+		wg.Add(1)
+		go func(p2pAddress string) {
+			defer wg.Done()
+			if err := node.registerWithBootstrapper(ctx, p2pAddress); err != nil && ctx.Err() == nil {
+				logger.ErrorF("Failed to register with any bootstrapper: %v", err)
+			}
+		}(node.p2pAddress)
+	}
 
-	if err := node.getInitialPeers(); err != nil {
-		logger.FatalF("Failed to register with bootstrapper: %v", err)
+	if err := sleepContext(ctx, 1*time.Second); err == nil {
+		node.join(ctx, &wg, logger)
+	}
+
+	<-ctx.Done()
+	// No registration or heartbeat may register the node again after it deregistered
+	wg.Wait()
+	logger.Info("Shutting down gracefully...")
+	node.shutdown(ln, accepting, logger)
+}
+
+// join discovers the first peers, announces this node and starts the periodic tasks of the node on wg. It gives up
+// without error if ctx is done before a peer is found.
+func (node *GossipNode) join(ctx context.Context, wg *sync.WaitGroup, logger *logging.Logger) {
+	if err := node.discoverPeers(ctx, logger); err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		logger.FatalF("Failed to discover any peers: %v", err)
 	}
 
 	node.announceNewPeer()
 
+	// Catch up on messages announced before this node joined
+	node.RequestHistory(node.catchUpSince(), logger)
+
 	node.PrintPeerLists()
+
+	wg.Add(3)
 	go func() {
 		defer wg.Done()
-		node.listenAnnounceMessage(node.announceMsgChan)
+		node.listenAnnounceMessage(ctx, node.announceMsgChan)
 	}()
 
 	go func() {
 		defer wg.Done()
-		node.periodicPeerListRequest()
+		node.periodicPeerListRequest(ctx)
 	}()
 	/*
 		go func() {
 			defer wg.Done()
-			node.periodicBootstrapping()
+			node.periodicBootstrapping(ctx)
 		}()
 	*/
 
 	go func() {
 		defer wg.Done()
-		node.sendHeartbeat()
+		node.sendHeartbeat(ctx)
 	}()
-
-	wg.Wait()
-
 }
 
-// listen: this function has multiple purposes:
-// - listen to messages from other peers
-// - gossip the message away
-// - check for the type of message that should be propagated, as requested from api of this peer
-func (node *GossipNode) listen(p2pAddress string, wg *sync.WaitGroup) {
-	logger := logging.NewCustomLogger()
-
-	ln, err := net.Listen("tcp", p2pAddress)
+// openListener listens on the P2P address, or on any free port if it is taken
+func (node *GossipNode) openListener(logger *logging.Logger) net.Listener {
+	ln, err := net.Listen("tcp", node.p2pAddress)
 	if err != nil {
 		ln, err = net.Listen("tcp", "localhost:0")
 		if err != nil {
@@ -135,37 +214,45 @@ func (node *GossipNode) listen(p2pAddress string, wg *sync.WaitGroup) {
 	// line underneath is unnecessary when one node has one corresponding address IP only
 	node.p2pAddress = ln.Addr().String()
 	logger.InfoF("P2P Server is listening on: %v", ln.Addr())
+	return ln
+}
 
-	if err := node.registerWithBootstrapper(ln.Addr().String()); err != nil {
-		logger.FatalF("Failed to register with bootstrapper: %v", err)
-	}
-
-	defer func(ln net.Listener) {
-		err = ln.Close()
+// acceptConnections handles the messages of other peers until ln is closed
+func (node *GossipNode) acceptConnections(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
 		if err != nil {
-			logger.FatalF("Error closing TCP server: %v", err)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			logging.NewCustomLogger().ErrorF("Failed to accept connection: %v", err)
+			continue
 		}
-	}(ln)
 
-	for {
-		conn, err1 := ln.Accept()
+		logger := logging.NewCustomLogger()
 		logger.Host(conn.LocalAddr().String())
 		logger.Client(conn.RemoteAddr().String())
-		if err1 != nil {
-			logger.ErrorF("Failed to accept connection: %v", err)
-			continue
-		}
-		wg.Add(1)
-		go node.HandleConnection(conn, logger)
+
+		node.connections.Add(1)
+		go func() {
+			defer node.connections.Done()
+			node.HandleConnection(conn, logger)
+		}()
 	}
 }
 
 // listenAnnounceMessage: listen to announce message and gossip it away. this function is to take announceMsg from the channel (used or intrad node call)
-func (node *GossipNode) listenAnnounceMessage(announceMsgChan chan enum.AnnounceMsg) {
+func (node *GossipNode) listenAnnounceMessage(ctx context.Context, announceMsgChan chan enum.AnnounceMsg) {
 	logger := logging.NewCustomLogger()
 
 	for {
-		msg, ok := <-announceMsgChan
+		var msg enum.AnnounceMsg
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case msg, ok = <-announceMsgChan:
+		}
 		if !ok {
 			logger.Info("Channel closed, exiting loop")
 			return
@@ -179,12 +266,50 @@ func (node *GossipNode) listenAnnounceMessage(announceMsgChan chan enum.Announce
 				Type:      int32(msg.DataType),
 				Ttl:       int32(msg.TTL),
 			}
+			node.makeLazy(gossipMsg)
 
-			node.gossip(gossipMsg, logger)
+			fragments, err := fragment(gossipMsg)
+			if err != nil {
+				logger.ErrorF("Failed to announce message: %v", err)
+				continue
+			}
+
+			node.logMessage(gossipMsg, node.p2pAddress, []byte(msg.Data), msglog.DecisionForwarded, logger)
+			for _, fragmentMsg := range fragments {
+				node.gossip(fragmentMsg, logger)
+			}
+			node.history.add(gossipMsg)
 		}
 	}
 }
 
+// logMessage records msg as received from sender and the decision taken on it in the message log, if one is
+// configured. payload is the resolved payload of msg; lazily transferred messages without one are logged with their
+// content hash.
+func (node *GossipNode) logMessage(msg *pb.GossipMessage, sender string, payload []byte, decision msglog.Decision, logger *logging.Logger) {
+	if node.messageLog == nil {
+		return
+	}
+
+	entry := msglog.Entry{
+		Received:   time.Now(),
+		MessageID:  msg.MessageId,
+		Datatype:   msg.Type,
+		Sender:     sender,
+		Originator: msg.From,
+		TTL:        msg.Ttl,
+		Decision:   decision,
+		Payload:    payload,
+	}
+	if payload == nil {
+		entry.ContentHash = msg.ContentHash
+	}
+	err := node.messageLog.Append(entry)
+	if err != nil {
+		logger.ErrorF("Failed to write message log: %v", err)
+	}
+}
+
 func (node *GossipNode) HandleConnection(conn net.Conn, logger *logging.Logger) {
 	defer func(conn net.Conn) {
 		err := conn.Close()
@@ -194,56 +319,178 @@ func (node *GossipNode) HandleConnection(conn net.Conn, logger *logging.Logger)
 		}
 	}(conn)
 
-	buf := make([]byte, 4096)
-	n, err := conn.Read(buf)
+	_ = conn.SetReadDeadline(time.Now().Add(enum.P2PReadTimeout))
+	data, err := io.ReadAll(io.LimitReader(conn, enum.MaxP2PMessageSize+1))
 	if err != nil {
-		if err == io.EOF {
-			logger.InfoF("Peer %s disconnected", conn.RemoteAddr())
-		} else {
-			logger.ErrorF("Failed to read from connection: %v", err)
-		}
+		logger.ErrorF("Failed to read from connection: %v", err)
+		return
+	}
+	if len(data) == 0 {
+		logger.InfoF("Peer %s disconnected", conn.RemoteAddr())
 		return
 	}
-	msg, err := deserialize(buf[:n])
+	if len(data) > enum.MaxP2PMessageSize {
+		logger.ErrorF("Message from %s exceeds %d bytes, dropping it", conn.RemoteAddr(), enum.MaxP2PMessageSize)
+		return
+	}
+
+	msg, err := deserialize(data)
 	if err != nil {
 		logger.ErrorF("Failed to deserialize message: %v", err)
 		return
 	}
 
+	// The hop fields are cleared by decode. Peers that do not name themselves are logged by their connection.
+	sender := msg.Sender
+	if sender == "" {
+		sender = conn.RemoteAddr().String()
+	}
+
+	node.compression.learn(msg)
+	if err := decode(msg); err != nil {
+		logger.ErrorF("Failed to decode message from %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	if msg.Type == int32(enum.MessageBatch) {
+		node.handleBatch(msg, sender, logger)
+		return
+	}
+
+	// Reachability probes come from the bootstrapper, which does not compute a proof of work
+	if msg.Type == int32(enum.ReachabilityProbe) {
+		node.answerProbe(conn, msg, logger)
+		return
+	}
+
 	if !pow.Validate(msg) {
 		logger.Error("Failed to validate nonce")
+		node.logMessage(msg, sender, node.knownPayload(msg), msglog.DecisionDroppedPoW, logger)
+		return
+	}
+
+	// Payload requests are answered on the same connection and never gossiped
+	if msg.Type == int32(enum.PayloadRequest) {
+		node.servePayload(conn, msg, logger)
 		return
 	}
-	node.handleGossipMessage(msg, logger)
+
+	node.handleGossipMessage(msg, sender, logger)
+
+	// Leaving nodes wait for this confirmation before they deregister
+	if msg.Type == int32(enum.PeerLeaveAnnounce) {
+		confirmLeave(conn)
+	}
 }
 
-func (node *GossipNode) handleGossipMessage(msg *pb.GossipMessage, logger *logging.Logger) {
+// handleGossipMessage handles msg as received from the peer sender
+func (node *GossipNode) handleGossipMessage(msg *pb.GossipMessage, sender string, logger *logging.Logger) {
 
 	if node.isMessageCached(strconv.Itoa(int(msg.MessageId))) {
 		//logger.InfoF("Duplicated gossip message, ID: %s", strconv.Itoa(int(msg.MessageId)))
+		node.logMessage(msg, sender, node.knownPayload(msg), msglog.DecisionDuplicate, logger)
 		return
 	} else {
 		node.addToCache(strconv.Itoa(int(msg.MessageId)))
 	}
 
+	if msg.Type == int32(enum.MessageFragment) {
+		node.handleFragment(msg, sender, logger)
+		return
+	}
+
+	forward := node.acceptMessage(msg, sender, logger)
+
+	msg.Ttl -= 1 //TODO: check whether there is better place to put this, in gossip() itself for example
+
+	node.handleProtocolMessage(msg, logger)
+
+	if !forward {
+		logger.InfoF("Message %d not accepted, not forwarding", msg.MessageId)
+		return
+	}
+
+	relayedMessages.Add(1)
+	node.gossip(msg, logger)
+
+}
+
+// acceptMessage resolves the payload of msg, runs the validation policy, which delivers it to local modules,
+// and records the decision. It reports whether msg, received from sender, may be forwarded.
+func (node *GossipNode) acceptMessage(msg *pb.GossipMessage, sender string, logger *logging.Logger) bool {
+	payload, err := node.resolvePayload(msg, []string{msg.From}, logger)
+	if err != nil {
+		logger.ErrorF("Dropping message %d: %v", msg.MessageId, err)
+		return false
+	}
+
+	notify := func() { node.notifyModules(msg, payload) }
+
+	forward := true
+	if node.validator != nil {
+		forward = node.validator.Decide(uint16(msg.MessageId), enum.Datatype(msg.Type), payload, notify)
+	} else {
+		notify()
+	}
+
+	if forward {
+		node.logMessage(msg, sender, payload, msglog.DecisionForwarded, logger)
+		if !isProtocolMessage(msg.Type) {
+			node.history.add(msg)
+		}
+	} else {
+		node.logMessage(msg, sender, payload, msglog.DecisionInvalid, logger)
+	}
+
+	return forward
+}
+
+// handleFragment collects a fragment received from sender. Once its message is complete and accepted, all fragments
+// are forwarded.
+func (node *GossipNode) handleFragment(msg *pb.GossipMessage, sender string, logger *logging.Logger) {
+	original, fragments, err := node.reassembler.add(msg, sender, logger)
+	if err != nil {
+		logger.ErrorF("Dropping fragment %d: %v", msg.MessageId, err)
+		return
+	}
+	if original == nil {
+		return
+	}
+
+	if node.isMessageCached(strconv.Itoa(int(original.MessageId))) {
+		node.logMessage(original, sender, node.knownPayload(original), msglog.DecisionDuplicate, logger)
+		return
+	}
+	node.addToCache(strconv.Itoa(int(original.MessageId)))
+
+	if !node.acceptMessage(original, sender, logger) {
+		logger.InfoF("Message %d not accepted, not forwarding its fragments", original.MessageId)
+		return
+	}
+
+	for _, fragmentMsg := range fragments {
+		fragmentMsg.Ttl -= 1
+		node.gossip(fragmentMsg, logger)
+	}
+}
+
+// notifyModules hands msg with its resolved payload to the local modules if any of them subscribed to its datatype
+func (node *GossipNode) notifyModules(msg *pb.GossipMessage, payload []byte) {
 	if node.datatypeMapper.CheckNotify(uint16(msg.MessageId), enum.Datatype(msg.Type)) {
 		//logger.DebugF("Notification Message found with type: %d", msg.Type)
 
+		node.datatypeMapper.AddMessageDatatype(uint16(msg.MessageId), enum.Datatype(msg.Type))
+
 		newNotificationMsg := enum.NotificationMsg{
 			MessageID: uint16(msg.MessageId),
 			DataType:  enum.Datatype(msg.Type),
-			Data:      string(msg.Payload),
+			Data:      string(payload),
 		}
 
 		node.notificationMsgChan <- newNotificationMsg
 	}
-	msg.Ttl -= 1 //TODO: check whether there is better place to put this, in gossip() itself for example
-
-	node.handleProtocolMessage(msg, logger)
-
-	node.gossip(msg, logger)
-
 }
+
 func (node *GossipNode) handleProtocolMessage(msg *pb.GossipMessage, logger *logging.Logger) {
 
 	switch msg.Type {
@@ -273,6 +520,14 @@ func (node *GossipNode) handleProtocolMessage(msg *pb.GossipMessage, logger *log
 		}
 		node.updateByPeerListResponse(receivedPeers, logger)
 
+	case int32(enum.HistoryRequest):
+		logger.Debug("Handling HistoryRequest message")
+		node.respondWithHistory(msg, logger)
+
+	case int32(enum.HistoryResponse):
+		logger.Debug("Handling HistoryResponse message")
+		node.handleHistoryResponse(msg, logger)
+
 	default:
 		logger.DebugF("Unknown P2P message type: %d", msg.Type)
 	}
@@ -0,0 +1,68 @@
+package p2p
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/msglog"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// receive hands msg to node as if sender had sent it on this hop
+func receive(t *testing.T, node *GossipNode, msg *pb.GossipMessage, sender string) {
+	pow.CalculateAndAddNonce(msg)
+	msg.Sender = sender
+	data, err := serialize(msg)
+	require.NoError(t, err)
+
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	go func() {
+		node.HandleConnection(server, logging.NewCustomLogger())
+		close(done)
+	}()
+	_, err = client.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, client.Close())
+	<-done
+}
+
+// TestMessageLogSenders checks that the message log tells the peer a message came from apart from its originator,
+// and that lazily transferred messages are logged with their payload.
+func TestMessageLogSenders(t *testing.T) {
+	dir := t.TempDir()
+	messageLog, err := msglog.Open(dir, 1<<20, 2)
+	require.NoError(t, err)
+	defer messageLog.Close()
+
+	node := newTestNode("127.0.0.1:7001", 0)
+	node.messageLog = messageLog
+
+	receive(t, node, &pb.GossipMessage{MessageId: 1, Type: 1, From: "10.0.0.9:7001", Payload: []byte("eager"), Ttl: 3}, "10.0.0.2:7001")
+	receive(t, node, &pb.GossipMessage{MessageId: 1, Type: 1, From: "10.0.0.9:7001", Payload: []byte("eager"), Ttl: 3}, "10.0.0.3:7001")
+
+	payload := bytes.Repeat([]byte("lazy"), 100)
+	node.payloadCache.add(contentHash(payload), payload)
+	receive(t, node, &pb.GossipMessage{MessageId: 2, Type: 1, From: "10.0.0.9:7001", ContentHash: contentHash(payload),
+		ContentSize: uint32(len(payload)), Ttl: 3}, "10.0.0.2:7001")
+
+	var entries []msglog.Entry
+	require.NoError(t, msglog.Read(dir, msglog.Query{}, func(entry msglog.Entry) error {
+		entries = append(entries, entry)
+		return nil
+	}))
+	require.Len(t, entries, 3)
+
+	assert.Equal(t, msglog.DecisionForwarded, entries[0].Decision)
+	assert.Equal(t, "10.0.0.2:7001", entries[0].Sender)
+	assert.Equal(t, "10.0.0.9:7001", entries[0].Originator)
+	assert.Equal(t, msglog.DecisionDuplicate, entries[1].Decision)
+	assert.Equal(t, "10.0.0.3:7001", entries[1].Sender)
+	assert.Equal(t, payload, entries[2].Payload)
+}
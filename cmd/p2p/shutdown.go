@@ -0,0 +1,210 @@
+package p2p
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+/* --------------------------------- SHUTDOWN ---------------------------------- */
+
+// leaveAck is what a peer answers on the connection a PeerLeaveAnnounce arrived on
+const leaveAck = 0x06
+
+// shutdown leaves the network in order: it stops accepting connections, tells the peers, deregisters from the
+// bootstrapper, sends the queued messages and waits for the open connections to be handled. Every step is bounded
+// by enum.NodeShutdownTimeout in total, so a node always stops.
+func (node *GossipNode) shutdown(ln net.Listener, accepting <-chan struct{}, logger *logging.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), enum.NodeShutdownTimeout)
+	defer cancel()
+
+	// Peers still connecting would not learn that this node leaves. The confirmations of the leave arrive on the
+	// connections this node opens.
+	if err := ln.Close(); err != nil {
+		logger.ErrorF("Failed to close P2P listener: %v", err)
+	}
+	<-accepting
+
+	leaveCtx, cancelLeave := context.WithTimeout(ctx, enum.LeaveTimeout)
+	node.leave(leaveCtx, logger)
+	cancelLeave()
+
+	if err := node.deregister(ctx); err != nil {
+		logger.ErrorF("Failed to deregister from any bootstrapper: %v", err)
+	}
+
+	if err := node.drainSenders(ctx); err != nil {
+		logger.ErrorF("Failed to send all queued messages: %v", err)
+	}
+
+	if err := waitContext(ctx, &node.connections); err != nil {
+		logger.ErrorF("Stopped waiting for open connections: %v", err)
+	}
+
+	if err := node.savePeerTable(); err != nil {
+		logger.ErrorF("Failed to save peer table: %v", err)
+	}
+	logger.Info("Node has shut down successfully.")
+}
+
+// leave sends a PeerLeaveAnnounce to every peer directly and waits until each of them confirmed it or ctx is done.
+// The peers gossip it on as usual.
+func (node *GossipNode) leave(ctx context.Context, logger *logging.Logger) {
+	logger.InfoF("Peer %s sends announceLeave", node.p2pAddress)
+
+	leaveMsg := &pb.GossipMessage{
+		MessageId: uint32(generate16BitRandomInteger()),
+		Payload:   []byte(node.p2pAddress),
+		From:      node.p2pAddress,
+		Type:      int32(enum.PeerLeaveAnnounce),
+		Ttl:       int32(5),
+	}
+	pow.CalculateAndAddNonce(leaveMsg)
+
+	data, err := serialize(leaveMsg)
+	if err != nil {
+		logger.ErrorF("Failed to serialize leave message: %v", err)
+		return
+	}
+
+	node.peersMutex.RLock()
+	peers := make([]string, 0, len(node.peers))
+	for peer := range node.peers {
+		if peer != node.p2pAddress {
+			peers = append(peers, peer)
+		}
+	}
+	node.peersMutex.RUnlock()
+
+	var confirmed atomic.Int32
+	var wg sync.WaitGroup
+	for _, peer := range peers {
+		wg.Add(1)
+		go func(peer string) {
+			defer wg.Done()
+			if err := sendConfirmed(ctx, peer, data); err != nil {
+				logger.ErrorF("Peer %s did not confirm leave: %v", peer, err)
+				return
+			}
+			confirmed.Add(1)
+		}(peer)
+	}
+	wg.Wait()
+
+	logger.InfoF("Leave confirmed by %d of %d peers", confirmed.Load(), len(peers))
+}
+
+// sendConfirmed sends data to address and waits for the leave acknowledgement on the same connection
+func sendConfirmed(ctx context.Context, address string, data []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	// The peer reads the message until EOF before it answers
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+
+	ack := make([]byte, 1)
+	if _, err := io.ReadFull(conn, ack); err != nil {
+		return err
+	}
+	if ack[0] != leaveAck {
+		return fmt.Errorf("unexpected acknowledgement %#x", ack[0])
+	}
+	return nil
+}
+
+// confirmLeave acknowledges a PeerLeaveAnnounce. Peers that gossiped the leave on do not wait for it.
+func confirmLeave(conn net.Conn) {
+	_ = conn.SetWriteDeadline(time.Now().Add(enum.LeaveTimeout))
+	_, _ = conn.Write([]byte{leaveAck})
+}
+
+// deregister removes this node from the bootstrapper, so that it is not handed out to joining nodes anymore.
+// The bootstrapper only accepts it signed with the identity key the node registered with.
+func (node *GossipNode) deregister(ctx context.Context) error {
+	if len(node.bootstrapURLs) == 0 {
+		return nil
+	}
+
+	return node.tryBootstrappers(ctx, 1, func(bootstrapURL string) error {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		signature := ed25519.Sign(node.identityKey, []byte("deregister "+node.p2pAddress+" "+timestamp))
+		form := url.Values{
+			"peer":      {node.p2pAddress},
+			"timestamp": {timestamp},
+			"signature": {base64.StdEncoding.EncodeToString(signature)},
+		}
+		request, err := http.NewRequestWithContext(ctx, http.MethodPost, bootstrapURL+"/deregister", strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		resp, err := bootstrapClient.Do(request)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("deregistration response status code not OK: %d", resp.StatusCode)
+		}
+		return nil
+	})
+}
+
+// drainSenders waits until the senders of all peers sent their queued messages, or ctx is done
+func (node *GossipNode) drainSenders(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for node.pendingSends.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d messages still queued: %w", node.pendingSends.Load(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// waitContext waits for wg, or until ctx is done
+func waitContext(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
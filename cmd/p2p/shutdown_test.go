@@ -0,0 +1,54 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// TestLeaveIsConfirmed checks that a peer has removed a leaving node once it confirmed the leave
+func TestLeaveIsConfirmed(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	peer := newTestNode(listener.Addr().String(), 0)
+	serveTestNode(t, peer, listener)
+
+	leaving := newTestNode("127.0.0.1:7000", 0)
+	leaving.peers[peer.p2pAddress] = struct{}{}
+	peer.peers[leaving.p2pAddress] = struct{}{}
+
+	leaveMsg := &pb.GossipMessage{From: leaving.p2pAddress, Type: int32(enum.PeerLeaveAnnounce), Payload: []byte(leaving.p2pAddress), Ttl: 5}
+	pow.CalculateAndAddNonce(leaveMsg)
+	data, err := serialize(leaveMsg)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), enum.LeaveTimeout)
+	defer cancel()
+	require.NoError(t, sendConfirmed(ctx, peer.p2pAddress, data))
+
+	peer.peersMutex.RLock()
+	defer peer.peersMutex.RUnlock()
+	assert.NotContains(t, peer.peers, leaving.p2pAddress)
+}
+
+// TestDrainSendersTimesOut checks that draining gives up on messages that cannot be sent
+func TestDrainSendersTimesOut(t *testing.T) {
+	node := newTestNode("127.0.0.1:7000", 0)
+	node.batchWindow = time.Hour
+	node.enqueue("127.0.0.1:1", &pb.GossipMessage{Type: 1}, logging.NewCustomLogger())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	assert.Error(t, node.drainSenders(ctx))
+}
@@ -1,13 +1,19 @@
 package p2p
 
 import (
+	"errors"
+	"fmt"
 	"github.com/golang/protobuf/proto"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
 	"math/rand"
 	"net"
 	"time"
 )
 
+// errMessageTooLarge is returned by send for messages exceeding enum.MaxP2PMessageSize
+var errMessageTooLarge = errors.New("message too large")
+
 // serialize converts a GossipMessage into a byte slice.
 func serialize(msg *pb.GossipMessage) ([]byte, error) {
 	return proto.Marshal(msg)
@@ -23,8 +29,21 @@ func deserialize(data []byte) (*pb.GossipMessage, error) {
 	return &msg, nil
 }
 
-// send sends a GossipMessage to the specified address over TCP.
-func send(address string, msg *pb.GossipMessage) error {
+// send sends a GossipMessage to the specified address over TCP, compressed if the peer negotiated it.
+func (node *GossipNode) send(address string, msg *pb.GossipMessage) error {
+	wireMsg, err := node.compression.encode(msg, node.p2pAddress, address)
+	if err != nil {
+		return err
+	}
+
+	data, err := serialize(wireMsg)
+	if err != nil {
+		return err
+	}
+	if len(data) > enum.MaxP2PMessageSize {
+		return fmt.Errorf("%w: %d bytes exceed %d bytes", errMessageTooLarge, len(data), enum.MaxP2PMessageSize)
+	}
+
 	conn, err := net.Dial("tcp", address)
 	if err != nil {
 		return err
@@ -36,11 +55,6 @@ func send(address string, msg *pb.GossipMessage) error {
 		}
 	}(conn)
 
-	data, err := serialize(msg)
-	if err != nil {
-		return err
-	}
-
 	_, err = conn.Write(data)
 	return err
 }
@@ -52,6 +66,9 @@ func generate16BitRandomInteger() uint16 {
 
 // function for caching message ID
 func (node *GossipNode) addToCache(msgID string) {
+	node.messageIDCacheMutex.Lock()
+	defer node.messageIDCacheMutex.Unlock()
+
 	for _, id := range node.messageIDCache {
 		if id == msgID {
 			return
@@ -67,6 +84,9 @@ func (node *GossipNode) addToCache(msgID string) {
 }
 
 func (node *GossipNode) isMessageCached(msgID string) bool {
+	node.messageIDCacheMutex.Lock()
+	defer node.messageIDCacheMutex.Unlock()
+
 	for _, id := range node.messageIDCache {
 		if id == msgID {
 			return true
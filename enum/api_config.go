@@ -0,0 +1,42 @@
+package enum
+
+import "time"
+
+/*
+•	SubscriptionBufferSize: Number of notifications buffered per API subscriber before new ones are dropped.
+•	GatewayReadTimeout: Maximum time the HTTP gateway waits for a request to be read.
+•	GatewayKeepAliveInterval: Interval at which the HTTP gateway sends keep-alive comments on notification streams.
+•	APISocketMode: Default file permissions of the API unix domain socket.
+•	MessageDatatypeCacheSize: Number of notified message IDs whose datatype is remembered for validation access checks.
+•	ValidationTimeout: Default time a validation policy waits for module validations before deciding on a message.
+•	DurableQueueMaxEntries: Default number of notifications kept in the durable queue of a module.
+•	DurableQueueMaxAge: Default time notifications are kept in the durable queue of a module.
+•	DurableQueueDedupWindow: Time within which a durable queue drops notifications whose message ID it queued already.
+•	WebhookTimeout: Maximum time a single webhook request may take.
+•	WebhookMaxRetries: Default number of times a failed webhook delivery is retried before it is dead-lettered.
+•	WebhookMinBackoff, WebhookMaxBackoff: Bounds of the exponential backoff between webhook delivery attempts.
+•	WebhookMaxInFlight: Number of notifications a webhook delivers at the same time; further ones are dead-lettered.
+•	MaxAnnounceSize: Largest payload the HTTP gateway and the gRPC service accept for an announce.
+•	APIShutdownTimeout: Maximum time the HTTP gateway and the gRPC service wait for open requests and streams on shutdown.
+•	AnnounceTimeout: Maximum time an announce waits for the P2P node to take it, which is busy while it computes the proof of work of earlier ones.
+*/
+
+const (
+	SubscriptionBufferSize   = 64
+	GatewayReadTimeout       = 10 * time.Second
+	GatewayKeepAliveInterval = 15 * time.Second
+	APISocketMode            = 0660
+	MessageDatatypeCacheSize = 4096
+	ValidationTimeout        = 2 * time.Second
+	DurableQueueMaxEntries   = 1024
+	DurableQueueMaxAge       = time.Hour
+	DurableQueueDedupWindow  = time.Minute
+	WebhookTimeout           = 5 * time.Second
+	WebhookMaxRetries        = 5
+	WebhookMinBackoff        = 500 * time.Millisecond
+	WebhookMaxBackoff        = 30 * time.Second
+	WebhookMaxInFlight       = 64
+	MaxAnnounceSize          = 1024 * 1024
+	APIShutdownTimeout       = 5 * time.Second
+	AnnounceTimeout          = 5 * time.Second
+)
@@ -9,6 +9,26 @@ import "time"
 •	HeartbeatTicker: The interval at which a node sends a heartbeat message to the bootstrapper to signal that it is still active.
 •	GossipInterval: The time interval at which nodes send gossip messages to other nodes in the network to spread information.
 •	SeedNodeLimit: Maximum number of SeedNode in the network
+•	ReplicationInterval: The time interval at which a bootstrapper pushes its registry to the other bootstrappers of its cluster.
+•	BootstrapAttempts: Number of rounds a node tries all configured bootstrappers before it gives up.
+•	BootstrapMinBackoff: Initial time a node waits before it tries all bootstrappers again; doubled per round.
+•	BootstrapMaxBackoff: Maximum time a node waits before it tries all bootstrappers again.
+•	BootstrapRequestTimeout: Maximum time a node waits for a bootstrapper to answer one request.
+•	PeerSubsetSize: Default number of peers the bootstrapper returns per /peers request.
+•	BootstrapperReadTimeout: Maximum time the bootstrapper waits for a request to be read.
+•	BootstrapperWriteTimeout: Maximum time the bootstrapper spends writing a response.
+•	BootstrapperShutdownTimeout: Maximum time the bootstrapper waits for open requests on shutdown.
+•	SeedElectionInterval: Default time interval at which the bootstrapper elects the seed nodes again.
+•	SeedFullUptime: Uptime from which a peer gets the full uptime rating in seed elections.
+•	SeedIncumbentBonus: Score added to current seed nodes in seed elections, so that seeds do not change for small differences.
+•	RegistrationDifficulty: Default number of leading zero hex digits the registration proof of work needs; 0 disables it.
+•	ChallengeTTL: Time a registration challenge can be answered.
+•	RegistrationRate: Default number of registration requests per minute accepted from one source IP.
+•	RegistrationBurst: Number of registration requests one source IP may send at once.
+•	ProbeTimeout: Maximum time the bootstrapper waits for a node to answer its reachability probe.
+•	DeregistrationMaxAge: Maximum age of the signed timestamp of a deregistration, so that it cannot be replayed later.
+•	MaxHeartbeatSize: Maximum size in bytes of the status a node reports with its heartbeat.
+•	PeerStoreCompactAfter: Number of write-ahead log entries after which the bootstrapper writes a new snapshot of its peer registry.
 */
 /*
 const (
@@ -27,4 +47,27 @@ const (
 	HeartbeatTicker         = 60 * time.Second
 	GossipInterval          = 60 * time.Second
 	SeedNodeLimit           = 3
+	PeerStoreCompactAfter   = 1000
+	ReplicationInterval     = 15 * time.Second
+	BootstrapAttempts       = 5
+	BootstrapMinBackoff     = 1 * time.Second
+	BootstrapMaxBackoff     = 30 * time.Second
+	BootstrapRequestTimeout = 10 * time.Second
+
+	PeerSubsetSize              = 5
+	BootstrapperReadTimeout     = 10 * time.Second
+	BootstrapperWriteTimeout    = 10 * time.Second
+	BootstrapperShutdownTimeout = 10 * time.Second
+
+	SeedElectionInterval = 60 * time.Second
+	SeedFullUptime       = time.Hour
+	SeedIncumbentBonus   = 0.1
+
+	RegistrationDifficulty = 5
+	ChallengeTTL           = 60 * time.Second
+	RegistrationRate       = 10
+	RegistrationBurst      = 5
+	ProbeTimeout           = 5 * time.Second
+	DeregistrationMaxAge   = 60 * time.Second
+	MaxHeartbeatSize       = 4096
 )
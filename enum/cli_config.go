@@ -0,0 +1,13 @@
+package enum
+
+/*
+•	ExitOK: Exit code of the command line tools (client, gossip-log) when they succeeded.
+•	ExitError: Exit code when the command failed, e.g. because the node could not be reached.
+•	ExitUsage: Exit code when the command was invoked with wrong arguments.
+*/
+
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
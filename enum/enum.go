@@ -1,19 +1,32 @@
 package enum
 
 const (
-	GossipAnnounce     uint16 = 500
-	GossipNotify       uint16 = 501
-	GossipNotification uint16 = 502
-	GossipValidation   uint16 = 503
+	GossipAnnounce           uint16 = 500
+	GossipNotify             uint16 = 501
+	GossipNotification       uint16 = 502
+	GossipValidation         uint16 = 503
+	GossipAuth               uint16 = 504
+	GossipResume             uint16 = 505
+	GossipQueuedNotification uint16 = 506
+	GossipAnnounceResult     uint16 = 507
 
 	PeerJoinAnnounce  uint16 = 511
 	PeerLeaveAnnounce uint16 = 512
 	PeerListRequest   uint16 = 513
 	PeerListResponse  uint16 = 514
+	HistoryRequest    uint16 = 515
+	HistoryResponse   uint16 = 516
+	PayloadRequest    uint16 = 517
+	MessageFragment   uint16 = 518
+	MessageBatch      uint16 = 519
+	ReachabilityProbe uint16 = 520
 )
 
 var Difficulty = "0000"
 
+// Version is reported to the bootstrapper in heartbeats, release builds set it with -ldflags "-X .../enum.Version=..."
+var Version = "dev"
+
 // Datatype is used to identify the application data Gossip spreads in the network.
 type Datatype uint16
 
@@ -44,3 +57,26 @@ type ValidationMsg struct {
 	MessageID uint16 `json:"message_id"`
 	Reserved  uint16 `json:"reserved"`
 }
+
+// ResumeMsg represents the structure for GOSSIP RESUME message. A set lowest Flags bit marks Sequence,
+// the sequence number of the last queued notification the module received, as resume token.
+type ResumeMsg struct {
+	Flags    uint16 `json:"flags"`
+	Reserved uint16 `json:"reserved"`
+	Sequence uint64 `json:"sequence"`
+	Identity string `json:"identity"`
+}
+
+// NodeStatus is the telemetry a node reports to the bootstrapper as JSON with every heartbeat. Neighbours is nil for
+// nodes that do not report their neighbours yet.
+type NodeStatus struct {
+	Peer            string   `json:"peer"`
+	PeerCount       int      `json:"peer_count"`
+	Seed            bool     `json:"seed"`
+	MessagesRelayed uint64   `json:"messages_relayed"`
+	CacheSize       int      `json:"cache_size"`
+	Load            float64  `json:"load"`
+	Version         string   `json:"version"`
+	Features        []string `json:"features"`
+	Neighbours      []string `json:"neighbours"`
+}
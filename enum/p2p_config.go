@@ -0,0 +1,57 @@
+package enum
+
+import "time"
+
+/*
+•	MessageLogSegmentSize: Default size in bytes after which the message log starts a new segment.
+•	MessageLogSegments: Default number of message log segments kept on disk.
+•	HistoryMaxMessages: Number of application messages retained per datatype for late joiners.
+•	HistoryMaxBytes: Total payload bytes retained per datatype for late joiners.
+•	HistoryMaxAge: Time an application message is retained for late joiners.
+•	LazyPayloadThreshold: Default payload size in bytes above which only the content hash is gossiped; 0 disables lazy transfer.
+•	MaxLazyPayloadSize: Largest payload accepted by lazy transfer.
+•	PayloadCacheBytes: Total size of the payloads a node keeps to serve lazy transfers.
+•	PayloadFetchTimeout: Maximum time spent fetching a lazily transferred payload from one peer.
+•	MaxP2PMessageSize: Largest serialized message accepted on a P2P connection; larger messages are rejected with an error.
+•	P2PReadTimeout: Maximum time spent reading one message from a P2P connection.
+•	FragmentChunkSize: Payload bytes carried per fragment of a message that does not fit into one P2P message.
+•	MaxFragmentedPayloadSize: Largest payload that is split into fragments.
+•	FragmentTimeout: Time after which the fragments of an incomplete message are dropped.
+•	FragmentPendingPerPeer: Number of incomplete messages a peer may start before its further fragments are dropped.
+•	FragmentPendingBytes: Total size of the fragments buffered for incomplete messages.
+•	BatchWindow: Default time gossip messages queued for the same peer are collected to be sent together; 0 disables batching.
+•	SendQueueSize: Number of gossip messages queued per peer before further messages are dropped.
+•	SenderIdleTimeout: Time after which the sender of a peer without queued messages stops.
+•	CompressionThreshold: Default payload size in bytes above which payloads are compressed for peers that support it; 0 disables compression.
+•	PeerCacheFile: Default file a node saves its peer table to on shutdown and reloads it from at start.
+•	PeerCacheMaxAge: Age after which a saved peer table is not used anymore.
+•	LeaveTimeout: Maximum time a leaving node waits for its peers to confirm the leave.
+•	NodeShutdownTimeout: Maximum time a node spends leaving the network, including LeaveTimeout.
+*/
+
+const (
+	MessageLogSegmentSize    = 16 * 1024 * 1024
+	MessageLogSegments       = 8
+	HistoryMaxMessages       = 100
+	HistoryMaxBytes          = 1024 * 1024
+	HistoryMaxAge            = 10 * time.Minute
+	LazyPayloadThreshold     = 2048
+	MaxLazyPayloadSize       = 16 * 1024 * 1024
+	PayloadCacheBytes        = 64 * 1024 * 1024
+	PayloadFetchTimeout      = 5 * time.Second
+	MaxP2PMessageSize        = 4096
+	P2PReadTimeout           = 10 * time.Second
+	FragmentChunkSize        = 2048
+	MaxFragmentedPayloadSize = 1024 * 1024
+	FragmentTimeout          = 30 * time.Second
+	FragmentPendingPerPeer   = 8
+	FragmentPendingBytes     = 16 * 1024 * 1024
+	CompressionThreshold     = 512
+	BatchWindow              = 5 * time.Millisecond
+	SendQueueSize            = 256
+	SenderIdleTimeout        = time.Minute
+	PeerCacheFile            = "peer-cache.json"
+	PeerCacheMaxAge          = 24 * time.Hour
+	LeaveTimeout             = 3 * time.Second
+	NodeShutdownTimeout      = 10 * time.Second
+)
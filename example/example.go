@@ -1,12 +1,13 @@
 package main
 
 import (
-	"bytes"
-	"encoding/binary"
+	"context"
 	"fmt"
+	"time"
+
 	"github.com/robfig/config"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
-	"net"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/gossipclient"
 )
 
 func main() {
@@ -14,142 +15,33 @@ func main() {
 
 	apiAddress, _ := configFile.String("gossip", "api_address")
 
-	tests := []struct {
-		name        string
-		messageType uint16
-		message     []byte
-	}{
-		{
-			name:        "AnnounceMsg",
-			messageType: enum.GossipAnnounce,
-			message:     createAnnounceMessage(),
-		},
-		{
-			name:        "NotifyMsg",
-			messageType: enum.GossipNotify,
-			message:     createNotifyMessage(),
-		},
-		{
-			name:        "NotificationMsg",
-			messageType: enum.GossipNotification,
-			message:     createNotificationMessage(),
-		},
-		{
-			name:        "ValidationMsg",
-			messageType: enum.GossipValidation,
-			message:     createValidationMessage(),
-		},
-	}
-
-	for _, tt := range tests {
-		var writeBuffer bytes.Buffer
-
-		_ = binary.Write(&writeBuffer, binary.BigEndian, uint16(len(tt.message)+4))
-
-		_ = binary.Write(&writeBuffer, binary.BigEndian, tt.messageType)
-
-		_ = binary.Write(&writeBuffer, binary.BigEndian, tt.message)
-
-		conn, _ := net.Dial("tcp", apiAddress)
-
-		write, _ := conn.Write(writeBuffer.Bytes())
-		fmt.Printf("FROM CLIENT: Sent %d bytes for %s.\n", write, tt.name)
-
-		var msgBytes []byte
-
-		/*_ = conn.SetReadDeadline(time.Now().Add(1 * time.Second))*/
-
-		// Read tokens delimited by newline
-		msg, err := conn.Read(msgBytes)
-		if err != nil {
-			fmt.Println(err)
-		}
-
-		fmt.Printf("FROM CLIENT: Received %d bytes.\n", msg)
-	}
-}
-
-func createAnnounceMessage() []byte {
 	const (
 		TTL         = uint8(1)
-		RESERVED    = uint8(1)
-		DATATYPE    = uint16(1)
+		DATATYPE    = enum.Datatype(1)
 		MessageData = "Calling announce"
 	)
 
-	var buffer bytes.Buffer
-
-	err := binary.Write(&buffer, binary.BigEndian, TTL)
-
-	err = binary.Write(&buffer, binary.BigEndian, RESERVED)
-
-	err = binary.Write(&buffer, binary.BigEndian, DATATYPE)
-
-	err = binary.Write(&buffer, binary.BigEndian, []byte(MessageData))
-
-	if err != nil {
-		panic(err)
-	}
-
-	return buffer.Bytes()
-}
-
-func createNotifyMessage() []byte {
-	const (
-		RESERVED = uint16(1)
-		DATATYPE = uint16(2)
-	)
-
-	var buffer bytes.Buffer
+	client := gossipclient.New(apiAddress, gossipclient.WithErrorHandler(func(err error) {
+		fmt.Println(err)
+	}))
+	defer client.Close()
 
-	err := binary.Write(&buffer, binary.BigEndian, RESERVED)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
-	err = binary.Write(&buffer, binary.BigEndian, DATATYPE)
+	notifications := client.Subscribe(ctx, DATATYPE)
 
-	if err != nil {
-		panic(err)
+	if err := client.Announce(ctx, TTL, DATATYPE, []byte(MessageData)); err != nil {
+		fmt.Println(err)
+		return
 	}
+	fmt.Printf("FROM CLIENT: Sent announce of type %d.\n", DATATYPE)
 
-	return buffer.Bytes()
-}
+	for notification := range notifications {
+		fmt.Printf("FROM CLIENT: Received notification %d with %q.\n", notification.MessageID, notification.Data)
 
-func createNotificationMessage() []byte {
-	const (
-		MessageID   = uint16(1)
-		DATATYPE    = uint16(3)
-		MessageData = "Notification data"
-	)
-
-	var buffer bytes.Buffer
-
-	err := binary.Write(&buffer, binary.BigEndian, MessageID)
-
-	err = binary.Write(&buffer, binary.BigEndian, DATATYPE)
-
-	err = binary.Write(&buffer, binary.BigEndian, []byte(MessageData))
-
-	if err != nil {
-		panic(err)
-	}
-
-	return buffer.Bytes()
-}
-
-func createValidationMessage() []byte {
-	const (
-		MessageID = uint16(2)
-		RESERVED  = uint16(2)
-	)
-
-	var buffer bytes.Buffer
-
-	err := binary.Write(&buffer, binary.BigEndian, MessageID)
-
-	err = binary.Write(&buffer, binary.BigEndian, RESERVED)
-
-	if err != nil {
-		panic(err)
+		if err := client.Validate(notification.MessageID, true); err != nil {
+			fmt.Println(err)
+		}
 	}
-
-	return buffer.Bytes()
 }
@@ -0,0 +1,431 @@
+package bootstrapper
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/peerstore"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/ratelimit"
+)
+
+// Bootstrapper is the registry joining nodes get their first peers from. It elects the seed nodes, keeps track of
+// the overlay the nodes report and replicates its registry to the other bootstrappers of its cluster.
+type Bootstrapper struct {
+	mu                  sync.RWMutex
+	peersTimeoutList    map[string]time.Time
+	seedNodes           []string
+	seedNodeLimit       int
+	subsetSize          int
+	timeout             time.Duration
+	cleanupListInterval time.Duration
+	replicationInterval time.Duration
+	electionInterval    time.Duration
+	seedSubnetCap       int
+	store               *peerstore.Store
+	replicas            []string
+	removed             map[string]time.Time
+	health              map[string]*peerHealth
+	publicKeys          map[string]ed25519.PublicKey
+	lastElection        seedElection
+	difficulty          int
+	verifyReachability  bool
+	challenges          map[string]issuedChallenge
+	limiter             *ratelimit.Limiter
+}
+
+// issuedChallenge is a registration challenge waiting to be answered, it can only be used for peer
+type issuedChallenge struct {
+	peer    string
+	expires time.Time
+}
+
+// replicationState is the registry a bootstrapper pushes to the other bootstrappers of its cluster.
+// Removed holds the time explicitly deregistered peers were removed, so that replicas do not restore them.
+// Seed flags are sent along, but replicas elect their seed nodes themselves.
+type replicationState struct {
+	Peers   []peerstore.Record   `json:"peers"`
+	Removed map[string]time.Time `json:"removed"`
+}
+
+// New creates a bootstrapper replicating its registry to the replicas of cfg.
+// If store is not nil, the registry is restored from it and every change is persisted.
+func New(cfg Config, store *peerstore.Store) *Bootstrapper {
+	b := &Bootstrapper{
+		peersTimeoutList:    make(map[string]time.Time),
+		seedNodes:           []string{},
+		seedNodeLimit:       cfg.SeedLimit,
+		subsetSize:          cfg.SubsetSize,
+		timeout:             cfg.Timeout,
+		cleanupListInterval: cfg.CleanupInterval, // Set a timeout for node inactivity
+		replicationInterval: cfg.ReplicationInterval,
+		electionInterval:    cfg.ElectionInterval,
+		seedSubnetCap:       cfg.SeedSubnetCap,
+		store:               store,
+		replicas:            cfg.Replicas,
+		removed:             make(map[string]time.Time),
+		health:              make(map[string]*peerHealth),
+		publicKeys:          make(map[string]ed25519.PublicKey),
+		difficulty:          cfg.RegistrationDifficulty,
+		verifyReachability:  cfg.VerifyReachability,
+		challenges:          make(map[string]issuedChallenge),
+	}
+	if cfg.RegistrationRate > 0 {
+		b.limiter = ratelimit.New(float64(cfg.RegistrationRate)/60, enum.RegistrationBurst)
+	}
+
+	if store != nil {
+		for _, record := range store.Records() {
+			b.peersTimeoutList[record.Peer] = record.LastSeen
+			b.health[record.Peer] = &peerHealth{registered: time.Now(), lastHeartbeat: record.LastSeen}
+			if len(record.PublicKey) == ed25519.PublicKeySize {
+				b.publicKeys[record.Peer] = record.PublicKey
+			}
+			if record.Seed && len(b.seedNodes) < b.seedNodeLimit {
+				b.seedNodes = append(b.seedNodes, record.Peer)
+			}
+		}
+	}
+	return b
+}
+
+// persist stores the current state of peer, or deletes it if the peer is not registered. b.mu must be held.
+func (b *Bootstrapper) persist(peer string) {
+	if b.store == nil {
+		return
+	}
+
+	logger := logging.NewCustomLogger()
+	lastSeen, exists := b.peersTimeoutList[peer]
+	var err error
+	if exists {
+		err = b.store.Put(b.record(peer, lastSeen))
+	} else {
+		err = b.store.Delete(peer)
+	}
+	if err != nil {
+		logger.ErrorF("Failed to persist peer %s: %v", peer, err)
+	}
+}
+
+// record returns the registry state of peer. b.mu must be held.
+func (b *Bootstrapper) record(peer string, lastSeen time.Time) peerstore.Record {
+	return peerstore.Record{Peer: peer, LastSeen: lastSeen, Seed: contains(b.seedNodes, peer), PublicKey: b.publicKeys[peer]}
+}
+
+// RegisterPeer registers a peer. Nodes register with the public key of their identity, which they later sign their
+// deregistration with; peers registered without one can only time out.
+func (b *Bootstrapper) RegisterPeer(w http.ResponseWriter, r *http.Request) {
+	logger := logging.NewCustomLogger()
+
+	// With a proof of work, registrations are already limited by the challenges issued
+	if b.difficulty == 0 && !b.allowRequest(w, r) {
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+
+	peer := r.FormValue("peer")
+	if peer == "" {
+		http.Error(w, "Missing peer", http.StatusBadRequest)
+		return
+	}
+
+	if err := b.verifyChallenge(peer, r.FormValue("challenge"), r.FormValue("nonce")); err != nil {
+		logger.ErrorF("Rejected registration of %s: %v", peer, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	var publicKey ed25519.PublicKey
+	if rawPublicKey := r.FormValue("public_key"); rawPublicKey != "" || b.verifyReachability {
+		var err error
+		if publicKey, err = parsePublicKey(rawPublicKey); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if b.verifyReachability {
+		if err := probeReachability(peer, publicKey); err != nil {
+			logger.ErrorF("Rejected registration of %s: %v", peer, err)
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+	}
+
+	b.mu.Lock()
+
+	now := time.Now()
+	if _, exists := b.health[peer]; !exists {
+		b.health[peer] = &peerHealth{registered: now, lastHeartbeat: now}
+	}
+
+	// Free seed slots are filled right away, the periodic election rebalances them by health
+	if len(b.seedNodes) < b.seedNodeLimit && !contains(b.seedNodes, peer) && b.subnetHasRoom(peer, b.seedNodes) {
+		logger.InfoF("Peer %s Registered as seed", peer)
+		b.seedNodes = append(b.seedNodes, peer)
+	}
+
+	b.peersTimeoutList[peer] = now
+	if publicKey != nil {
+		b.publicKeys[peer] = publicKey
+	}
+	delete(b.removed, peer)
+	b.persist(peer)
+	b.mu.Unlock()
+	w.WriteHeader(http.StatusOK)
+	logger.InfoF("Peer %s registered successfully", peer)
+
+	b.printRegisteredPeers()
+	b.printSeedNodes()
+}
+
+// DeregisterPeer removes a peer that leaves the network. The peer signs "deregister <peer> <timestamp>" with the key
+// it registered with and sends it along with the timestamp in Unix seconds.
+func (b *Bootstrapper) DeregisterPeer(w http.ResponseWriter, r *http.Request) {
+	logger := logging.NewCustomLogger()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "Unable to parse form", http.StatusBadRequest)
+		return
+	}
+	peer := r.FormValue("peer")
+	if peer == "" {
+		http.Error(w, "Missing peer", http.StatusBadRequest)
+		return
+	}
+
+	b.mu.Lock()
+	if err := b.verifyDeregistration(peer, r.FormValue("timestamp"), r.FormValue("signature")); err != nil {
+		b.mu.Unlock()
+		logger.ErrorF("Rejected deregistration of %s: %v", peer, err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	b.removePeer(peer)
+	b.removed[peer] = time.Now()
+	b.mu.Unlock()
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// GetPeers hands out a subset of the registered peers to a joining node. It picks the peers with the lowest
+// estimated in-degree, including one seed node, so that connections spread evenly over the network instead of
+// concentrating on the seeds. The optional exclude parameter lists addresses not to hand out, usually the requester
+// and its current peers, separated by commas.
+func (b *Bootstrapper) GetPeers(w http.ResponseWriter, r *http.Request) {
+	excluded := make(map[string]bool)
+	for _, peer := range splitList(r.URL.Query().Get("exclude")) {
+		excluded[peer] = true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	peers := make([]string, 0, len(b.peersTimeoutList))
+	for peer := range b.peersTimeoutList {
+		if !excluded[peer] {
+			peers = append(peers, peer)
+		}
+	}
+	// Shuffled first, so that peers with the same in-degree are handed out in turn
+	rand.Shuffle(len(peers), func(i, j int) { peers[i], peers[j] = peers[j], peers[i] })
+	sort.SliceStable(peers, func(i, j int) bool { return b.estimatedInDegree(peers[i]) < b.estimatedInDegree(peers[j]) })
+
+	subsetSize := min(b.subsetSize, len(peers)) // Limit the number of peers returned to a subset
+
+	hasSeed := false
+	for _, seed := range b.seedNodes {
+		hasSeed = hasSeed || excluded[seed]
+	}
+
+	partialPeers := make([]string, 0, subsetSize)
+	// Unless the requester is connected to a seed node already, ensure one is added, the least connected one
+	for _, peer := range peers {
+		if hasSeed || len(partialPeers) == subsetSize {
+			break
+		}
+		if contains(b.seedNodes, peer) {
+			partialPeers = append(partialPeers, peer)
+			hasSeed = true
+		}
+	}
+
+	// Fill remaining partialPeers with the least connected peers
+	for _, peer := range peers {
+		if !contains(partialPeers, peer) && len(partialPeers) < subsetSize {
+			partialPeers = append(partialPeers, peer)
+		}
+	}
+
+	for _, peer := range partialPeers {
+		b.healthOf(peer).handouts++
+	}
+
+	response := map[string][]string{
+		"partialPeers": partialPeers,
+		"seedNodes":    append([]string{}, b.seedNodes...),
+	}
+
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		http.Error(w, "Unable to encode peers", http.StatusInternalServerError)
+	}
+}
+
+// estimatedInDegree estimates how many nodes are connected to peer: the number of peers it reported with its latest
+// heartbeat plus the nodes it was handed out to since. Nodes reporting their neighbours are counted by them, the peer
+// count of others cannot exceed the number of registered peers. b.mu must be held.
+func (b *Bootstrapper) estimatedInDegree(peer string) int {
+	health, exists := b.health[peer]
+	if !exists {
+		return 0
+	}
+	degree := min(health.status.PeerCount, len(b.peersTimeoutList))
+	if health.status.Neighbours != nil {
+		degree = len(health.status.Neighbours)
+	}
+	return degree + health.handouts
+}
+
+// HandleHeartbeat refreshes a registered peer. Nodes POST their status as JSON; older nodes send a GET with the
+// peer and optionally its load as query parameters.
+func (b *Bootstrapper) HandleHeartbeat(w http.ResponseWriter, r *http.Request) {
+	logger := logging.NewCustomLogger()
+
+	var status enum.NodeStatus
+	if r.Method == http.MethodPost {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, enum.MaxHeartbeatSize)).Decode(&status); err != nil {
+			http.Error(w, "Invalid status", http.StatusBadRequest)
+			return
+		}
+	} else {
+		status.Peer = r.URL.Query().Get("peer")
+		if rawLoad := r.URL.Query().Get("load"); rawLoad != "" {
+			var err error
+			if status.Load, err = strconv.ParseFloat(rawLoad, 64); err != nil {
+				http.Error(w, "Invalid load", http.StatusBadRequest)
+				return
+			}
+		}
+	}
+
+	peer := status.Peer
+	if peer == "" {
+		http.Error(w, "Missing peer", http.StatusBadRequest)
+		return
+	}
+	// A negative count would put the peer in front of every handout
+	if status.PeerCount < 0 {
+		http.Error(w, "Invalid peer count", http.StatusBadRequest)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.peersTimeoutList[peer]; exists {
+		now := time.Now()
+		b.peersTimeoutList[peer] = now
+		health := b.healthOf(peer)
+		health.heartbeat(now, status.Load)
+		// Older nodes do not report a status, the handouts are all that is known of their in-degree then
+		if r.Method == http.MethodPost {
+			health.report(status)
+		}
+		b.persist(peer)
+		w.WriteHeader(http.StatusOK)
+	} else {
+		logger.Error("Peer is not registered with Bootstrapping Server")
+
+		http.Error(w, "Peer not registered", http.StatusBadRequest)
+	}
+}
+
+// RemoveInactivePeers periodically removes peers without heartbeat until ctx is done
+func (b *Bootstrapper) RemoveInactivePeers(ctx context.Context) {
+	logger := logging.NewCustomLogger()
+
+	ticker := time.NewTicker(b.cleanupListInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			for peer, lastSeen := range b.peersTimeoutList {
+
+				if time.Since(lastSeen) > b.timeout {
+					logger.InfoF("Removing inactive peer: %s", peer)
+					b.removePeer(peer)
+				}
+			}
+			for peer, removedAt := range b.removed {
+				if time.Since(removedAt) > b.timeout {
+					delete(b.removed, peer)
+				}
+			}
+			b.mu.Unlock()
+		}
+	}
+}
+
+// removePeer removes peer from the registry and the seed nodes. b.mu must be held.
+func (b *Bootstrapper) removePeer(peer string) {
+	delete(b.peersTimeoutList, peer)
+	delete(b.health, peer)
+	delete(b.publicKeys, peer)
+	for i, seed := range b.seedNodes {
+		if seed == peer {
+			b.seedNodes = append(b.seedNodes[:i], b.seedNodes[i+1:]...)
+			break
+		}
+	}
+	b.persist(peer)
+}
+
+func (b *Bootstrapper) printRegisteredPeers() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	logger := logging.NewCustomLogger()
+	logger.Info("Current list of registered peers:")
+
+	counter := 1
+	for peer := range b.peersTimeoutList {
+		logger.InfoF("%d: %s", counter, peer)
+		counter++
+	}
+}
+
+func (b *Bootstrapper) printSeedNodes() {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	logger := logging.NewCustomLogger()
+	logger.Info("Current list of seed nodes:")
+
+	for i, peer := range b.seedNodes {
+		logger.InfoF("%d: %s", i+1, peer)
+	}
+}
+
+func contains(peers []string, peer string) bool {
+	for _, p := range peers {
+		if p == peer {
+			return true
+		}
+	}
+	return false
+}
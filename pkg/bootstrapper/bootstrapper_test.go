@@ -0,0 +1,157 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// getPeers requests /peers with the given query and returns the handed out peers
+func getPeers(t *testing.T, b *Bootstrapper, query string) []string {
+	recorder := httptest.NewRecorder()
+	b.GetPeers(recorder, httptest.NewRequest(http.MethodGet, "/peers"+query, nil))
+	require.Equal(t, http.StatusOK, recorder.Code)
+
+	var response map[string][]string
+	require.NoError(t, json.NewDecoder(recorder.Body).Decode(&response))
+	return response["partialPeers"]
+}
+
+// addPeerWithDegree registers peer as having reported peerCount connections
+func addPeerWithDegree(b *Bootstrapper, peer string, peerCount int) {
+	addPeer(b, peer, time.Now(), 0)
+	b.health[peer].status.PeerCount = peerCount
+}
+
+func TestGetPeersLowestInDegree(t *testing.T) {
+	b := newTestBootstrapper(0, 0)
+	b.subsetSize = 2
+	addPeerWithDegree(b, "10.0.0.1:7001", 5)
+	addPeerWithDegree(b, "10.0.0.2:7001", 1)
+	addPeerWithDegree(b, "10.0.0.3:7001", 3)
+	addPeerWithDegree(b, "10.0.0.4:7001", 0)
+
+	assert.Equal(t, []string{"10.0.0.4:7001", "10.0.0.2:7001"}, getPeers(t, b, ""))
+	assert.Equal(t, 1, b.health["10.0.0.4:7001"].handouts)
+	assert.Equal(t, 1, b.health["10.0.0.2:7001"].handouts)
+
+	// The handouts count towards the in-degree until the peers report again
+	addPeerWithDegree(b, "10.0.0.5:7001", 1)
+	assert.ElementsMatch(t, []string{"10.0.0.4:7001", "10.0.0.5:7001"}, getPeers(t, b, ""))
+	assert.Equal(t, 2, b.health["10.0.0.4:7001"].handouts)
+}
+
+func TestGetPeersIncludesSeed(t *testing.T) {
+	b := newTestBootstrapper(2, 0)
+	b.subsetSize = 2
+	addPeerWithDegree(b, "10.0.0.1:7001", 4)
+	addPeerWithDegree(b, "10.0.0.2:7001", 3)
+	addPeerWithDegree(b, "10.0.0.3:7001", 1)
+	addPeerWithDegree(b, "10.0.0.4:7001", 2)
+	b.seedNodes = []string{"10.0.0.1:7001", "10.0.0.2:7001"}
+
+	// The least connected seed comes first, the rest are the least connected peers
+	assert.Equal(t, []string{"10.0.0.2:7001", "10.0.0.3:7001"}, getPeers(t, b, ""))
+
+	// A requester connected to a seed already gets no other seed. 10.0.0.3:7001 was handed out once, so it is on a
+	// par with 10.0.0.4:7001 now.
+	assert.ElementsMatch(t, []string{"10.0.0.3:7001", "10.0.0.4:7001"}, getPeers(t, b, "?exclude=10.0.0.1:7001"))
+}
+
+func TestGetPeersExclude(t *testing.T) {
+	b := newTestBootstrapper(0, 0)
+	b.subsetSize = 5
+	addPeerWithDegree(b, "10.0.0.1:7001", 0)
+	addPeerWithDegree(b, "10.0.0.2:7001", 0)
+	addPeerWithDegree(b, "10.0.0.3:7001", 0)
+
+	assert.ElementsMatch(t, []string{"10.0.0.2:7001"}, getPeers(t, b, "?exclude=10.0.0.1:7001,,10.0.0.3:7001"))
+	assert.Empty(t, getPeers(t, b, "?exclude=10.0.0.1:7001,10.0.0.2:7001,10.0.0.3:7001"))
+}
+
+// heartbeat POSTs status to /heartbeat and returns the response code
+func heartbeat(t *testing.T, b *Bootstrapper, status enum.NodeStatus) int {
+	body, err := json.Marshal(status)
+	require.NoError(t, err)
+	recorder := httptest.NewRecorder()
+	b.HandleHeartbeat(recorder, httptest.NewRequest(http.MethodPost, "/heartbeat", bytes.NewReader(body)))
+	return recorder.Code
+}
+
+func TestHeartbeatRejectsNegativePeerCount(t *testing.T) {
+	b := newTestBootstrapper(0, 0)
+	b.subsetSize = 1
+	addPeerWithDegree(b, "10.0.0.1:7001", 2)
+	addPeerWithDegree(b, "10.0.0.2:7001", 1)
+
+	assert.Equal(t, http.StatusBadRequest, heartbeat(t, b, enum.NodeStatus{Peer: "10.0.0.1:7001", PeerCount: -100}))
+	assert.Equal(t, 2, b.health["10.0.0.1:7001"].status.PeerCount)
+	assert.Equal(t, []string{"10.0.0.2:7001"}, getPeers(t, b, ""))
+}
+
+func TestInDegreeIgnoresImplausiblePeerCount(t *testing.T) {
+	b := newTestBootstrapper(0, 0)
+	addPeerWithDegree(b, "10.0.0.1:7001", 0)
+	addPeerWithDegree(b, "10.0.0.2:7001", 0)
+
+	// The reported neighbours count, not the peer count sent along with them
+	require.Equal(t, http.StatusOK, heartbeat(t, b, enum.NodeStatus{
+		Peer: "10.0.0.1:7001", PeerCount: 1000, Neighbours: []string{"10.0.0.2:7001"},
+	}))
+	assert.Equal(t, 1, b.estimatedInDegree("10.0.0.1:7001"))
+
+	// Without neighbours, no peer can have more connections than peers are registered
+	require.Equal(t, http.StatusOK, heartbeat(t, b, enum.NodeStatus{Peer: "10.0.0.2:7001", PeerCount: 1000}))
+	assert.Equal(t, 2, b.estimatedInDegree("10.0.0.2:7001"))
+}
+
+// deregister POSTs a deregistration of peer signed with key at timestamp and returns the response code
+func deregister(b *Bootstrapper, peer string, key ed25519.PrivateKey, timestamp time.Time) int {
+	rawTimestamp := strconv.FormatInt(timestamp.Unix(), 10)
+	form := url.Values{
+		"peer":      {peer},
+		"timestamp": {rawTimestamp},
+		"signature": {base64.StdEncoding.EncodeToString(ed25519.Sign(key, []byte("deregister "+peer+" "+rawTimestamp)))},
+	}
+	request := httptest.NewRequest(http.MethodPost, "/deregister", strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	recorder := httptest.NewRecorder()
+	b.DeregisterPeer(recorder, request)
+	return recorder.Code
+}
+
+func TestDeregisterPeerNeedsSignature(t *testing.T) {
+	publicKey, key, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	_, otherKey, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	b := newTestBootstrapper(0, 0)
+	addPeer(b, "10.0.0.1:7001", time.Now(), 0)
+	addPeer(b, "10.0.0.2:7001", time.Now(), 0)
+	b.publicKeys["10.0.0.1:7001"] = publicKey
+
+	assert.Equal(t, http.StatusForbidden, deregister(b, "10.0.0.1:7001", otherKey, time.Now()))
+	assert.Equal(t, http.StatusForbidden, deregister(b, "10.0.0.1:7001", key, time.Now().Add(-2*enum.DeregistrationMaxAge)))
+	// Peers registered without a key can only time out
+	assert.Equal(t, http.StatusForbidden, deregister(b, "10.0.0.2:7001", otherKey, time.Now()))
+	assert.Len(t, b.peersTimeoutList, 2)
+
+	assert.Equal(t, http.StatusOK, deregister(b, "10.0.0.1:7001", key, time.Now()))
+	assert.NotContains(t, b.peersTimeoutList, "10.0.0.1:7001")
+	assert.NotContains(t, b.publicKeys, "10.0.0.1:7001")
+	assert.Contains(t, b.removed, "10.0.0.1:7001")
+}
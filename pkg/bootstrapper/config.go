@@ -0,0 +1,176 @@
+package bootstrapper
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/config"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// Config holds the settings of the bootstrapper. They are read from the [bootstrapper] section of the
+// config file, and flags override them.
+type Config struct {
+	Address             string
+	DataDir             string
+	Replicas            []string
+	SubsetSize          int
+	SeedLimit           int
+	Timeout             time.Duration
+	CleanupInterval     time.Duration
+	ReplicationInterval time.Duration
+	ElectionInterval    time.Duration
+	SeedSubnetCap       int
+	// Registrations need a proof of work with this many leading zero hex digits, 0 disables it
+	RegistrationDifficulty int
+	// Registrations accepted per minute and source IP, 0 disables the limit
+	RegistrationRate   int
+	VerifyReachability bool
+}
+
+func defaultConfig() Config {
+	return Config{
+		Address:                ":8080",
+		SubsetSize:             enum.PeerSubsetSize,
+		SeedLimit:              enum.SeedNodeLimit,
+		Timeout:                enum.Timeout,
+		CleanupInterval:        enum.CleanupListInterval,
+		ReplicationInterval:    enum.ReplicationInterval,
+		ElectionInterval:       enum.SeedElectionInterval,
+		RegistrationDifficulty: enum.RegistrationDifficulty,
+		RegistrationRate:       enum.RegistrationRate,
+		VerifyReachability:     true,
+	}
+}
+
+// readFile applies the options set in the [bootstrapper] section of the config file at path
+func (c *Config) readFile(path string) error {
+	configFile, err := config.ReadDefault(path)
+	if err != nil {
+		return err
+	}
+
+	const section = "bootstrapper"
+	if configFile.HasOption(section, "address") {
+		if c.Address, err = configFile.String(section, "address"); err != nil {
+			return err
+		}
+	}
+	if configFile.HasOption(section, "data_dir") {
+		if c.DataDir, err = configFile.String(section, "data_dir"); err != nil {
+			return err
+		}
+	}
+	if configFile.HasOption(section, "replicas") {
+		replicas, err := configFile.String(section, "replicas")
+		if err != nil {
+			return err
+		}
+		c.Replicas = splitList(replicas)
+	}
+	for option, value := range map[string]*int{
+		"subset_size":             &c.SubsetSize,
+		"seed_limit":              &c.SeedLimit,
+		"seed_subnet_cap":         &c.SeedSubnetCap,
+		"registration_difficulty": &c.RegistrationDifficulty,
+		"registration_rate":       &c.RegistrationRate,
+	} {
+		if configFile.HasOption(section, option) {
+			if *value, err = configFile.Int(section, option); err != nil {
+				return fmt.Errorf("invalid %s: %w", option, err)
+			}
+		}
+	}
+	if configFile.HasOption(section, "verify_reachability") {
+		if c.VerifyReachability, err = configFile.Bool(section, "verify_reachability"); err != nil {
+			return fmt.Errorf("invalid verify_reachability: %w", err)
+		}
+	}
+	for option, value := range map[string]*time.Duration{
+		"timeout":              &c.Timeout,
+		"cleanup_interval":     &c.CleanupInterval,
+		"replication_interval": &c.ReplicationInterval,
+		"election_interval":    &c.ElectionInterval,
+	} {
+		if configFile.HasOption(section, option) {
+			raw, err := configFile.String(section, option)
+			if err != nil {
+				return err
+			}
+			if *value, err = time.ParseDuration(raw); err != nil {
+				return fmt.Errorf("invalid %s: %w", option, err)
+			}
+		}
+	}
+	return nil
+}
+
+// validate rejects settings the bootstrapper can not run with
+func (c *Config) validate() error {
+	if c.SubsetSize < 1 || c.SeedLimit < 0 || c.SeedSubnetCap < 0 {
+		return fmt.Errorf("subset size must be positive, seed limit and subnet cap must not be negative")
+	}
+	if c.RegistrationDifficulty < 0 || c.RegistrationDifficulty > 64 || c.RegistrationRate < 0 {
+		return fmt.Errorf("registration difficulty must be between 0 and 64, registration rate must not be negative")
+	}
+	if c.Timeout <= 0 || c.CleanupInterval <= 0 || c.ReplicationInterval <= 0 || c.ElectionInterval <= 0 {
+		return fmt.Errorf("timeout and intervals must be positive")
+	}
+	return nil
+}
+
+// ParseConfig reads the config file given by -c, if any, and applies the flags on top of it
+func ParseConfig(args []string) (Config, error) {
+	cfg := defaultConfig()
+
+	var configPath, replicas string
+	fs := flag.NewFlagSet("bootstrapper", flag.ContinueOnError)
+	fs.StringVar(&configPath, "c", "", "Config file with a [bootstrapper] section")
+	fs.StringVar(&cfg.Address, "addr", cfg.Address, "Address the bootstrapper listens on")
+	fs.StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "Directory the peer registry is persisted in; the registry is kept in memory only if empty")
+	fs.StringVar(&replicas, "replicas", "", "Comma-separated URLs of the other bootstrappers of the cluster")
+	fs.IntVar(&cfg.SubsetSize, "subset-size", cfg.SubsetSize, "Number of peers returned by /peers")
+	fs.IntVar(&cfg.SeedLimit, "seed-limit", cfg.SeedLimit, "Maximum number of seed nodes")
+	fs.DurationVar(&cfg.Timeout, "timeout", cfg.Timeout, "Time without heartbeat after which a peer is removed")
+	fs.DurationVar(&cfg.CleanupInterval, "cleanup-interval", cfg.CleanupInterval, "Interval at which inactive peers are removed")
+	fs.DurationVar(&cfg.ReplicationInterval, "replication-interval", cfg.ReplicationInterval, "Interval at which the registry is pushed to the replicas")
+	fs.DurationVar(&cfg.ElectionInterval, "election-interval", cfg.ElectionInterval, "Interval at which seed nodes are elected again")
+	fs.IntVar(&cfg.SeedSubnetCap, "seed-subnet-cap", cfg.SeedSubnetCap, "Maximum number of seed nodes per IPv4 /24 or IPv6 /64 subnet; 0 for no limit")
+	fs.IntVar(&cfg.RegistrationDifficulty, "registration-difficulty", cfg.RegistrationDifficulty, "Leading zero hex digits of the registration proof of work; 0 disables it")
+	fs.IntVar(&cfg.RegistrationRate, "registration-rate", cfg.RegistrationRate, "Registrations accepted per minute and source IP; 0 for no limit")
+	fs.BoolVar(&cfg.VerifyReachability, "verify-reachability", cfg.VerifyReachability, "Dial the P2P address of registering nodes and check their signed answer")
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if configPath != "" {
+		if err := cfg.readFile(configPath); err != nil {
+			return cfg, fmt.Errorf("can not read %s: %w", configPath, err)
+		}
+		// Parsing again lets the flags override the config file
+		if err := fs.Parse(args); err != nil {
+			return cfg, err
+		}
+	}
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == "replicas" {
+			cfg.Replicas = splitList(replicas)
+		}
+	})
+
+	return cfg, cfg.validate()
+}
+
+// splitList splits a comma-separated list, dropping empty items
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
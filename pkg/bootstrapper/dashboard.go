@@ -0,0 +1,203 @@
+package bootstrapper
+
+import (
+	"embed"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// dashboardFiles is the web UI served under /dashboard/, it polls /topology
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardNode is a node as shown by the dashboard. Nodes that are not registered are only known because a
+// registered node reported them as neighbour. Component is -1 for nodes whose connections are unknown, because
+// they did not report their neighbours and no other node reported them.
+type dashboardNode struct {
+	Peer              string           `json:"peer"`
+	Registered        bool             `json:"registered"`
+	Seed              bool             `json:"seed"`
+	RegisteredAt      *time.Time       `json:"registered_at,omitempty"`
+	LastSeen          *time.Time       `json:"last_seen,omitempty"`
+	EstimatedInDegree int              `json:"estimated_in_degree"`
+	Status            *enum.NodeStatus `json:"status,omitempty"`
+	Component         int              `json:"component"`
+}
+
+// topologyEdge is a connection a node reported to one of its neighbours
+type topologyEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// topology is the overlay as the bootstrapper knows it from the reported neighbour lists, served by /topology.
+// Components holds the connected parts of the overlay, largest first; more than one means it is partitioned.
+type topology struct {
+	GeneratedAt time.Time       `json:"generated_at"`
+	Nodes       []dashboardNode `json:"nodes"`
+	Seeds       []string        `json:"seeds"`
+	Edges       []topologyEdge  `json:"edges"`
+	Components  [][]string      `json:"components"`
+	Partitioned bool            `json:"partitioned"`
+	Isolated    []string        `json:"isolated"`
+	Unreported  []string        `json:"unreported"`
+}
+
+// topology takes a snapshot of the registered nodes and the overlay they reported
+func (b *Bootstrapper) topology() topology {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	result := topology{
+		GeneratedAt: time.Now(),
+		Seeds:       append([]string{}, b.seedNodes...),
+		Edges:       []topologyEdge{},
+		Components:  [][]string{},
+		Isolated:    []string{},
+		Unreported:  []string{},
+	}
+
+	nodes := make(map[string]*dashboardNode)
+	for peer, lastSeen := range b.peersTimeoutList {
+		node := &dashboardNode{
+			Peer:              peer,
+			Registered:        true,
+			Seed:              contains(b.seedNodes, peer),
+			LastSeen:          &lastSeen,
+			EstimatedInDegree: b.estimatedInDegree(peer),
+			Component:         -1,
+		}
+		if health, exists := b.health[peer]; exists {
+			registered := health.registered
+			node.RegisteredAt = &registered
+			// Only nodes that POST their heartbeats report a status
+			if health.status.Peer != "" {
+				status := health.status
+				node.Status = &status
+			}
+		}
+		nodes[peer] = node
+	}
+
+	// The overlay is undirected: a connection counts if either end reported it
+	neighbours := make(map[string]map[string]struct{})
+	link := func(from, to string) {
+		if neighbours[from] == nil {
+			neighbours[from] = make(map[string]struct{})
+		}
+		neighbours[from][to] = struct{}{}
+	}
+	for peer, node := range nodes {
+		// Nodes older than the neighbour lists report none at all, not an empty one
+		if node.Status == nil || node.Status.Neighbours == nil {
+			continue
+		}
+		link(peer, peer)
+		for _, neighbour := range node.Status.Neighbours {
+			if neighbour == peer {
+				continue
+			}
+			if _, exists := nodes[neighbour]; !exists {
+				nodes[neighbour] = &dashboardNode{Peer: neighbour, Component: -1}
+			}
+			result.Edges = append(result.Edges, topologyEdge{From: peer, To: neighbour})
+			link(peer, neighbour)
+			link(neighbour, peer)
+		}
+	}
+
+	for peer := range neighbours {
+		if nodes[peer].Component >= 0 {
+			continue
+		}
+		var component []string
+		queue := []string{peer}
+		nodes[peer].Component = len(result.Components)
+		for len(queue) > 0 {
+			current := queue[0]
+			queue = queue[1:]
+			component = append(component, current)
+			for neighbour := range neighbours[current] {
+				if nodes[neighbour].Component < 0 {
+					nodes[neighbour].Component = nodes[peer].Component
+					queue = append(queue, neighbour)
+				}
+			}
+		}
+		sort.Strings(component)
+		result.Components = append(result.Components, component)
+	}
+
+	// Number the components by size, so that the largest one is 0
+	order := make([]int, len(result.Components))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		first, second := result.Components[order[i]], result.Components[order[j]]
+		if len(first) != len(second) {
+			return len(first) > len(second)
+		}
+		return first[0] < second[0]
+	})
+	renumbered := make([]int, len(order))
+	components := make([][]string, len(order))
+	for i, component := range order {
+		renumbered[component] = i
+		components[i] = result.Components[component]
+	}
+	result.Components = components
+	result.Partitioned = len(components) > 1
+
+	for _, node := range nodes {
+		switch {
+		case node.Component >= 0:
+			node.Component = renumbered[node.Component]
+			if len(components[node.Component]) == 1 {
+				result.Isolated = append(result.Isolated, node.Peer)
+			}
+		case node.Registered:
+			result.Unreported = append(result.Unreported, node.Peer)
+		}
+		result.Nodes = append(result.Nodes, *node)
+	}
+
+	sort.Slice(result.Nodes, func(i, j int) bool { return result.Nodes[i].Peer < result.Nodes[j].Peer })
+	sort.Slice(result.Edges, func(i, j int) bool {
+		if result.Edges[i].From != result.Edges[j].From {
+			return result.Edges[i].From < result.Edges[j].From
+		}
+		return result.Edges[i].To < result.Edges[j].To
+	})
+	sort.Strings(result.Isolated)
+	sort.Strings(result.Unreported)
+	return result
+}
+
+// GetTopology serves the registered nodes, their telemetry and the overlay they reported
+func (b *Bootstrapper) GetTopology(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(b.topology()); err != nil {
+		http.Error(w, "Unable to encode topology", http.StatusInternalServerError)
+	}
+}
+
+// GetNodes serves the registered nodes with their last heartbeat and the telemetry they reported
+func (b *Bootstrapper) GetNodes(w http.ResponseWriter, r *http.Request) {
+	nodes := []dashboardNode{}
+	for _, node := range b.topology().Nodes {
+		if node.Registered {
+			nodes = append(nodes, node)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(nodes); err != nil {
+		http.Error(w, "Unable to encode nodes", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,76 @@
+package bootstrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// addReportingPeer registers peer as having reported neighbours with its heartbeat
+func addReportingPeer(b *Bootstrapper, peer string, neighbours ...string) {
+	addPeer(b, peer, time.Now(), 0)
+	b.health[peer].status = enum.NodeStatus{Peer: peer, PeerCount: len(neighbours), Neighbours: neighbours}
+}
+
+func TestTopology(t *testing.T) {
+	b := newTestBootstrapper(3, 0)
+	addReportingPeer(b, "10.0.0.1:7001", "10.0.0.2:7001")
+	addReportingPeer(b, "10.0.0.2:7001", "10.0.0.1:7001", "10.0.0.9:7001")
+	addReportingPeer(b, "10.0.0.3:7001", "10.0.0.4:7001")
+	// Not reporting, but reported by 10.0.0.3:7001
+	addPeer(b, "10.0.0.4:7001", time.Now(), 0)
+	addReportingPeer(b, "10.0.0.5:7001", []string{}...)
+	// Neither reporting nor reported
+	addPeer(b, "10.0.0.6:7001", time.Now(), 0)
+	b.seedNodes = []string{"10.0.0.1:7001"}
+
+	result := b.topology()
+
+	assert.Equal(t, [][]string{
+		{"10.0.0.1:7001", "10.0.0.2:7001", "10.0.0.9:7001"},
+		{"10.0.0.3:7001", "10.0.0.4:7001"},
+		{"10.0.0.5:7001"},
+	}, result.Components)
+	assert.True(t, result.Partitioned)
+	assert.Equal(t, []string{"10.0.0.5:7001"}, result.Isolated)
+	assert.Equal(t, []string{"10.0.0.6:7001"}, result.Unreported)
+	assert.Equal(t, []topologyEdge{
+		{From: "10.0.0.1:7001", To: "10.0.0.2:7001"},
+		{From: "10.0.0.2:7001", To: "10.0.0.1:7001"},
+		{From: "10.0.0.2:7001", To: "10.0.0.9:7001"},
+		{From: "10.0.0.3:7001", To: "10.0.0.4:7001"},
+	}, result.Edges)
+
+	components := make(map[string]int)
+	for _, node := range result.Nodes {
+		components[node.Peer] = node.Component
+		assert.Equal(t, node.Peer != "10.0.0.9:7001", node.Registered, node.Peer)
+		assert.Equal(t, node.Peer == "10.0.0.1:7001", node.Seed, node.Peer)
+	}
+	assert.Equal(t, map[string]int{
+		"10.0.0.1:7001": 0,
+		"10.0.0.2:7001": 0,
+		"10.0.0.9:7001": 0,
+		"10.0.0.3:7001": 1,
+		"10.0.0.4:7001": 1,
+		"10.0.0.5:7001": 2,
+		"10.0.0.6:7001": -1,
+	}, components)
+}
+
+func TestTopologyConnected(t *testing.T) {
+	b := newTestBootstrapper(3, 0)
+	addReportingPeer(b, "10.0.0.1:7001", "10.0.0.2:7001")
+	addReportingPeer(b, "10.0.0.2:7001", "10.0.0.3:7001")
+	addReportingPeer(b, "10.0.0.3:7001", "10.0.0.1:7001")
+
+	result := b.topology()
+
+	assert.Equal(t, [][]string{{"10.0.0.1:7001", "10.0.0.2:7001", "10.0.0.3:7001"}}, result.Components)
+	assert.False(t, result.Partitioned)
+	assert.Empty(t, result.Isolated)
+	assert.Empty(t, result.Unreported)
+}
@@ -0,0 +1,219 @@
+package bootstrapper
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+)
+
+// peerHealth is what the bootstrapper observed of a peer since it registered
+type peerHealth struct {
+	registered    time.Time
+	lastHeartbeat time.Time
+	// gaps, gapMean and gapM2 summarize the intervals between heartbeats (Welford's online algorithm)
+	gaps    int
+	gapMean float64
+	gapM2   float64
+	load    float64
+	// status is the latest status the peer reported, handouts counts how often it was handed out by /peers since
+	status   enum.NodeStatus
+	handouts int
+}
+
+// healthOf returns the health of peer, starting to observe it if needed. b.mu must be held.
+func (b *Bootstrapper) healthOf(peer string) *peerHealth {
+	health, exists := b.health[peer]
+	if !exists {
+		now := time.Now()
+		health = &peerHealth{registered: now, lastHeartbeat: now}
+		b.health[peer] = health
+	}
+	return health
+}
+
+func (h *peerHealth) heartbeat(now time.Time, load float64) {
+	gap := now.Sub(h.lastHeartbeat).Seconds()
+	h.lastHeartbeat = now
+	// Peers report their load between 0 (idle) and 1 (saturated), it is taken into account for seed elections
+	h.load = min(max(load, 0), 1)
+
+	h.gaps++
+	delta := gap - h.gapMean
+	h.gapMean += delta / float64(h.gaps)
+	h.gapM2 += delta * (gap - h.gapMean)
+}
+
+// report records the status sent with a heartbeat. The peers it reports include the nodes it was handed out to.
+func (h *peerHealth) report(status enum.NodeStatus) {
+	h.status = status
+	h.handouts = 0
+}
+
+// regularity is 1 for heartbeats at a constant interval and falls towards 0 the more the intervals vary.
+// Peers with fewer than two heartbeats are not rated yet and get 0.
+func (h *peerHealth) regularity() float64 {
+	if h.gaps < 2 || h.gapMean <= 0 {
+		return 0
+	}
+	stddev := math.Sqrt(h.gapM2 / float64(h.gaps-1))
+	return 1 / (1 + stddev/h.gapMean)
+}
+
+// seedCandidate is a peer as rated by a seed election
+type seedCandidate struct {
+	Peer          string  `json:"peer"`
+	Subnet        string  `json:"subnet"`
+	Score         float64 `json:"score"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+	Regularity    float64 `json:"regularity"`
+	Load          float64 `json:"load"`
+	Seed          bool    `json:"seed"`
+	Reason        string  `json:"reason"`
+}
+
+// seedElection is the outcome of a seed election, served by /seeds
+type seedElection struct {
+	ElectedAt  time.Time       `json:"elected_at"`
+	SeedLimit  int             `json:"seed_limit"`
+	SubnetCap  int             `json:"subnet_cap"`
+	Seeds      []string        `json:"seeds"`
+	Candidates []seedCandidate `json:"candidates"`
+}
+
+// subnetOf groups peers by IPv4 /24 or IPv6 /64 subnet. Host names are their own group.
+func subnetOf(peer string) string {
+	host, _, err := net.SplitHostPort(peer)
+	if err != nil {
+		host = peer
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return host
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%s/24", ip4.Mask(net.CIDRMask(24, 32)))
+	}
+	return fmt.Sprintf("%s/64", ip.Mask(net.CIDRMask(64, 128)))
+}
+
+// subnetHasRoom reports whether peer may become a seed next to seeds without exceeding the subnet cap
+func (b *Bootstrapper) subnetHasRoom(peer string, seeds []string) bool {
+	if b.seedSubnetCap == 0 {
+		return true
+	}
+	subnet := subnetOf(peer)
+	count := 0
+	for _, seed := range seeds {
+		if seed != peer && subnetOf(seed) == subnet {
+			count++
+		}
+	}
+	return count < b.seedSubnetCap
+}
+
+// electSeeds rates every peer by uptime, heartbeat regularity and reported load, and makes the best rated ones
+// seed nodes, at most seedSubnetCap per subnet. Current seeds get a bonus, so that seeds only change for clearly
+// healthier peers. b.mu must be held.
+func (b *Bootstrapper) electSeeds(now time.Time) {
+	candidates := make([]seedCandidate, 0, len(b.peersTimeoutList))
+	for peer := range b.peersTimeoutList {
+		health := b.healthOf(peer)
+		uptime := now.Sub(health.registered)
+		candidate := seedCandidate{
+			Peer:          peer,
+			Subnet:        subnetOf(peer),
+			UptimeSeconds: uptime.Seconds(),
+			Regularity:    health.regularity(),
+			Load:          health.load,
+		}
+		candidate.Score = (min(uptime.Seconds()/enum.SeedFullUptime.Seconds(), 1) + candidate.Regularity + (1 - candidate.Load)) / 3
+		if contains(b.seedNodes, peer) {
+			candidate.Score += enum.SeedIncumbentBonus
+		}
+		candidates = append(candidates, candidate)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].Score != candidates[j].Score {
+			return candidates[i].Score > candidates[j].Score
+		}
+		return candidates[i].Peer < candidates[j].Peer
+	})
+
+	previous := b.seedNodes
+	seeds := make([]string, 0, b.seedNodeLimit)
+	for i := range candidates {
+		switch {
+		case len(seeds) >= b.seedNodeLimit:
+			candidates[i].Reason = "seed limit reached"
+		case !b.subnetHasRoom(candidates[i].Peer, seeds):
+			candidates[i].Reason = "subnet cap reached"
+		default:
+			candidates[i].Seed = true
+			candidates[i].Reason = "elected"
+			seeds = append(seeds, candidates[i].Peer)
+		}
+	}
+	b.seedNodes = seeds
+
+	for _, peer := range previous {
+		if !contains(seeds, peer) {
+			b.persist(peer)
+		}
+	}
+	for _, peer := range seeds {
+		if !contains(previous, peer) {
+			b.persist(peer)
+		}
+	}
+
+	b.lastElection = seedElection{
+		ElectedAt:  now,
+		SeedLimit:  b.seedNodeLimit,
+		SubnetCap:  b.seedSubnetCap,
+		Seeds:      seeds,
+		Candidates: candidates,
+	}
+}
+
+// ElectSeedsPeriodically elects the seed nodes every election interval until ctx is done
+func (b *Bootstrapper) ElectSeedsPeriodically(ctx context.Context) {
+	logger := logging.NewCustomLogger()
+
+	ticker := time.NewTicker(b.electionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.mu.Lock()
+			b.electSeeds(time.Now())
+			logger.InfoF("Elected seed nodes: %v", b.seedNodes)
+			b.mu.Unlock()
+		}
+	}
+}
+
+// GetSeeds serves the current seed nodes and the ratings of the last election
+func (b *Bootstrapper) GetSeeds(w http.ResponseWriter, r *http.Request) {
+	b.mu.RLock()
+	response := struct {
+		Seeds        []string     `json:"seeds"`
+		LastElection seedElection `json:"last_election"`
+	}{Seeds: b.seedNodes, LastElection: b.lastElection}
+	err := json.NewEncoder(w).Encode(response)
+	b.mu.RUnlock()
+
+	if err != nil {
+		http.Error(w, "Unable to encode seeds", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,84 @@
+package bootstrapper
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+func newTestBootstrapper(seedLimit int, subnetCap int) *Bootstrapper {
+	cfg := defaultConfig()
+	cfg.SeedLimit = seedLimit
+	cfg.SeedSubnetCap = subnetCap
+	return New(cfg, nil)
+}
+
+// addPeer registers peer as if it had been up for the full uptime rating, reporting load
+func addPeer(b *Bootstrapper, peer string, now time.Time, load float64) {
+	b.peersTimeoutList[peer] = now
+	b.health[peer] = &peerHealth{registered: now.Add(-enum.SeedFullUptime), lastHeartbeat: now, load: load}
+}
+
+func TestSubnetHasRoom(t *testing.T) {
+	b := newTestBootstrapper(3, 1)
+	seeds := []string{"10.0.0.1:7001", "[2001:db8::1]:7001", "seed.example:7001"}
+
+	assert.False(t, b.subnetHasRoom("10.0.0.2:7001", seeds))
+	assert.True(t, b.subnetHasRoom("10.0.1.1:7001", seeds))
+	assert.False(t, b.subnetHasRoom("[2001:db8::2]:7001", seeds))
+	assert.True(t, b.subnetHasRoom("[2001:db8:0:1::1]:7001", seeds))
+	assert.False(t, b.subnetHasRoom("seed.example:7002", seeds))
+	// A seed does not take room from itself
+	assert.True(t, b.subnetHasRoom("10.0.0.1:7001", seeds))
+
+	b.seedSubnetCap = 2
+	assert.True(t, b.subnetHasRoom("10.0.0.2:7001", seeds))
+	assert.False(t, b.subnetHasRoom("10.0.0.3:7001", append(seeds, "10.0.0.2:7001")))
+
+	b.seedSubnetCap = 0
+	assert.True(t, b.subnetHasRoom("10.0.0.3:7001", append(seeds, "10.0.0.2:7001")))
+}
+
+func TestElectSeeds(t *testing.T) {
+	now := time.Now()
+	b := newTestBootstrapper(2, 1)
+	addPeer(b, "10.0.0.1:7001", now, 0)
+	addPeer(b, "10.0.0.2:7001", now, 0.1)
+	addPeer(b, "10.0.1.1:7001", now, 0.5)
+	addPeer(b, "10.0.2.1:7001", now, 0.9)
+
+	b.electSeeds(now)
+
+	assert.Equal(t, []string{"10.0.0.1:7001", "10.0.1.1:7001"}, b.seedNodes)
+	reasons := make(map[string]string)
+	for _, candidate := range b.lastElection.Candidates {
+		reasons[candidate.Peer] = candidate.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"10.0.0.1:7001": "elected",
+		"10.0.0.2:7001": "subnet cap reached",
+		"10.0.1.1:7001": "elected",
+		"10.0.2.1:7001": "seed limit reached",
+	}, reasons)
+	assert.Equal(t, b.seedNodes, b.lastElection.Seeds)
+}
+
+func TestElectSeedsKeepsIncumbent(t *testing.T) {
+	now := time.Now()
+	b := newTestBootstrapper(1, 0)
+	addPeer(b, "10.0.0.1:7001", now, 0.45)
+	addPeer(b, "10.0.1.1:7001", now, 0.3)
+	b.seedNodes = []string{"10.0.0.1:7001"}
+
+	// The incumbent bonus outweighs the slightly lower load of the other peer
+	b.electSeeds(now)
+	assert.Equal(t, []string{"10.0.0.1:7001"}, b.seedNodes)
+
+	// A clearly healthier peer takes over
+	addPeer(b, "10.0.2.1:7001", now, 0)
+	b.electSeeds(now)
+	assert.Equal(t, []string{"10.0.2.1:7001"}, b.seedNodes)
+}
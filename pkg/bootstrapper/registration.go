@@ -0,0 +1,166 @@
+package bootstrapper
+
+import (
+	"crypto/ed25519"
+	crand "crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/pow"
+	pb "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/proto"
+)
+
+// allowRequest applies the per source IP rate limit of registrations
+func (b *Bootstrapper) allowRequest(w http.ResponseWriter, r *http.Request) bool {
+	if b.limiter == nil {
+		return true
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !b.limiter.Allow(host, time.Now()) {
+		http.Error(w, "Too many registrations", http.StatusTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// IssueChallenge issues a proof-of-work challenge for registering the given peer
+func (b *Bootstrapper) IssueChallenge(w http.ResponseWriter, r *http.Request) {
+	if !b.allowRequest(w, r) {
+		return
+	}
+
+	peer := r.URL.Query().Get("peer")
+	if peer == "" {
+		http.Error(w, "Missing peer", http.StatusBadRequest)
+		return
+	}
+
+	random := make([]byte, 16)
+	if _, err := crand.Read(random); err != nil {
+		http.Error(w, "Unable to issue challenge", http.StatusInternalServerError)
+		return
+	}
+	challenge := hex.EncodeToString(random)
+
+	now := time.Now()
+	b.mu.Lock()
+	for issued, pending := range b.challenges {
+		if now.After(pending.expires) {
+			delete(b.challenges, issued)
+		}
+	}
+	b.challenges[challenge] = issuedChallenge{peer: peer, expires: now.Add(enum.ChallengeTTL)}
+	b.mu.Unlock()
+
+	response := struct {
+		Challenge  string `json:"challenge"`
+		Difficulty int    `json:"difficulty"`
+	}{Challenge: challenge, Difficulty: b.difficulty}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "Unable to encode challenge", http.StatusInternalServerError)
+	}
+}
+
+// verifyChallenge checks and consumes the challenge answered by a registration of peer
+func (b *Bootstrapper) verifyChallenge(peer string, challenge string, rawNonce string) error {
+	if b.difficulty == 0 {
+		return nil
+	}
+
+	b.mu.Lock()
+	issued, exists := b.challenges[challenge]
+	delete(b.challenges, challenge)
+	b.mu.Unlock()
+
+	if !exists || issued.peer != peer || time.Now().After(issued.expires) {
+		return fmt.Errorf("unknown or expired challenge")
+	}
+	nonce, err := strconv.ParseUint(rawNonce, 10, 64)
+	if err != nil || !pow.CheckChallenge(challenge+peer, nonce, strings.Repeat("0", b.difficulty)) {
+		return fmt.Errorf("invalid proof of work")
+	}
+	return nil
+}
+
+// parsePublicKey decodes the base64 encoded ed25519 public key a node registers with
+func parsePublicKey(rawPublicKey string) (ed25519.PublicKey, error) {
+	publicKey, err := base64.StdEncoding.DecodeString(rawPublicKey)
+	if err != nil || len(publicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid public key")
+	}
+	return publicKey, nil
+}
+
+// probeReachability dials the P2P address of peer with a random nonce, which the node must sign with the key it registers with
+func probeReachability(peer string, publicKey ed25519.PublicKey) error {
+	nonce := make([]byte, 32)
+	if _, err := crand.Read(nonce); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(&pb.GossipMessage{Type: int32(enum.ReachabilityProbe), Payload: nonce})
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout("tcp", peer, enum.ProbeTimeout)
+	if err != nil {
+		return fmt.Errorf("peer not reachable: %w", err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(enum.ProbeTimeout))
+
+	if _, err := conn.Write(data); err != nil {
+		return err
+	}
+	// The node reads the probe until EOF before it answers
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		_ = tcpConn.CloseWrite()
+	}
+
+	signature, err := io.ReadAll(io.LimitReader(conn, ed25519.SignatureSize+1))
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, append(nonce, peer...), signature) {
+		return fmt.Errorf("invalid answer to reachability probe")
+	}
+	return nil
+}
+
+// verifyDeregistration checks that a deregistration of peer is signed with the key peer registered with, over
+// "deregister <peer> <timestamp>". The timestamp in Unix seconds must not be older than enum.DeregistrationMaxAge.
+// b.mu must be held.
+func (b *Bootstrapper) verifyDeregistration(peer string, rawTimestamp string, rawSignature string) error {
+	publicKey, exists := b.publicKeys[peer]
+	if !exists {
+		return fmt.Errorf("peer did not register with a public key")
+	}
+
+	timestamp, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp")
+	}
+	if age := time.Since(time.Unix(timestamp, 0)); age > enum.DeregistrationMaxAge || age < -enum.DeregistrationMaxAge {
+		return fmt.Errorf("expired deregistration")
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(rawSignature)
+	if err != nil || !ed25519.Verify(publicKey, []byte("deregister "+peer+" "+rawTimestamp), signature) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
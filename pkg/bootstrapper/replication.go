@@ -0,0 +1,144 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/peerstore"
+)
+
+// HandleReplicate merges the registry pushed by another bootstrapper of the cluster. Of two records of a peer,
+// the one seen last wins; a deregistration wins over records seen before it.
+func (b *Bootstrapper) HandleReplicate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !b.isReplica(r.RemoteAddr) {
+		http.Error(w, "Not a replica", http.StatusForbidden)
+		return
+	}
+
+	var state replicationState
+	if err := json.NewDecoder(r.Body).Decode(&state); err != nil {
+		http.Error(w, "Unable to decode registry", http.StatusBadRequest)
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for peer, removedAt := range state.Removed {
+		if lastSeen, exists := b.peersTimeoutList[peer]; exists && !lastSeen.After(removedAt) {
+			b.removePeer(peer)
+		}
+		if removedAt.After(b.removed[peer]) {
+			b.removed[peer] = removedAt
+		}
+	}
+
+	for _, record := range state.Peers {
+		if removedAt, removed := b.removed[record.Peer]; removed && !record.LastSeen.After(removedAt) {
+			continue
+		}
+		if time.Since(record.LastSeen) > b.timeout {
+			continue
+		}
+
+		// Seed nodes are elected by every bootstrapper on its own, so the seed flag of replicas is not merged
+		// The key of the latest registration wins, so that the peer can deregister at every bootstrapper
+		if lastSeen, exists := b.peersTimeoutList[record.Peer]; !exists || record.LastSeen.After(lastSeen) {
+			b.peersTimeoutList[record.Peer] = record.LastSeen
+			if len(record.PublicKey) == ed25519.PublicKeySize {
+				b.publicKeys[record.Peer] = record.PublicKey
+			}
+			b.healthOf(record.Peer)
+			b.persist(record.Peer)
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// isReplica reports whether remoteAddr belongs to one of the configured replicas. Registrations replicated by
+// others would bypass the checks of RegisterPeer.
+func (b *Bootstrapper) isReplica(remoteAddr string) bool {
+	remoteHost, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	remoteIP := net.ParseIP(remoteHost)
+
+	for _, replica := range b.replicas {
+		replicaURL, err := url.Parse(replica)
+		if err != nil {
+			continue
+		}
+		addresses, err := net.LookupIP(replicaURL.Hostname())
+		if err != nil {
+			continue
+		}
+		for _, address := range addresses {
+			if address.Equal(remoteIP) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Replicate periodically pushes the registry to every other bootstrapper of the cluster until ctx is done
+func (b *Bootstrapper) Replicate(ctx context.Context) {
+	logger := logging.NewCustomLogger()
+	client := &http.Client{Timeout: b.replicationInterval}
+
+	ticker := time.NewTicker(b.replicationInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		b.mu.RLock()
+		state := replicationState{
+			Peers:   make([]peerstore.Record, 0, len(b.peersTimeoutList)),
+			Removed: make(map[string]time.Time, len(b.removed)),
+		}
+		for peer, lastSeen := range b.peersTimeoutList {
+			state.Peers = append(state.Peers, b.record(peer, lastSeen))
+		}
+		for peer, removedAt := range b.removed {
+			state.Removed[peer] = removedAt
+		}
+		b.mu.RUnlock()
+
+		body, err := json.Marshal(state)
+		if err != nil {
+			logger.ErrorF("Failed to encode registry: %v", err)
+			continue
+		}
+
+		for _, replica := range b.replicas {
+			resp, err := client.Post(replica+"/replicate", "application/json", bytes.NewReader(body))
+			if err != nil {
+				logger.ErrorF("Failed to replicate registry to %s: %v", replica, err)
+				continue
+			}
+			_ = resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				logger.ErrorF("Replica %s rejected registry, status code: %d", replica, resp.StatusCode)
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package bootstrapper
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/peerstore"
+)
+
+// replicateFrom pushes state to b as if it came from remoteAddr
+func replicateFrom(t *testing.T, b *Bootstrapper, remoteAddr string, state replicationState) int {
+	body, err := json.Marshal(state)
+	require.NoError(t, err)
+	request := httptest.NewRequest(http.MethodPost, "/replicate", bytes.NewReader(body))
+	request.RemoteAddr = remoteAddr
+	recorder := httptest.NewRecorder()
+	b.HandleReplicate(recorder, request)
+	return recorder.Code
+}
+
+func TestHandleReplicate(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	publicKey, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	b := newTestBootstrapper(3, 0)
+	b.replicas = []string{"http://127.0.0.1:8081"}
+
+	b.peersTimeoutList["10.0.0.1:7001"] = now.Add(-time.Minute)
+	b.peersTimeoutList["10.0.0.2:7001"] = now
+	b.peersTimeoutList["10.0.0.3:7001"] = now.Add(-time.Minute)
+	b.peersTimeoutList["10.0.0.4:7001"] = now
+	b.removed["10.0.0.5:7001"] = now.Add(-time.Minute)
+	b.removed["10.0.0.6:7001"] = now.Add(-time.Minute)
+
+	status := replicateFrom(t, b, "127.0.0.1:40000", replicationState{
+		Peers: []peerstore.Record{
+			// Seen later by the replica, the seed flag is not taken over, the key of its registration is
+			{Peer: "10.0.0.1:7001", LastSeen: now, Seed: true, PublicKey: publicKey},
+			// Seen earlier by the replica
+			{Peer: "10.0.0.2:7001", LastSeen: now.Add(-time.Minute)},
+			// Deregistered here before the replica saw it last, and after
+			{Peer: "10.0.0.5:7001", LastSeen: now},
+			{Peer: "10.0.0.6:7001", LastSeen: now.Add(-2 * time.Minute)},
+			// Timed out
+			{Peer: "10.0.0.7:7001", LastSeen: now.Add(-b.timeout - time.Minute)},
+			{Peer: "10.0.0.8:7001", LastSeen: now},
+		},
+		Removed: map[string]time.Time{
+			// Deregistered at the replica after it was seen here, and before
+			"10.0.0.3:7001": now,
+			"10.0.0.4:7001": now.Add(-time.Minute),
+		},
+	})
+	require.Equal(t, http.StatusOK, status)
+
+	assert.Equal(t, map[string]time.Time{
+		"10.0.0.1:7001": now,
+		"10.0.0.2:7001": now,
+		"10.0.0.4:7001": now,
+		"10.0.0.5:7001": now,
+		"10.0.0.8:7001": now,
+	}, b.peersTimeoutList)
+	assert.Empty(t, b.seedNodes)
+	assert.Equal(t, ed25519.PublicKey(publicKey), b.publicKeys["10.0.0.1:7001"])
+	assert.Equal(t, now, b.removed["10.0.0.3:7001"])
+	assert.Equal(t, now.Add(-time.Minute), b.removed["10.0.0.4:7001"])
+}
+
+func TestHandleReplicateRejectsOthers(t *testing.T) {
+	b := newTestBootstrapper(3, 0)
+	b.replicas = []string{"http://127.0.0.1:8081"}
+
+	status := replicateFrom(t, b, "192.0.2.1:40000", replicationState{
+		Peers: []peerstore.Record{{Peer: "10.0.0.1:7001", LastSeen: time.Now()}},
+	})
+
+	assert.Equal(t, http.StatusForbidden, status)
+	assert.Empty(t, b.peersTimeoutList)
+}
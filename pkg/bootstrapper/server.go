@@ -0,0 +1,53 @@
+package bootstrapper
+
+import (
+	"io/fs"
+	"net/http"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+)
+
+// Handler serves the bootstrapper API and the dashboard, logging every request
+func (b *Bootstrapper) Handler() (http.Handler, error) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/challenge", b.IssueChallenge)
+	mux.HandleFunc("/register", b.RegisterPeer)
+	mux.HandleFunc("/deregister", b.DeregisterPeer)
+	mux.HandleFunc("/peers", b.GetPeers)
+	mux.HandleFunc("/heartbeat", b.HandleHeartbeat)
+	mux.HandleFunc("/replicate", b.HandleReplicate)
+	mux.HandleFunc("/seeds", b.GetSeeds)
+	mux.HandleFunc("/nodes", b.GetNodes)
+	mux.HandleFunc("/topology", b.GetTopology)
+
+	dashboard, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		return nil, err
+	}
+	mux.Handle("/dashboard/", http.StripPrefix("/dashboard/", http.FileServerFS(dashboard)))
+
+	return logRequests(mux), nil
+}
+
+// statusRecorder remembers the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// logRequests logs method, path, status and duration of every request
+func logRequests(next http.Handler) http.Handler {
+	logger := logging.NewCustomLogger()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		logger.InfoF("%s %s %s %d %v", r.RemoteAddr, r.Method, r.URL.RequestURI(), recorder.status, time.Since(start))
+	})
+}
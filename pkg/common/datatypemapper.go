@@ -9,19 +9,39 @@ import (
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 )
 
+// PseudoSubscriber is implemented by the addresses of subscribers that receive notifications on behalf of modules,
+// like durable queues. They are no module sessions and do not take part in validation policies.
+type PseudoSubscriber interface {
+	net.Addr
+	PseudoSubscriber()
+}
+
+// AwaitedValidator is implemented by the addresses of subscribers that answer every notification with a validation,
+// like webhooks. Their validations are waited for before a message is forwarded, even without a validation policy.
+type AwaitedValidator interface {
+	net.Addr
+	AwaitedValidator()
+}
+
 // DatatypeMapper map address -> another map value: enum.Datatype -> boolean, that indicates presence of that type
 type DatatypeMapper struct {
-	mu           sync.RWMutex
-	data         map[net.Addr]map[enum.Datatype]bool
-	invalidMsgID map[uint16]bool
-	logger       *logging.Logger
+	mu               sync.RWMutex
+	data             map[net.Addr]map[enum.Datatype]bool
+	validators       map[string]net.Addr
+	invalidMsgID     map[uint16]bool
+	msgDatatypes     map[uint16]enum.Datatype
+	msgDatatypeOrder []uint16
+	onValidation     func(validator net.Addr, msgID uint16, valid bool)
+	logger           *logging.Logger
 }
 
 // NewMap initializes a new DatatypeMapper.
 func NewMap() *DatatypeMapper {
 	return &DatatypeMapper{
 		data:         make(map[net.Addr]map[enum.Datatype]bool),
+		validators:   make(map[string]net.Addr),
 		invalidMsgID: make(map[uint16]bool),
+		msgDatatypes: make(map[uint16]enum.Datatype),
 	}
 }
 
@@ -35,6 +55,43 @@ func (am *DatatypeMapper) Add(addr net.Addr, datatype enum.Datatype) {
 	am.data[addr][datatype] = true
 }
 
+// Remove deletes every datatype registered for a specific address.
+func (am *DatatypeMapper) Remove(addr net.Addr) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	delete(am.data, addr)
+	delete(am.validators, AddrKey(addr))
+}
+
+// SetValidator records the identity the module subscribed at addr sends its validations as, e.g. its credential.
+// Subscribers without one validate as their own address.
+func (am *DatatypeMapper) SetValidator(addr net.Addr, validator net.Addr) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.validators[AddrKey(addr)] = validator
+}
+
+// AddrKey identifies addr by value, so that different instances of the same address match
+func AddrKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+// validatorsOf returns the identities of the modules subscribed to datatype, each once. am.mu must be held.
+func (am *DatatypeMapper) validatorsOf(datatype enum.Datatype) map[string]struct{} {
+	validators := make(map[string]struct{})
+	for addr, datatypes := range am.data {
+		if _, pseudo := addr.(PseudoSubscriber); pseudo || !datatypes[datatype] {
+			continue
+		}
+		validator, exists := am.validators[AddrKey(addr)]
+		if !exists {
+			validator = addr
+		}
+		validators[AddrKey(validator)] = struct{}{}
+	}
+	return validators
+}
+
 // AddInvalidMsgID Add adds a new datatype to a specific address in the DatatypeMapper.
 func (am *DatatypeMapper) AddInvalidMsgID(msgID uint16) {
 	am.mu.Lock()
@@ -42,6 +99,51 @@ func (am *DatatypeMapper) AddInvalidMsgID(msgID uint16) {
 	am.invalidMsgID[msgID] = true
 }
 
+// AddValidation records the validation result of a module for a message and reports it to the validation listener.
+// validator identifies the module that sent it.
+func (am *DatatypeMapper) AddValidation(validator net.Addr, msgID uint16, valid bool) {
+	am.mu.Lock()
+	if !valid {
+		am.invalidMsgID[msgID] = true
+	}
+	onValidation := am.onValidation
+	am.mu.Unlock()
+
+	if onValidation != nil {
+		onValidation(validator, msgID, valid)
+	}
+}
+
+// SetValidationListener registers a function called for every validation passed to AddValidation.
+func (am *DatatypeMapper) SetValidationListener(onValidation func(validator net.Addr, msgID uint16, valid bool)) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+	am.onValidation = onValidation
+}
+
+// AddMessageDatatype remembers the datatype of a message handed to the API, so that validations can be checked against it.
+func (am *DatatypeMapper) AddMessageDatatype(msgID uint16, datatype enum.Datatype) {
+	am.mu.Lock()
+	defer am.mu.Unlock()
+
+	if _, exists := am.msgDatatypes[msgID]; !exists {
+		if len(am.msgDatatypeOrder) >= enum.MessageDatatypeCacheSize {
+			delete(am.msgDatatypes, am.msgDatatypeOrder[0])
+			am.msgDatatypeOrder = am.msgDatatypeOrder[1:]
+		}
+		am.msgDatatypeOrder = append(am.msgDatatypeOrder, msgID)
+	}
+	am.msgDatatypes[msgID] = datatype
+}
+
+// GetMessageDatatype returns the datatype of a message previously handed to the API.
+func (am *DatatypeMapper) GetMessageDatatype(msgID uint16) (enum.Datatype, bool) {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	datatype, exists := am.msgDatatypes[msgID]
+	return datatype, exists
+}
+
 func (am *DatatypeMapper) CheckNotify(msgID uint16, datatype enum.Datatype) bool {
 	am.mu.RLock()
 	defer am.mu.RUnlock()
@@ -90,3 +192,33 @@ func (am *DatatypeMapper) GetAddressesByType(datatype enum.Datatype) []net.Addr
 
 	return addresses
 }
+
+// CountValidators returns the number of modules and webhooks subscribed to datatype. A module subscribed on several
+// sessions is counted once, pseudo subscribers are not counted.
+func (am *DatatypeMapper) CountValidators(datatype enum.Datatype) int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	return len(am.validatorsOf(datatype))
+}
+
+// CountAwaited returns the number of awaited validators subscribed to datatype
+func (am *DatatypeMapper) CountAwaited(datatype enum.Datatype) int {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+
+	count := 0
+	for addr, datatypes := range am.data {
+		if _, awaited := addr.(AwaitedValidator); awaited && datatypes[datatype] {
+			count++
+		}
+	}
+	return count
+}
+
+// IsValidator reports whether validator identifies a module subscribed to datatype
+func (am *DatatypeMapper) IsValidator(validator net.Addr, datatype enum.Datatype) bool {
+	am.mu.RLock()
+	defer am.mu.RUnlock()
+	_, subscribed := am.validatorsOf(datatype)[AddrKey(validator)]
+	return subscribed
+}
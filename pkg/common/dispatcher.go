@@ -0,0 +1,93 @@
+package common
+
+import (
+	"sync"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// Subscription receives every notification matching one of its datatypes
+type Subscription struct {
+	C         chan enum.NotificationMsg
+	datatypes map[enum.Datatype]bool
+}
+
+// Dispatcher fans notifications coming from the P2P layer out to every API subscriber,
+// so that binary, HTTP and other front ends do not compete for the same channel.
+type Dispatcher struct {
+	mu                  sync.RWMutex
+	subscriptions       map[*Subscription]struct{}
+	notificationMsgChan chan enum.NotificationMsg
+}
+
+// NewDispatcher initializes a new Dispatcher reading from notificationMsgChan.
+func NewDispatcher(notificationMsgChan chan enum.NotificationMsg) *Dispatcher {
+	return &Dispatcher{
+		subscriptions:       make(map[*Subscription]struct{}),
+		notificationMsgChan: notificationMsgChan,
+	}
+}
+
+// Run forwards notifications until the notification channel is closed.
+func (d *Dispatcher) Run() {
+	for msg := range d.notificationMsgChan {
+		d.Publish(msg)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for sub := range d.subscriptions {
+		close(sub.C)
+		delete(d.subscriptions, sub)
+	}
+}
+
+// Publish delivers msg to all matching subscriptions. Slow subscribers lose the message instead of blocking the others.
+func (d *Dispatcher) Publish(msg enum.NotificationMsg) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	for sub := range d.subscriptions {
+		if !sub.datatypes[msg.DataType] {
+			continue
+		}
+		select {
+		case sub.C <- msg:
+		default:
+		}
+	}
+}
+
+// Subscribe registers a new subscription for the given datatypes.
+func (d *Dispatcher) Subscribe(datatypes ...enum.Datatype) *Subscription {
+	sub := &Subscription{
+		C:         make(chan enum.NotificationMsg, enum.SubscriptionBufferSize),
+		datatypes: make(map[enum.Datatype]bool),
+	}
+	for _, datatype := range datatypes {
+		sub.datatypes[datatype] = true
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.subscriptions[sub] = struct{}{}
+
+	return sub
+}
+
+// AddDatatype extends an existing subscription by another datatype.
+func (d *Dispatcher) AddDatatype(sub *Subscription, datatype enum.Datatype) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	sub.datatypes[datatype] = true
+}
+
+// Unsubscribe removes the subscription and closes its channel.
+func (d *Dispatcher) Unsubscribe(sub *Subscription) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, exists := d.subscriptions[sub]; exists {
+		delete(d.subscriptions, sub)
+		close(sub.C)
+	}
+}
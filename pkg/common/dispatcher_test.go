@@ -0,0 +1,30 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// TestDispatcherFanOut checks that every matching subscriber receives a notification.
+func TestDispatcherFanOut(t *testing.T) {
+	notificationMsgChan := make(chan enum.NotificationMsg)
+	dispatcher := NewDispatcher(notificationMsgChan)
+	go dispatcher.Run()
+
+	first := dispatcher.Subscribe(1)
+	second := dispatcher.Subscribe(1, 2)
+	other := dispatcher.Subscribe(3)
+
+	msg := enum.NotificationMsg{MessageID: 7, DataType: 1, Data: "hello"}
+	notificationMsgChan <- msg
+
+	assert.Equal(t, msg, <-first.C)
+	assert.Equal(t, msg, <-second.C)
+
+	close(notificationMsgChan)
+
+	_, ok := <-other.C
+	assert.False(t, ok)
+}
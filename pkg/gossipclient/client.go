@@ -0,0 +1,429 @@
+// Package gossipclient is a Go client for the binary GOSSIP API served by the gossip node.
+package gossipclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+const (
+	unixScheme = "unix://"
+
+	defaultDialTimeout = 5 * time.Second
+	defaultMinBackoff  = 100 * time.Millisecond
+	defaultMaxBackoff  = 10 * time.Second
+
+	// maxAnnounceData is the largest payload fitting into one ANNOUNCE message
+	maxAnnounceData = 65535 - 8
+	// announceResultTimeout bounds the wait for GOSSIP ANNOUNCE RESULT, which the node sends within enum.AnnounceTimeout
+	announceResultTimeout = 2 * enum.AnnounceTimeout
+)
+
+var (
+	// ErrClosed is returned by calls on a closed Client
+	ErrClosed = errors.New("gossipclient: client closed")
+	// ErrDataTooLarge is returned when announced data does not fit into one API message
+	ErrDataTooLarge = errors.New("gossipclient: data too large")
+	// ErrNoDatatypes is reported when Subscribe is called without any datatype
+	ErrNoDatatypes = errors.New("gossipclient: no datatypes to subscribe to")
+	// ErrUnexpectedMessage is reported when the node sends something other than the GOSSIP NOTIFICATION or GOSSIP
+	// ANNOUNCE RESULT expected
+	ErrUnexpectedMessage = errors.New("gossipclient: unexpected message")
+)
+
+// ConnectionError wraps failures to reach or talk to the gossip node
+type ConnectionError struct {
+	Address string
+	Err     error
+}
+
+func (e *ConnectionError) Error() string {
+	return fmt.Sprintf("gossipclient: connection to %s failed: %v", e.Address, e.Err)
+}
+
+func (e *ConnectionError) Unwrap() error {
+	return e.Err
+}
+
+// AnnounceError is returned when the node did not take an announced message, e.g. because it was busy or the
+// module may not announce the datatype. The connection stays usable.
+type AnnounceError struct {
+	Reason string
+}
+
+func (e *AnnounceError) Error() string {
+	return fmt.Sprintf("gossipclient: announce failed: %s", e.Reason)
+}
+
+// Notification is a GOSSIP NOTIFICATION delivered to a subscriber. Sequence is its position in the durable queue
+// of the subscription, or 0 without WithResume.
+type Notification struct {
+	MessageID uint16
+	Datatype  enum.Datatype
+	Data      []byte
+	Sequence  uint64
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithToken authenticates every session with a pre-shared module token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithDialTimeout limits the time spent connecting to the node
+func WithDialTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.dialTimeout = timeout
+	}
+}
+
+// WithReconnectBackoff sets the bounds of the exponential backoff between reconnection attempts
+func WithReconnectBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithResume makes subscriptions use the durable queue of identity on the node. After a reconnect,
+// every notification missed in the meantime is delivered first. Authenticated modules use the queue
+// of their credential regardless of identity.
+func WithResume(identity string) Option {
+	return func(c *Client) {
+		c.resumeIdentity = identity
+	}
+}
+
+// WithErrorHandler receives errors of subscriptions, which are otherwise retried silently
+func WithErrorHandler(handler func(error)) Option {
+	return func(c *Client) {
+		c.errorHandler = handler
+	}
+}
+
+// Client talks to the API of one gossip node. Announce and Validate share one connection,
+// every subscription uses a connection of its own. A Client is safe for concurrent use.
+type Client struct {
+	address      string
+	token        string
+	dialTimeout  time.Duration
+	minBackoff   time.Duration
+	maxBackoff   time.Duration
+	errorHandler func(error)
+	// resumeIdentity selects the durable queue used by subscriptions, if set
+	resumeIdentity string
+
+	mu     sync.Mutex
+	conn   net.Conn
+	closed bool
+}
+
+// New creates a Client for address, which is either host:port or unix:///path/to.sock.
+// No connection is made until the first call.
+func New(address string, opts ...Option) *Client {
+	c := &Client{
+		address:      address,
+		dialTimeout:  defaultDialTimeout,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		errorHandler: func(error) {},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// Announce asks the node to spread data of the given datatype with ttl hops. It waits until the node took the
+// message and returns an *AnnounceError if it did not.
+func (c *Client) Announce(ctx context.Context, ttl uint8, datatype enum.Datatype, data []byte) error {
+	if len(data) > maxAnnounceData {
+		return ErrDataTooLarge
+	}
+
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, ttl)
+	_ = binary.Write(&body, binary.BigEndian, api.AnnounceRequestResult)
+	_ = binary.Write(&body, binary.BigEndian, datatype)
+	body.Write(data)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.sendLocked(ctx, enum.GossipAnnounce, body.Bytes()); err != nil {
+		return err
+	}
+	return c.readAnnounceResult(ctx)
+}
+
+// readAnnounceResult reads the answer of the node to an announce from the shared connection. c.mu must be held.
+func (c *Client) readAnnounceResult(ctx context.Context) error {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(announceResultTimeout)
+	}
+	_ = c.conn.SetReadDeadline(deadline)
+
+	messageType, body, err := api.ReadFrame(c.conn)
+	if err == nil && (messageType != enum.GossipAnnounceResult || len(body) == 0) {
+		err = fmt.Errorf("%w: type %d", ErrUnexpectedMessage, messageType)
+	}
+	if err != nil {
+		// The announce is not sent again, the node may have taken it
+		_ = c.conn.Close()
+		c.conn = nil
+		return &ConnectionError{Address: c.address, Err: err}
+	}
+
+	if body[0] != api.AnnounceAccepted {
+		return &AnnounceError{Reason: string(body[1:])}
+	}
+	return nil
+}
+
+// Validate reports whether the message with the given ID, received as Notification, is valid
+func (c *Client) Validate(id uint16, valid bool) error {
+	// The node treats a set lowest bit as invalid
+	var reserved uint16
+	if !valid {
+		reserved = 1
+	}
+
+	var body bytes.Buffer
+	_ = binary.Write(&body, binary.BigEndian, id)
+	_ = binary.Write(&body, binary.BigEndian, reserved)
+
+	return c.send(context.Background(), enum.GossipValidation, body.Bytes())
+}
+
+// Subscribe delivers notifications of the given datatypes until ctx is done or the Client is closed.
+// Lost connections are re-established with exponential backoff; the channel is closed at the end.
+func (c *Client) Subscribe(ctx context.Context, datatypes ...enum.Datatype) <-chan Notification {
+	notifications := make(chan Notification, enum.SubscriptionBufferSize)
+
+	go func() {
+		defer close(notifications)
+
+		if len(datatypes) == 0 {
+			c.errorHandler(ErrNoDatatypes)
+			return
+		}
+
+		backoff := c.minBackoff
+		resume := &resumeToken{}
+		for {
+			if c.isClosed() {
+				return
+			}
+
+			delivered, err := c.subscribeOnce(ctx, datatypes, resume, notifications)
+			if ctx.Err() != nil {
+				return
+			}
+			if err != nil {
+				c.errorHandler(err)
+			}
+			if delivered {
+				backoff = c.minBackoff
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+
+			backoff *= 2
+			if backoff > c.maxBackoff {
+				backoff = c.maxBackoff
+			}
+		}
+	}()
+
+	return notifications
+}
+
+// Close closes the shared connection. Running subscriptions end at their next reconnection attempt.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+// resumeToken remembers the sequence number of the last queued notification delivered by a subscription
+type resumeToken struct {
+	sequence uint64
+	valid    bool
+}
+
+// subscribeOnce runs one subscription session and reports whether any notification was delivered
+func (c *Client) subscribeOnce(ctx context.Context, datatypes []enum.Datatype, resume *resumeToken, notifications chan<- Notification) (bool, error) {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+			_ = conn.Close()
+		}
+	}()
+
+	if c.resumeIdentity != "" {
+		var flags uint16
+		if resume.valid {
+			flags = 1
+		}
+
+		var body bytes.Buffer
+		_ = binary.Write(&body, binary.BigEndian, flags)
+		_ = binary.Write(&body, binary.BigEndian, uint16(0))
+		_ = binary.Write(&body, binary.BigEndian, resume.sequence)
+		body.WriteString(c.resumeIdentity)
+
+		if err := api.WriteFrame(conn, enum.GossipResume, body.Bytes()); err != nil {
+			return false, &ConnectionError{Address: c.address, Err: err}
+		}
+	}
+
+	for _, datatype := range datatypes {
+		var body bytes.Buffer
+		_ = binary.Write(&body, binary.BigEndian, uint16(0))
+		_ = binary.Write(&body, binary.BigEndian, datatype)
+
+		if err := api.WriteFrame(conn, enum.GossipNotify, body.Bytes()); err != nil {
+			return false, &ConnectionError{Address: c.address, Err: err}
+		}
+	}
+
+	delivered := false
+	for {
+		messageType, body, err := api.ReadFrame(conn)
+		if err != nil {
+			return delivered, &ConnectionError{Address: c.address, Err: err}
+		}
+
+		// Durable sessions receive queued notifications, which start with their sequence number
+		var sequence uint64
+		if messageType == enum.GossipQueuedNotification && len(body) >= 8 {
+			sequence, body = binary.BigEndian.Uint64(body[0:8]), body[8:]
+		} else if messageType != enum.GossipNotification {
+			return delivered, fmt.Errorf("%w: type %d", ErrUnexpectedMessage, messageType)
+		}
+		if len(body) < 4 {
+			return delivered, fmt.Errorf("%w: type %d", ErrUnexpectedMessage, messageType)
+		}
+
+		notification := Notification{
+			MessageID: binary.BigEndian.Uint16(body[0:2]),
+			Datatype:  enum.Datatype(binary.BigEndian.Uint16(body[2:4])),
+			Data:      body[4:],
+			Sequence:  sequence,
+		}
+
+		select {
+		case notifications <- notification:
+			delivered = true
+			if messageType == enum.GossipQueuedNotification {
+				resume.sequence, resume.valid = sequence, true
+			}
+		case <-ctx.Done():
+			return delivered, nil
+		}
+	}
+}
+
+// send writes one message over the shared connection, reconnecting once if the connection was lost
+func (c *Client) send(ctx context.Context, messageType uint16, body []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sendLocked(ctx, messageType, body)
+}
+
+// sendLocked is send with c.mu held
+func (c *Client) sendLocked(ctx context.Context, messageType uint16, body []byte) error {
+	if c.closed {
+		return ErrClosed
+	}
+
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			c.conn, err = c.dial(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			_ = c.conn.SetWriteDeadline(deadline)
+		} else {
+			_ = c.conn.SetWriteDeadline(time.Time{})
+		}
+
+		err = api.WriteFrame(c.conn, messageType, body)
+		if err == nil {
+			return nil
+		}
+
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+
+	return &ConnectionError{Address: c.address, Err: err}
+}
+
+// dial opens a new session and authenticates it if a token is configured
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	network, address := "tcp", c.address
+	if strings.HasPrefix(address, unixScheme) {
+		network, address = "unix", strings.TrimPrefix(address, unixScheme)
+	}
+
+	dialer := net.Dialer{Timeout: c.dialTimeout}
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, &ConnectionError{Address: c.address, Err: err}
+	}
+
+	if c.token != "" {
+		if err := api.WriteFrame(conn, enum.GossipAuth, []byte(c.token)); err != nil {
+			_ = conn.Close()
+			return nil, &ConnectionError{Address: c.address, Err: err}
+		}
+	}
+
+	return conn, nil
+}
+
+func (c *Client) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
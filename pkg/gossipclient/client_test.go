@@ -0,0 +1,261 @@
+package gossipclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	apiserver "gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/cmd/api"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/protocols/api"
+)
+
+type testNode struct {
+	address             string
+	announceMsgChan     chan enum.AnnounceMsg
+	notificationMsgChan chan enum.NotificationMsg
+	datatypeMapper      *common.DatatypeMapper
+}
+
+// startNode runs an in-process api.Server on a random port
+func startNode(t *testing.T, accessControl *api.AccessControl) *testNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	node := &testNode{
+		address:             listener.Addr().String(),
+		announceMsgChan:     make(chan enum.AnnounceMsg),
+		notificationMsgChan: make(chan enum.NotificationMsg),
+		datatypeMapper:      common.NewMap(),
+	}
+
+	dispatcher := common.NewDispatcher(node.notificationMsgChan)
+	go dispatcher.Run()
+
+	server := apiserver.NewServer(node.address, enum.APISocketMode, node.announceMsgChan, dispatcher, node.datatypeMapper, accessControl, nil)
+	go server.Serve(context.Background(), listener)
+
+	t.Cleanup(func() {
+		_ = listener.Close()
+		close(node.notificationMsgChan)
+	})
+
+	return node
+}
+
+// TestAnnounce checks that an announce reaches the announce channel of the node.
+func TestAnnounce(t *testing.T) {
+	node := startNode(t, nil)
+	received := make(chan enum.AnnounceMsg, 1)
+	go func() { received <- <-node.announceMsgChan }()
+
+	client := New(node.address)
+	defer client.Close()
+
+	require.NoError(t, client.Announce(context.Background(), 3, 7, []byte("hello")))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, enum.AnnounceMsg{TTL: 3, Reserved: api.AnnounceRequestResult, DataType: 7, Data: "hello"}, msg)
+	case <-time.After(2 * time.Second):
+		t.Fatal("announce not received")
+	}
+}
+
+// TestSubscribeAndValidate checks notification delivery and that an invalid validation is recorded.
+func TestSubscribeAndValidate(t *testing.T) {
+	node := startNode(t, nil)
+
+	client := New(node.address)
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	notifications := client.Subscribe(ctx, 1, 2)
+	require.Eventually(t, func() bool {
+		return len(node.datatypeMapper.GetAddressesByType(2)) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	node.notificationMsgChan <- enum.NotificationMsg{MessageID: 42, DataType: 2, Data: "payload"}
+
+	select {
+	case notification := <-notifications:
+		assert.Equal(t, Notification{MessageID: 42, Datatype: 2, Data: []byte("payload")}, notification)
+	case <-time.After(2 * time.Second):
+		t.Fatal("notification not received")
+	}
+
+	require.NoError(t, client.Validate(42, false))
+	require.Eventually(t, func() bool {
+		return !node.datatypeMapper.CheckNotify(42, 2)
+	}, 2*time.Second, 10*time.Millisecond)
+
+	cancel()
+	for range notifications {
+	}
+}
+
+// TestToken checks that sessions authenticate with the configured token.
+func TestToken(t *testing.T) {
+	credential := api.NewCredential("test")
+	credential.Token = "secret"
+	credential.Allow(api.OpAnnounce, 1)
+	node := startNode(t, api.NewAccessControl(credential))
+
+	received := make(chan enum.AnnounceMsg, 1)
+	go func() { received <- <-node.announceMsgChan }()
+
+	client := New(node.address, WithToken("secret"))
+	defer client.Close()
+
+	require.NoError(t, client.Announce(context.Background(), 1, 1, []byte("authenticated")))
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "authenticated", msg.Data)
+	case <-time.After(2 * time.Second):
+		t.Fatal("announce not received")
+	}
+}
+
+// TestAnnounceRejected checks that announces the node does not take are reported and that the session survives them.
+func TestAnnounceRejected(t *testing.T) {
+	credential := api.NewCredential("test")
+	credential.Token = "secret"
+	credential.Allow(api.OpAnnounce, 1)
+	node := startNode(t, api.NewAccessControl(credential))
+
+	client := New(node.address, WithToken("secret"))
+	defer client.Close()
+
+	err := client.Announce(context.Background(), 1, 2, []byte("forbidden"))
+	var announceErr *AnnounceError
+	require.ErrorAs(t, err, &announceErr)
+	assert.Contains(t, announceErr.Reason, "may not announce datatype 2")
+
+	received := make(chan enum.AnnounceMsg, 1)
+	go func() { received <- <-node.announceMsgChan }()
+	require.NoError(t, client.Announce(context.Background(), 1, 1, []byte("allowed")))
+	assert.Equal(t, "allowed", (<-received).Data)
+}
+
+// TestSubscribeReconnect checks that a subscription survives the node dropping the connection.
+func TestSubscribeReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	go func() {
+		// The first session is dropped right after the NOTIFY message
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		_, _, _ = api.ReadFrame(conn)
+		_ = conn.Close()
+
+		conn, err = listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, _, _ = api.ReadFrame(conn)
+
+		var body bytes.Buffer
+		_ = binary.Write(&body, binary.BigEndian, uint16(9))
+		_ = binary.Write(&body, binary.BigEndian, enum.Datatype(5))
+		body.WriteString("again")
+		_ = api.WriteFrame(conn, enum.GossipNotification, body.Bytes())
+		time.Sleep(time.Second)
+	}()
+
+	client := New(listener.Addr().String(), WithReconnectBackoff(10*time.Millisecond, 50*time.Millisecond))
+	defer client.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	select {
+	case notification := <-client.Subscribe(ctx, 5):
+		assert.Equal(t, uint16(9), notification.MessageID)
+		assert.Equal(t, []byte("again"), notification.Data)
+	case <-ctx.Done():
+		t.Fatal("notification not received after reconnect")
+	}
+}
+
+// TestAnnounceTooLarge checks the typed error for oversized payloads.
+func TestAnnounceTooLarge(t *testing.T) {
+	client := New("127.0.0.1:1")
+	err := client.Announce(context.Background(), 1, 1, make([]byte, maxAnnounceData+1))
+	assert.ErrorIs(t, err, ErrDataTooLarge)
+
+	var connectionError *ConnectionError
+	assert.ErrorAs(t, client.Announce(context.Background(), 1, 1, []byte("x")), &connectionError)
+}
+
+// TestSubscribeResume checks that notifications queued while the module was away are delivered after resuming.
+func TestSubscribeResume(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	notificationMsgChan := make(chan enum.NotificationMsg)
+	defer close(notificationMsgChan)
+	datatypeMapper := common.NewMap()
+	dispatcher := common.NewDispatcher(notificationMsgChan)
+	go dispatcher.Run()
+
+	durableQueues := api.NewDurableQueues(t.TempDir(), 16, time.Hour, dispatcher, datatypeMapper)
+	server := apiserver.NewServer(listener.Addr().String(), enum.APISocketMode, make(chan enum.AnnounceMsg), dispatcher, datatypeMapper, nil, durableQueues)
+	go server.Serve(context.Background(), listener)
+
+	client := New(listener.Addr().String(), WithResume("chat"))
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	notifications := client.Subscribe(ctx, 3)
+	require.Eventually(t, func() bool {
+		return len(datatypeMapper.GetAddressesByType(3)) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+
+	notificationMsgChan <- enum.NotificationMsg{MessageID: 1, DataType: 3, Data: "first"}
+	assert.Equal(t, uint16(1), (<-notifications).MessageID)
+	cancel()
+	for range notifications {
+	}
+
+	// Delivered while the module is disconnected
+	notificationMsgChan <- enum.NotificationMsg{MessageID: 2, DataType: 3, Data: "second"}
+	notificationMsgChan <- enum.NotificationMsg{MessageID: 2, DataType: 3, Data: "duplicate"}
+	notificationMsgChan <- enum.NotificationMsg{MessageID: 3, DataType: 3, Data: "third"}
+
+	// A new client resuming from message 1 receives exactly the missed notifications
+	resumed := New(listener.Addr().String(), WithResume("chat"))
+	defer resumed.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	token := &resumeToken{sequence: 1, valid: true}
+	received := make(chan Notification, 4)
+	go func() { _, _ = resumed.subscribeOnce(ctx, []enum.Datatype{3}, token, received) }()
+
+	for i, expected := range []string{"second", "third"} {
+		select {
+		case notification := <-received:
+			assert.Equal(t, expected, string(notification.Data))
+			assert.Equal(t, uint64(i+2), notification.Sequence)
+		case <-ctx.Done():
+			t.Fatal("missed notification not delivered")
+		}
+	}
+}
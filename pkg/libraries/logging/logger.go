@@ -57,7 +57,7 @@ func (c *Logger) Info(msg string) {
 
 // InfoF logs a formatted message at Info level.
 func (c *Logger) InfoF(format string, v ...interface{}) {
-	c.logger.Info().Msgf(c.formatWithNetworkF(format, v...))
+	c.logger.Info().Msg(c.formatWithNetworkF(format, v...))
 }
 
 // Error logs a message at Error level.
@@ -67,7 +67,7 @@ func (c *Logger) Error(msg string) {
 
 // ErrorF logs a message at Error level.
 func (c *Logger) ErrorF(format string, v ...interface{}) {
-	c.logger.Error().Msgf(c.formatWithNetworkF(format, v...))
+	c.logger.Error().Msg(c.formatWithNetworkF(format, v...))
 }
 
 // Fatal logs a message at Fatal level and exits the application.
@@ -77,7 +77,7 @@ func (c *Logger) Fatal(msg string) {
 
 // FatalF logs a formatted message at Fatal level and exits the application.
 func (c *Logger) FatalF(format string, v ...interface{}) {
-	c.logger.Fatal().Msgf(c.formatWithNetworkF(format, v...))
+	c.logger.Fatal().Msg(c.formatWithNetworkF(format, v...))
 }
 
 // Debug logs a message at Debug level.
@@ -87,7 +87,7 @@ func (c *Logger) Debug(msg string) {
 
 // DebugF logs a formatted message at Debug level.
 func (c *Logger) DebugF(format string, v ...interface{}) {
-	c.logger.Debug().Msgf(c.formatWithNetworkF(format, v...))
+	c.logger.Debug().Msg(c.formatWithNetworkF(format, v...))
 }
 
 func (c *Logger) Client(client string) {
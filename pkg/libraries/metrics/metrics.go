@@ -0,0 +1,153 @@
+// Package metrics implements the counters a node exposes in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value
+type Counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+// Add increases the counter by delta
+func (c *Counter) Add(delta uint64) {
+	c.value.Add(delta)
+}
+
+// Value returns the current value of the counter
+func (c *Counter) Value() uint64 {
+	return c.value.Load()
+}
+
+func (c *Counter) write(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n%s %d\n", c.name, c.help, c.name, c.name, c.Value())
+	return err
+}
+
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	name    string
+	help    string
+	mu      sync.Mutex
+	bounds  []float64
+	buckets []uint64
+	count   uint64
+	sum     float64
+}
+
+// Observe records one observation
+func (h *Histogram) Observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.bounds {
+		if value <= bound {
+			h.buckets[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name); err != nil {
+		return err
+	}
+	for i, bound := range h.bounds {
+		if _, err := fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", h.name, bound, h.buckets[i]); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n%s_sum %g\n%s_count %d\n", h.name, h.count, h.name, h.sum, h.name, h.count)
+	return err
+}
+
+// metric is implemented by every metric type of a Registry
+type metric interface {
+	write(w io.Writer) error
+}
+
+// Registry holds metrics by name
+type Registry struct {
+	mu      sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// Default is the registry of the node, served by the HTTP gateway
+var Default = NewRegistry()
+
+// Counter returns the counter registered under name, registering it first if needed
+func (r *Registry) Counter(name string, help string) *Counter {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.metrics[name]; exists {
+		return existing.(*Counter)
+	}
+	c := &Counter{name: name, help: help}
+	r.metrics[name] = c
+	return c
+}
+
+// Histogram returns the histogram registered under name, registering it with the given ascending bucket bounds first if needed
+func (r *Registry) Histogram(name string, help string, bounds []float64) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if existing, exists := r.metrics[name]; exists {
+		return existing.(*Histogram)
+	}
+	h := &Histogram{name: name, help: help, bounds: bounds, buckets: make([]uint64, len(bounds))}
+	r.metrics[name] = h
+	return h
+}
+
+// WriteText writes all metrics sorted by name in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	selected := make([]metric, 0, len(names))
+	for _, name := range names {
+		selected = append(selected, r.metrics[name])
+	}
+	r.mu.Unlock()
+
+	for _, m := range selected {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ServeHTTP serves the metrics of the registry
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_ = r.WriteText(w)
+}
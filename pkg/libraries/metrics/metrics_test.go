@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("saved_bytes_total", "Bytes saved.").Add(42)
+	h := r.Histogram("batch_size", "Messages per batch.", []float64{1, 4})
+	h.Observe(1)
+	h.Observe(3)
+	h.Observe(10)
+
+	// Metrics are registered once per name
+	assert.Same(t, h, r.Histogram("batch_size", "", nil))
+
+	var out bytes.Buffer
+	require.NoError(t, r.WriteText(&out))
+	assert.Equal(t, `# HELP batch_size Messages per batch.
+# TYPE batch_size histogram
+batch_size_bucket{le="1"} 1
+batch_size_bucket{le="4"} 2
+batch_size_bucket{le="+Inf"} 3
+batch_size_sum 14
+batch_size_count 3
+# HELP saved_bytes_total Bytes saved.
+# TYPE saved_bytes_total counter
+saved_bytes_total 42
+`, out.String())
+}
@@ -0,0 +1,253 @@
+// Package msglog implements the append-only, segment-rotated log of gossip messages seen by a node.
+package msglog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// segmentSuffix is the file extension of log segments, which are named by their sequence number
+const segmentSuffix = ".log"
+
+// Decision records what the node did with a received message
+type Decision string
+
+const (
+	DecisionForwarded  Decision = "forwarded"
+	DecisionDroppedPoW Decision = "dropped_pow"
+	DecisionDuplicate  Decision = "duplicate"
+	DecisionInvalid    Decision = "invalid"
+	// DecisionReplayed marks messages received from the history of a peer, which are delivered but not forwarded
+	DecisionReplayed Decision = "replayed"
+)
+
+// Entry is one logged gossip message. Sender is the peer it was received from, Originator the node that announced it.
+// Lazily transferred messages whose payload was not fetched are logged with their content hash instead.
+type Entry struct {
+	Received    time.Time `json:"received"`
+	MessageID   uint32    `json:"message_id"`
+	Datatype    int32     `json:"datatype"`
+	Sender      string    `json:"sender"`
+	Originator  string    `json:"originator"`
+	TTL         int32     `json:"ttl"`
+	Decision    Decision  `json:"decision"`
+	Payload     []byte    `json:"payload"`
+	ContentHash []byte    `json:"content_hash,omitempty"`
+}
+
+// Log appends entries to the newest segment in dir. A new segment is started once the current one
+// exceeds maxSegmentSize bytes; only the newest maxSegments segments are kept.
+type Log struct {
+	mu             sync.Mutex
+	dir            string
+	maxSegmentSize int64
+	maxSegments    int
+	segment        uint64
+	size           int64
+	file           *os.File
+}
+
+// Open opens the log in dir and continues its newest segment
+func Open(dir string, maxSegmentSize int64, maxSegments int) (*Log, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, maxSegmentSize: maxSegmentSize, maxSegments: maxSegments}
+
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) > 0 {
+		l.segment = segments[len(segments)-1]
+	}
+
+	if err := l.openSegment(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func segmentPath(dir string, segment uint64) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", segment, segmentSuffix))
+}
+
+// listSegments returns the sequence numbers of all segments in dir, oldest first
+func listSegments(dir string) ([]uint64, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []uint64
+	for _, file := range files {
+		var segment uint64
+		if !strings.HasSuffix(file.Name(), segmentSuffix) {
+			continue
+		}
+		if _, err := fmt.Sscanf(file.Name(), "%d"+segmentSuffix, &segment); err == nil {
+			segments = append(segments, segment)
+		}
+	}
+	sort.Slice(segments, func(i, j int) bool { return segments[i] < segments[j] })
+
+	return segments, nil
+}
+
+func (l *Log) openSegment() error {
+	file, err := os.OpenFile(segmentPath(l.dir, l.segment), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	l.file = file
+	l.size = info.Size()
+	return nil
+}
+
+// rotate starts a new segment and removes segments beyond maxSegments
+func (l *Log) rotate() error {
+	if err := l.file.Close(); err != nil {
+		return err
+	}
+	l.segment++
+	if err := l.openSegment(); err != nil {
+		return err
+	}
+
+	segments, err := listSegments(l.dir)
+	if err != nil {
+		return err
+	}
+	for len(segments) > l.maxSegments {
+		if err := os.Remove(segmentPath(l.dir, segments[0])); err != nil {
+			return err
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+// Append writes entry to the log
+func (l *Log) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return fmt.Errorf("message log %s is closed", l.dir)
+	}
+	if l.size > 0 && l.size+int64(len(line)) > l.maxSegmentSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// Close closes the current segment
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Query selects log entries. Zero values do not restrict the result.
+type Query struct {
+	Datatype     int32
+	HasDatatype  bool
+	MessageID    uint32
+	HasMessageID bool
+	Since        time.Time
+	Until        time.Time
+}
+
+// Matches reports whether entry is selected by the query
+func (q Query) Matches(entry Entry) bool {
+	if q.HasDatatype && entry.Datatype != q.Datatype {
+		return false
+	}
+	if q.HasMessageID && entry.MessageID != q.MessageID {
+		return false
+	}
+	if !q.Since.IsZero() && entry.Received.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Received.After(q.Until) {
+		return false
+	}
+	return true
+}
+
+// Read calls fn for every entry in dir matching query, oldest first
+func Read(dir string, query Query, fn func(Entry) error) error {
+	segments, err := listSegments(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, segment := range segments {
+		if err := readSegment(segmentPath(dir, segment), query, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSegment(path string, query Query, fn func(Entry) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	// Lines are read whole, lazily transferred payloads make entries of several MiB
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry Entry
+			// A torn last line after a crash is skipped
+			if json.Unmarshal(line, &entry) == nil && query.Matches(entry) {
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package msglog
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestLogRotationAndQuery checks segment rotation, retention and queries across segments.
+func TestLogRotationAndQuery(t *testing.T) {
+	dir := t.TempDir()
+
+	l, err := Open(dir, 400, 3)
+	require.NoError(t, err)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		require.NoError(t, l.Append(Entry{
+			Received:  start.Add(time.Duration(i) * time.Second),
+			MessageID: uint32(i),
+			Datatype:  int32(i % 2),
+			Sender:    "localhost:9000",
+			TTL:       3,
+			Decision:  DecisionForwarded,
+		}))
+	}
+	require.NoError(t, l.Close())
+
+	segments, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, segments, 3)
+
+	var ids []uint32
+	require.NoError(t, Read(dir, Query{Datatype: 1, HasDatatype: true, Since: start.Add(5 * time.Second)}, func(entry Entry) error {
+		ids = append(ids, entry.MessageID)
+		return nil
+	}))
+	assert.Equal(t, []uint32{5, 7, 9}, ids)
+
+	// Reopening continues the newest segment
+	l, err = Open(dir, 400, 3)
+	require.NoError(t, err)
+	require.NoError(t, l.Append(Entry{MessageID: 42, Decision: DecisionDuplicate}))
+	require.NoError(t, l.Close())
+
+	var found []Entry
+	require.NoError(t, Read(dir, Query{MessageID: 42, HasMessageID: true}, func(entry Entry) error {
+		found = append(found, entry)
+		return nil
+	}))
+	require.Len(t, found, 1)
+	assert.Equal(t, DecisionDuplicate, found[0].Decision)
+}
+
+// TestReadLargeEntry checks that entries with payloads of several MiB, like lazily transferred ones, can be read.
+func TestReadLargeEntry(t *testing.T) {
+	dir := t.TempDir()
+	payload := bytes.Repeat([]byte{0xab}, 3*1024*1024)
+
+	l, err := Open(dir, 64*1024*1024, 3)
+	require.NoError(t, err)
+	require.NoError(t, l.Append(Entry{MessageID: 1, Decision: DecisionForwarded, Payload: payload}))
+	require.NoError(t, l.Append(Entry{MessageID: 2, Decision: DecisionForwarded}))
+	require.NoError(t, l.Close())
+
+	var found []Entry
+	require.NoError(t, Read(dir, Query{}, func(entry Entry) error {
+		found = append(found, entry)
+		return nil
+	}))
+	require.Len(t, found, 2)
+	assert.Equal(t, payload, found[0].Payload)
+	assert.Equal(t, uint32(2), found[1].MessageID)
+}
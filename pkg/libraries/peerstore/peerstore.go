@@ -0,0 +1,235 @@
+// Package peerstore persists the peer registry of the bootstrapper as a snapshot plus a write-ahead log.
+package peerstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	snapshotFile = "snapshot.json"
+	walFile      = "wal.log"
+)
+
+// Record is the registry state of one peer. PublicKey is the ed25519 key the peer registered with, if any.
+type Record struct {
+	Peer      string    `json:"peer"`
+	LastSeen  time.Time `json:"last_seen"`
+	Seed      bool      `json:"seed"`
+	PublicKey []byte    `json:"public_key,omitempty"`
+}
+
+// walEntry is one change appended to the write-ahead log
+type walEntry struct {
+	Op     string `json:"op"`
+	Record Record `json:"record"`
+}
+
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// Store keeps the records of all registered peers. Every change is appended to the write-ahead log; once it holds
+// more than compactAfter entries, a new snapshot is written atomically and the log is truncated.
+// Changes of the set of peers are synced to disk, refreshed last-seen times are not.
+type Store struct {
+	mu           sync.Mutex
+	dir          string
+	compactAfter int
+	records      map[string]Record
+	wal          *os.File
+	walEntries   int
+}
+
+// Open loads the snapshot and replays the write-ahead log in dir, creating both if needed
+func Open(dir string, compactAfter int) (*Store, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	s := &Store{dir: dir, compactAfter: compactAfter, records: make(map[string]Record)}
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	// Starting from a fresh snapshot also drops a torn last line of the log
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var records []Record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return fmt.Errorf("invalid snapshot: %w", err)
+	}
+	for _, record := range records {
+		s.records[record.Peer] = record
+	}
+	return nil
+}
+
+func (s *Store) replay() error {
+	file, err := os.Open(filepath.Join(s.dir, walFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry walEntry
+		// A torn last line after a crash is skipped
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		s.apply(entry)
+	}
+	return scanner.Err()
+}
+
+func (s *Store) apply(entry walEntry) {
+	switch entry.Op {
+	case opPut:
+		s.records[entry.Record.Peer] = entry.Record
+	case opDelete:
+		delete(s.records, entry.Record.Peer)
+	}
+}
+
+// compact writes all records to a new snapshot and starts an empty write-ahead log
+func (s *Store) compact() error {
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Peer < records[j].Peer })
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := filepath.Join(s.dir, snapshotFile+".tmp")
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return err
+	}
+
+	if s.wal != nil {
+		_ = s.wal.Close()
+	}
+	s.wal, err = os.OpenFile(filepath.Join(s.dir, walFile), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	s.walEntries = 0
+	return err
+}
+
+func (s *Store) append(entry walEntry, sync bool) error {
+	if s.wal == nil {
+		return fmt.Errorf("peer store %s is closed", s.dir)
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := s.wal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	if sync {
+		if err := s.wal.Sync(); err != nil {
+			return err
+		}
+	}
+
+	s.apply(entry)
+	s.walEntries++
+	if s.walEntries > s.compactAfter {
+		return s.compact()
+	}
+	return nil
+}
+
+// Put stores record, replacing the record of the same peer
+func (s *Store) Put(record Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, exists := s.records[record.Peer]
+	membershipChanged := !exists || previous.Seed != record.Seed
+	return s.append(walEntry{Op: opPut, Record: record}, membershipChanged)
+}
+
+// Delete removes the record of peer
+func (s *Store) Delete(peer string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.records[peer]; !exists {
+		return nil
+	}
+	return s.append(walEntry{Op: opDelete, Record: Record{Peer: peer}}, true)
+}
+
+// Records returns all stored records sorted by peer
+func (s *Store) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	records := make([]Record, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Peer < records[j].Peer })
+	return records
+}
+
+// Close writes a final snapshot and closes the write-ahead log
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.wal == nil {
+		return nil
+	}
+	err := s.compact()
+	_ = s.wal.Close()
+	s.wal = nil
+	return err
+}
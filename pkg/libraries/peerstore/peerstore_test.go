@@ -0,0 +1,41 @@
+package peerstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReloadAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	seen := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+
+	store, err := Open(dir, 3)
+	require.NoError(t, err)
+	for _, peer := range []string{"127.0.0.1:7001", "127.0.0.1:7002", "127.0.0.1:7003"} {
+		require.NoError(t, store.Put(Record{Peer: peer, LastSeen: seen, Seed: peer == "127.0.0.1:7001"}))
+	}
+	require.NoError(t, store.Delete("127.0.0.1:7003"))
+	// The fifth change compacts the log into a new snapshot
+	require.NoError(t, store.Put(Record{Peer: "127.0.0.1:7002", LastSeen: seen.Add(time.Minute)}))
+
+	// Simulate a crash: the log is not compacted on close, and its last line is torn
+	wal, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = wal.WriteString(`{"op":"put","record":{"peer":"127.0.0.1:70`)
+	require.NoError(t, err)
+	require.NoError(t, wal.Close())
+
+	reopened, err := Open(dir, 3)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, []Record{
+		{Peer: "127.0.0.1:7001", LastSeen: seen, Seed: true},
+		{Peer: "127.0.0.1:7002", LastSeen: seen.Add(time.Minute)},
+	}, reopened.Records())
+}
@@ -11,7 +11,12 @@ import (
 )
 
 func ConcatMembers(gm *pb.GossipMessage) string {
-	return fmt.Sprintf("%d%s%s%d%s", gm.Type, gm.From, gm.Payload, gm.Ttl, gm.MessageId)
+	members := fmt.Sprintf("%d%s%s%d%s", gm.Type, gm.From, gm.Payload, gm.Ttl, gm.MessageId)
+	// Lazily transferred messages are bound to their content by the hash, inline messages are unchanged
+	if len(gm.ContentHash) > 0 {
+		members += fmt.Sprintf("%x%d", gm.ContentHash, gm.ContentSize)
+	}
+	return members
 }
 
 // CalculateAndAddNonce runs the Proof of Work algorithm to find a valid hash.
@@ -46,3 +51,19 @@ func Validate(msg *pb.GossipMessage) bool {
 	calculatedHash := hex.EncodeToString(h.Sum(nil))
 	return strings.HasPrefix(calculatedHash, enum.Difficulty)
 }
+
+// SolveChallenge finds a nonce for which the SHA-256 hash of data followed by the nonce starts with prefix.
+// Nodes use it to answer the registration challenges of the bootstrapper.
+func SolveChallenge(data string, prefix string) uint64 {
+	var nonce uint64
+	for !CheckChallenge(data, nonce, prefix) {
+		nonce++
+	}
+	return nonce
+}
+
+// CheckChallenge checks a nonce found by SolveChallenge.
+func CheckChallenge(data string, nonce uint64, prefix string) bool {
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s%d", data, nonce)))
+	return strings.HasPrefix(hex.EncodeToString(sum[:]), prefix)
+}
@@ -0,0 +1,289 @@
+// Package queue implements a bounded notification queue persisted as JSON lines on local disk.
+package queue
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// Entry is one queued notification. Sequence numbers grow by one per appended entry.
+type Entry struct {
+	Sequence  uint64        `json:"sequence"`
+	Received  time.Time     `json:"received"`
+	MessageID uint16        `json:"message_id"`
+	Datatype  enum.Datatype `json:"datatype"`
+	Data      string        `json:"data"`
+}
+
+// Queue keeps at most maxEntries notifications not older than maxAge. Message IDs are random 16 bit numbers that are
+// reused over time, so a notification is only dropped as duplicate if its message ID was queued within dedupWindow.
+// Every entry is appended to the queue file. Dropped entries stay in the file until as many of them piled up as the
+// queue holds at most, then the file is compacted, so it never grows beyond twice the bound.
+type Queue struct {
+	mu          sync.Mutex
+	path        string
+	maxEntries  int
+	maxAge      time.Duration
+	dedupWindow time.Duration
+	entries     []Entry
+	// recent holds when each message ID was queued last, it has at most one key per possible message ID
+	recent       map[uint16]time.Time
+	nextSequence uint64
+	// stale counts the lines of the queue file whose entries were dropped or could not be read
+	stale   int
+	file    *os.File
+	changed chan struct{}
+}
+
+// Open loads the queue stored at path, creating it if needed
+func Open(path string, maxEntries int, maxAge time.Duration, dedupWindow time.Duration) (*Queue, error) {
+	q := &Queue{
+		path:         path,
+		maxEntries:   maxEntries,
+		maxAge:       maxAge,
+		dedupWindow:  dedupWindow,
+		recent:       make(map[uint16]time.Time),
+		nextSequence: 1,
+		changed:      make(chan struct{}),
+	}
+
+	torn, err := q.load()
+	if err != nil {
+		return nil, err
+	}
+	q.prune(time.Now())
+	// Appending after a torn last line would corrupt the next entry
+	if torn || q.stale >= q.maxEntries {
+		err = q.compact()
+	} else {
+		q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// load reads the queue file and reports whether its last line is torn. Entries beyond the size bound are skipped while
+// reading, so a file that was not compacted yet is never held in memory as a whole.
+func (q *Queue) load() (bool, error) {
+	file, err := os.Open(q.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	// Lines are read whole, however large the notification they hold
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry Entry
+			// A torn last line after a crash is skipped
+			if json.Unmarshal(line, &entry) == nil {
+				q.add(entry)
+				if len(q.entries) > q.maxEntries {
+					q.drop(len(q.entries) - q.maxEntries)
+				}
+			} else {
+				q.stale++
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return len(line) > 0, nil
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+}
+
+// add keeps entry in memory
+func (q *Queue) add(entry Entry) {
+	q.entries = append(q.entries, entry)
+	if entry.Received.After(q.recent[entry.MessageID]) {
+		q.recent[entry.MessageID] = entry.Received
+	}
+	if entry.Sequence >= q.nextSequence {
+		q.nextSequence = entry.Sequence + 1
+	}
+}
+
+// prune drops entries beyond the size and age bounds and reports whether any was dropped
+func (q *Queue) prune(now time.Time) bool {
+	drop := 0
+	if len(q.entries) > q.maxEntries {
+		drop = len(q.entries) - q.maxEntries
+	}
+	for drop < len(q.entries) && now.Sub(q.entries[drop].Received) > q.maxAge {
+		drop++
+	}
+	if drop == 0 {
+		return false
+	}
+
+	q.drop(drop)
+	return true
+}
+
+// drop removes the n oldest entries. Their lines stay in the queue file until it is compacted.
+func (q *Queue) drop(n int) {
+	clear(q.entries[:n])
+	q.entries = q.entries[n:]
+	q.stale += n
+}
+
+// compact atomically replaces the queue file with the current entries and reopens it for appending
+func (q *Queue) compact() error {
+	if q.file != nil {
+		_ = q.file.Close()
+		q.file = nil
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(q.path), filepath.Base(q.path)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, entry := range q.entries {
+		if err := encoder.Encode(entry); err != nil {
+			_ = tmp.Close()
+			_ = os.Remove(tmp.Name())
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), q.path); err != nil {
+		return err
+	}
+
+	q.file, err = os.OpenFile(q.path, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	// The slice may still reference the backing array of every dropped entry
+	q.entries = append([]Entry(nil), q.entries...)
+	q.stale = 0
+	return nil
+}
+
+// Append queues a notification. Notifications whose message ID was queued within the dedup window are ignored.
+func (q *Queue) Append(msg enum.NotificationMsg) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return false, fmt.Errorf("queue %s is closed", q.path)
+	}
+	now := time.Now()
+	if queued, seen := q.recent[msg.MessageID]; seen && now.Sub(queued) < q.dedupWindow {
+		return false, nil
+	}
+
+	entry := Entry{
+		Sequence:  q.nextSequence,
+		Received:  now,
+		MessageID: msg.MessageID,
+		Datatype:  msg.DataType,
+		Data:      msg.Data,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return false, err
+	}
+	if _, err := q.file.Write(append(line, '\n')); err != nil {
+		return false, err
+	}
+	q.add(entry)
+
+	if q.prune(entry.Received) && q.stale >= q.maxEntries {
+		if err := q.compact(); err != nil {
+			return false, err
+		}
+	}
+
+	// Wake up every reader waiting for new entries
+	close(q.changed)
+	q.changed = make(chan struct{})
+
+	return true, nil
+}
+
+// After returns the entries with a sequence number greater than sequence, in order
+func (q *Queue) After(sequence uint64) []Entry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.prune(time.Now())
+
+	var entries []Entry
+	for _, entry := range q.entries {
+		if entry.Sequence > sequence {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// Resume returns the sequence number to read after for a module whose last received notification had the given
+// sequence number. Sequence numbers beyond the newest entry belong to a queue that was lost, everything queued is
+// returned again then.
+func (q *Queue) Resume(sequence uint64) uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if sequence >= q.nextSequence {
+		return 0
+	}
+	return sequence
+}
+
+// Last returns the sequence number of the newest entry
+func (q *Queue) Last() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.nextSequence - 1
+}
+
+// Changed returns a channel that is closed by the next Append
+func (q *Queue) Changed() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.changed
+}
+
+// Close closes the queue file
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
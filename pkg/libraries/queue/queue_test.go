@@ -0,0 +1,157 @@
+package queue
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+func messageIDs(entries []Entry) []uint16 {
+	var ids []uint16
+	for _, entry := range entries {
+		ids = append(ids, entry.MessageID)
+	}
+	return ids
+}
+
+// TestQueuePersistence checks ordering, de-duplication, the size bound and reloading from disk.
+func TestQueuePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.queue")
+
+	q, err := Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+
+	for _, id := range []uint16{10, 11, 11, 12, 13} {
+		_, err := q.Append(enum.NotificationMsg{MessageID: id, DataType: 1, Data: "data"})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, []uint16{11, 12, 13}, messageIDs(q.After(0)))
+	require.NoError(t, q.Close())
+
+	q, err = Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+	defer q.Close()
+
+	assert.Equal(t, []uint16{11, 12, 13}, messageIDs(q.After(0)))
+	// 10 got sequence number 1 and was dropped, the duplicate of 11 got none, 12 got 3
+	assert.Equal(t, []uint16{13}, messageIDs(q.After(q.Resume(3))))
+	assert.Equal(t, []uint16{11, 12, 13}, messageIDs(q.After(q.Resume(1))), "dropped entries are skipped")
+	assert.Equal(t, []uint16{11, 12, 13}, messageIDs(q.After(q.Resume(99))), "a token of a lost queue replays everything")
+
+	_, err = q.Append(enum.NotificationMsg{MessageID: 14, DataType: 1})
+	require.NoError(t, err)
+	assert.Equal(t, uint64(5), q.Last())
+}
+
+// TestQueueReusedMessageID checks that message IDs are only de-duplicated within the dedup window, and that
+// resuming by sequence number is not confused by a reused message ID.
+func TestQueueReusedMessageID(t *testing.T) {
+	q, err := Open(filepath.Join(t.TempDir(), "module.queue"), 10, time.Hour, 20*time.Millisecond)
+	require.NoError(t, err)
+	defer q.Close()
+
+	for _, data := range []string{"first", "duplicate"} {
+		_, err := q.Append(enum.NotificationMsg{MessageID: 5, DataType: 1, Data: data})
+		require.NoError(t, err)
+	}
+	time.Sleep(30 * time.Millisecond)
+	for _, id := range []uint16{6, 5} {
+		appended, err := q.Append(enum.NotificationMsg{MessageID: id, DataType: 1, Data: "later"})
+		require.NoError(t, err)
+		assert.True(t, appended)
+	}
+
+	entries := q.After(0)
+	assert.Equal(t, []uint16{5, 6, 5}, messageIDs(entries))
+	assert.Equal(t, "first", entries[0].Data)
+	assert.Equal(t, []uint16{6, 5}, messageIDs(q.After(q.Resume(entries[0].Sequence))))
+}
+
+// TestQueueMaxAge checks that old entries are dropped.
+func TestQueueMaxAge(t *testing.T) {
+	q, err := Open(filepath.Join(t.TempDir(), "module.queue"), 10, 20*time.Millisecond, time.Minute)
+	require.NoError(t, err)
+	defer q.Close()
+
+	_, err = q.Append(enum.NotificationMsg{MessageID: 1})
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	assert.Empty(t, q.After(0))
+}
+
+// TestQueueLargeEntry checks that a queue holding a notification larger than a MiB can be reopened.
+func TestQueueLargeEntry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.queue")
+	data := strings.Repeat("x", 2*1024*1024)
+
+	q, err := Open(path, 10, time.Hour, time.Minute)
+	require.NoError(t, err)
+	_, err = q.Append(enum.NotificationMsg{MessageID: 1, DataType: 1, Data: data})
+	require.NoError(t, err)
+	_, err = q.Append(enum.NotificationMsg{MessageID: 2, DataType: 1, Data: "small"})
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+
+	q, err = Open(path, 10, time.Hour, time.Minute)
+	require.NoError(t, err)
+	defer q.Close()
+
+	entries := q.After(0)
+	require.Len(t, entries, 2)
+	assert.Equal(t, data, entries[0].Data)
+	assert.Equal(t, "small", entries[1].Data)
+}
+
+func countLines(t *testing.T, path string) int {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Count(string(content), "\n")
+}
+
+// TestQueueCompactsLazily checks that dropped entries are only removed from the file once as many piled up as the queue
+// holds, that they are skipped when the queue is reopened, and that a torn last line does not corrupt later entries.
+func TestQueueCompactsLazily(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "module.queue")
+
+	q, err := Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+	for id := uint16(1); id <= 5; id++ {
+		_, err := q.Append(enum.NotificationMsg{MessageID: id, DataType: 1})
+		require.NoError(t, err)
+	}
+	assert.Equal(t, 5, countLines(t, path), "two dropped entries are not compacted yet")
+	require.NoError(t, q.Close())
+
+	q, err = Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, []uint16{3, 4, 5}, messageIDs(q.After(0)))
+	_, err = q.Append(enum.NotificationMsg{MessageID: 6, DataType: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 3, countLines(t, path), "three dropped entries are compacted")
+	require.NoError(t, q.Close())
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+	require.NoError(t, err)
+	_, err = file.WriteString(`{"sequence":7,"rece`)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+
+	q, err = Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+	_, err = q.Append(enum.NotificationMsg{MessageID: 7, DataType: 1})
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+
+	q, err = Open(path, 3, time.Hour, time.Minute)
+	require.NoError(t, err)
+	defer q.Close()
+	assert.Equal(t, []uint16{5, 6, 7}, messageIDs(q.After(0)))
+}
@@ -0,0 +1,24 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllow(t *testing.T) {
+	limiter := New(1, 2)
+	now := time.Now()
+
+	assert.True(t, limiter.Allow("10.0.0.1", now))
+	assert.True(t, limiter.Allow("10.0.0.1", now))
+	assert.False(t, limiter.Allow("10.0.0.1", now))
+
+	// Other keys have their own bucket
+	assert.True(t, limiter.Allow("10.0.0.2", now))
+
+	// One token is refilled per second
+	assert.True(t, limiter.Allow("10.0.0.1", now.Add(time.Second)))
+	assert.False(t, limiter.Allow("10.0.0.1", now.Add(time.Second)))
+}
@@ -0,0 +1,218 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.4.0
+// - protoc             v5.26.1
+// source: gossip_api.proto
+
+package proto
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.62.0 or later.
+const _ = grpc.SupportPackageIsVersion8
+
+const (
+	GossipAPI_Announce_FullMethodName  = "/p2p.GossipAPI/Announce"
+	GossipAPI_Validate_FullMethodName  = "/p2p.GossipAPI/Validate"
+	GossipAPI_Subscribe_FullMethodName = "/p2p.GossipAPI/Subscribe"
+)
+
+// GossipAPIClient is the client API for GossipAPI service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// GossipAPI is the gRPC counterpart of the binary GOSSIP API.
+type GossipAPIClient interface {
+	Announce(ctx context.Context, in *AnnounceRequest, opts ...grpc.CallOption) (*AnnounceResponse, error)
+	Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error)
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (GossipAPI_SubscribeClient, error)
+}
+
+type gossipAPIClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewGossipAPIClient(cc grpc.ClientConnInterface) GossipAPIClient {
+	return &gossipAPIClient{cc}
+}
+
+func (c *gossipAPIClient) Announce(ctx context.Context, in *AnnounceRequest, opts ...grpc.CallOption) (*AnnounceResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(AnnounceResponse)
+	err := c.cc.Invoke(ctx, GossipAPI_Announce_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gossipAPIClient) Validate(ctx context.Context, in *ValidateRequest, opts ...grpc.CallOption) (*ValidateResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ValidateResponse)
+	err := c.cc.Invoke(ctx, GossipAPI_Validate_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *gossipAPIClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (GossipAPI_SubscribeClient, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &GossipAPI_ServiceDesc.Streams[0], GossipAPI_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &gossipAPISubscribeClient{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type GossipAPI_SubscribeClient interface {
+	Recv() (*Notification, error)
+	grpc.ClientStream
+}
+
+type gossipAPISubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *gossipAPISubscribeClient) Recv() (*Notification, error) {
+	m := new(Notification)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GossipAPIServer is the server API for GossipAPI service.
+// All implementations must embed UnimplementedGossipAPIServer
+// for forward compatibility
+//
+// GossipAPI is the gRPC counterpart of the binary GOSSIP API.
+type GossipAPIServer interface {
+	Announce(context.Context, *AnnounceRequest) (*AnnounceResponse, error)
+	Validate(context.Context, *ValidateRequest) (*ValidateResponse, error)
+	Subscribe(*SubscribeRequest, GossipAPI_SubscribeServer) error
+	mustEmbedUnimplementedGossipAPIServer()
+}
+
+// UnimplementedGossipAPIServer must be embedded to have forward compatible implementations.
+type UnimplementedGossipAPIServer struct {
+}
+
+func (UnimplementedGossipAPIServer) Announce(context.Context, *AnnounceRequest) (*AnnounceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Announce not implemented")
+}
+func (UnimplementedGossipAPIServer) Validate(context.Context, *ValidateRequest) (*ValidateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Validate not implemented")
+}
+func (UnimplementedGossipAPIServer) Subscribe(*SubscribeRequest, GossipAPI_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedGossipAPIServer) mustEmbedUnimplementedGossipAPIServer() {}
+
+// UnsafeGossipAPIServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to GossipAPIServer will
+// result in compilation errors.
+type UnsafeGossipAPIServer interface {
+	mustEmbedUnimplementedGossipAPIServer()
+}
+
+func RegisterGossipAPIServer(s grpc.ServiceRegistrar, srv GossipAPIServer) {
+	s.RegisterService(&GossipAPI_ServiceDesc, srv)
+}
+
+func _GossipAPI_Announce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AnnounceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GossipAPIServer).Announce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GossipAPI_Announce_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GossipAPIServer).Announce(ctx, req.(*AnnounceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GossipAPI_Validate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ValidateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(GossipAPIServer).Validate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: GossipAPI_Validate_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(GossipAPIServer).Validate(ctx, req.(*ValidateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _GossipAPI_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(GossipAPIServer).Subscribe(m, &gossipAPISubscribeServer{ServerStream: stream})
+}
+
+type GossipAPI_SubscribeServer interface {
+	Send(*Notification) error
+	grpc.ServerStream
+}
+
+type gossipAPISubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *gossipAPISubscribeServer) Send(m *Notification) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// GossipAPI_ServiceDesc is the grpc.ServiceDesc for GossipAPI service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var GossipAPI_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "p2p.GossipAPI",
+	HandlerType: (*GossipAPIServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Announce",
+			Handler:    _GossipAPI_Announce_Handler,
+		},
+		{
+			MethodName: "Validate",
+			Handler:    _GossipAPI_Validate_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _GossipAPI_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "gossip_api.proto",
+}
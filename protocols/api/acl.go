@@ -0,0 +1,227 @@
+package api
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/robfig/config"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+)
+
+// moduleSectionPrefix prefixes config.ini sections that declare module credentials, e.g. [module:chat]
+const moduleSectionPrefix = "module:"
+
+// Operation is an API operation that can be restricted per datatype
+type Operation int
+
+const (
+	OpAnnounce Operation = iota
+	OpNotify
+	OpValidate
+)
+
+func (op Operation) String() string {
+	switch op {
+	case OpAnnounce:
+		return "announce"
+	case OpNotify:
+		return "notify"
+	case OpValidate:
+		return "validate"
+	default:
+		return "unknown"
+	}
+}
+
+// anyDatatype allows an operation for every datatype when used in an allow-list
+const anyDatatype = "*"
+
+// Credential identifies a module and the datatypes it may use per operation
+type Credential struct {
+	Name    string
+	Token   string
+	UID     uint32
+	HasUID  bool
+	anyType map[Operation]bool
+	allowed map[Operation]map[enum.Datatype]bool
+}
+
+// NewCredential creates a credential without any permission
+func NewCredential(name string) *Credential {
+	return &Credential{
+		Name:    name,
+		anyType: make(map[Operation]bool),
+		allowed: make(map[Operation]map[enum.Datatype]bool),
+	}
+}
+
+// Allow permits op for the given datatypes, or for every datatype if none are given
+func (c *Credential) Allow(op Operation, datatypes ...enum.Datatype) {
+	if len(datatypes) == 0 {
+		c.anyType[op] = true
+		return
+	}
+	if c.allowed[op] == nil {
+		c.allowed[op] = make(map[enum.Datatype]bool)
+	}
+	for _, datatype := range datatypes {
+		c.allowed[op][datatype] = true
+	}
+}
+
+// Allows reports whether the credential may perform op on datatype
+func (c *Credential) Allows(op Operation, datatype enum.Datatype) bool {
+	return c.anyType[op] || c.allowed[op][datatype]
+}
+
+// credentialAddr identifies an authenticated module as validator, whichever session it validates on
+type credentialAddr string
+
+func (c credentialAddr) Network() string { return "module" }
+func (c credentialAddr) String() string  { return string(c) }
+
+// ValidatorIdentity identifies the module sending a validation: by its credential if it authenticated, otherwise by
+// the address of its session
+func ValidatorIdentity(credential *Credential, session net.Addr) net.Addr {
+	if credential != nil {
+		return credentialAddr(credential.Name)
+	}
+	return session
+}
+
+// AccessControl holds the module credentials configured in config.ini.
+// Without any configured credential the API stays open to every module.
+type AccessControl struct {
+	credentials []*Credential
+}
+
+// NewAccessControl creates an AccessControl from the given credentials
+func NewAccessControl(credentials ...*Credential) *AccessControl {
+	return &AccessControl{credentials: credentials}
+}
+
+// LoadAccessControl reads every [module:<name>] section of config.ini:
+//
+//	[module:chat]
+//	token = secret
+//	uid = 1000
+//	announce = 1,2
+//	notify = *
+//	validate = 1
+func LoadAccessControl(configFile *config.Config) (*AccessControl, error) {
+	accessControl := NewAccessControl()
+
+	for _, section := range configFile.Sections() {
+		if !strings.HasPrefix(section, moduleSectionPrefix) {
+			continue
+		}
+
+		credential := NewCredential(strings.TrimPrefix(section, moduleSectionPrefix))
+
+		if configFile.HasOption(section, "token") {
+			token, err := configFile.RawString(section, "token")
+			if err != nil {
+				return nil, err
+			}
+			credential.Token = strings.TrimSpace(token)
+		}
+
+		if configFile.HasOption(section, "uid") {
+			uid, err := configFile.Int(section, "uid")
+			if err != nil || uid < 0 {
+				return nil, fmt.Errorf("invalid uid for module %s", credential.Name)
+			}
+			credential.UID = uint32(uid)
+			credential.HasUID = true
+		}
+
+		if credential.Token == "" && !credential.HasUID {
+			return nil, fmt.Errorf("module %s needs a token or a uid", credential.Name)
+		}
+
+		for _, op := range []Operation{OpAnnounce, OpNotify, OpValidate} {
+			if !configFile.HasOption(section, op.String()) {
+				continue
+			}
+			value, err := configFile.RawString(section, op.String())
+			if err != nil {
+				return nil, err
+			}
+			if err := parseAllowList(credential, op, value); err != nil {
+				return nil, fmt.Errorf("invalid %s list for module %s: %w", op, credential.Name, err)
+			}
+		}
+
+		accessControl.credentials = append(accessControl.credentials, credential)
+	}
+
+	return accessControl, nil
+}
+
+func parseAllowList(credential *Credential, op Operation, value string) error {
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		switch entry {
+		case "":
+			continue
+		case anyDatatype:
+			credential.Allow(op)
+		default:
+			datatype, err := common.ParseUint16(entry)
+			if err != nil {
+				return err
+			}
+			credential.Allow(op, enum.Datatype(datatype))
+		}
+	}
+	return nil
+}
+
+// Enabled reports whether any credential is configured
+func (ac *AccessControl) Enabled() bool {
+	return ac != nil && len(ac.credentials) > 0
+}
+
+// AuthenticateToken returns the credential owning token
+func (ac *AccessControl) AuthenticateToken(token string) (*Credential, bool) {
+	if ac == nil || token == "" {
+		return nil, false
+	}
+	for _, credential := range ac.credentials {
+		if credential.Token != "" && subtle.ConstantTimeCompare([]byte(credential.Token), []byte(token)) == 1 {
+			return credential, true
+		}
+	}
+	return nil, false
+}
+
+// AuthenticateUID returns the credential bound to a unix uid
+func (ac *AccessControl) AuthenticateUID(uid uint32) (*Credential, bool) {
+	if ac == nil {
+		return nil, false
+	}
+	for _, credential := range ac.credentials {
+		if credential.HasUID && credential.UID == uid {
+			return credential, true
+		}
+	}
+	return nil, false
+}
+
+// Authorize checks op on datatype for a session. With access control disabled everything is allowed.
+func (ac *AccessControl) Authorize(credential *Credential, op Operation, datatype enum.Datatype) error {
+	if !ac.Enabled() {
+		return nil
+	}
+	if credential == nil {
+		return fmt.Errorf("module is not authenticated")
+	}
+	if !credential.Allows(op, datatype) {
+		return fmt.Errorf("module %s may not %s datatype %d", credential.Name, op, datatype)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/robfig/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// TestLoadAccessControl checks that module sections of config.ini become credentials with allow-lists.
+func TestLoadAccessControl(t *testing.T) {
+	configFile := config.NewDefault()
+	configFile.AddOption("gossip", "degree", "30")
+	configFile.AddOption("module:chat", "token", "secret")
+	configFile.AddOption("module:chat", "announce", "1, 2")
+	configFile.AddOption("module:chat", "notify", "*")
+	configFile.AddOption("module:local", "uid", "1000")
+	configFile.AddOption("module:local", "validate", "3")
+
+	accessControl, err := LoadAccessControl(configFile)
+	require.NoError(t, err)
+	assert.True(t, accessControl.Enabled())
+
+	chat, ok := accessControl.AuthenticateToken("secret")
+	require.True(t, ok)
+	assert.Equal(t, "chat", chat.Name)
+	assert.NoError(t, accessControl.Authorize(chat, OpAnnounce, enum.Datatype(2)))
+	assert.Error(t, accessControl.Authorize(chat, OpAnnounce, enum.Datatype(3)))
+	assert.NoError(t, accessControl.Authorize(chat, OpNotify, enum.Datatype(42)))
+	assert.Error(t, accessControl.Authorize(chat, OpValidate, enum.Datatype(1)))
+
+	_, ok = accessControl.AuthenticateToken("wrong")
+	assert.False(t, ok)
+
+	local, ok := accessControl.AuthenticateUID(1000)
+	require.True(t, ok)
+	assert.NoError(t, accessControl.Authorize(local, OpValidate, enum.Datatype(3)))
+	assert.Error(t, accessControl.Authorize(nil, OpValidate, enum.Datatype(3)))
+}
+
+// TestAccessControlDisabled checks that the API stays open without configured modules.
+func TestAccessControlDisabled(t *testing.T) {
+	accessControl, err := LoadAccessControl(config.NewDefault())
+	require.NoError(t, err)
+	assert.False(t, accessControl.Enabled())
+	assert.NoError(t, accessControl.Authorize(nil, OpAnnounce, enum.Datatype(1)))
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// AnnounceRequestResult is set in the reserved byte of GOSSIP ANNOUNCE by modules that want to learn whether the
+// node took the message. The node answers with GOSSIP ANNOUNCE RESULT: a status byte, AnnounceAccepted or
+// AnnounceFailed, followed by the reason of a failure.
+const AnnounceRequestResult uint8 = 1
+
+const (
+	AnnounceAccepted uint8 = 0
+	AnnounceFailed   uint8 = 1
+)
+
+// ErrAnnounceTimeout is reported when the P2P node did not take an announce within enum.AnnounceTimeout
+var ErrAnnounceTimeout = errors.New("node busy, announce timed out")
+
+// SubmitAnnounce hands msg to the P2P node. The node takes one announce at a time, so it waits until the node is done
+// with the earlier ones, at most enum.AnnounceTimeout, or until done is closed.
+func SubmitAnnounce(announceMsgChan chan<- enum.AnnounceMsg, msg enum.AnnounceMsg, done <-chan struct{}) error {
+	timer := time.NewTimer(enum.AnnounceTimeout)
+	defer timer.Stop()
+
+	select {
+	case announceMsgChan <- msg:
+		return nil
+	case <-timer.C:
+		return ErrAnnounceTimeout
+	case <-done:
+		return errors.New("announce cancelled")
+	}
+}
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+)
+
+// TestSubmitAnnounceWaitsForNode checks that an announce waits for a busy node instead of being dropped.
+func TestSubmitAnnounceWaitsForNode(t *testing.T) {
+	announceMsgChan := make(chan enum.AnnounceMsg)
+	received := make(chan enum.AnnounceMsg, 1)
+	go func() {
+		// Busy with the proof of work of an earlier announce
+		time.Sleep(100 * time.Millisecond)
+		received <- <-announceMsgChan
+	}()
+
+	assert.NoError(t, SubmitAnnounce(announceMsgChan, enum.AnnounceMsg{Data: "waiting"}, nil))
+	assert.Equal(t, "waiting", (<-received).Data)
+
+	done := make(chan struct{})
+	close(done)
+	assert.Error(t, SubmitAnnounce(announceMsgChan, enum.AnnounceMsg{}, done))
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/queue"
+)
+
+// queueIdentityPattern restricts module identities, which are used as file names
+var queueIdentityPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// queueAddr identifies a durable queue inside the DatatypeMapper, which is keyed by net.Addr
+type queueAddr string
+
+func (q queueAddr) Network() string   { return "queue" }
+func (q queueAddr) String() string    { return string(q) }
+func (q queueAddr) PseudoSubscriber() {}
+
+// DurableQueue collects the notifications of one module identity, also while the module is disconnected
+type DurableQueue struct {
+	Queue          *queue.Queue
+	addr           queueAddr
+	subscription   *common.Subscription
+	dispatcher     *common.Dispatcher
+	datatypeMapper *common.DatatypeMapper
+}
+
+// AddDatatype makes the queue collect notifications of datatype
+func (dq *DurableQueue) AddDatatype(datatype enum.Datatype) {
+	dq.datatypeMapper.Add(dq.addr, datatype)
+	dq.dispatcher.AddDatatype(dq.subscription, datatype)
+}
+
+// collect appends every notification of the subscription to the queue
+func (dq *DurableQueue) collect(logger *logging.Logger) {
+	for notificationMsg := range dq.subscription.C {
+		if _, err := dq.Queue.Append(notificationMsg); err != nil {
+			logger.ErrorF("Failed to queue notification %d for %s: %v", notificationMsg.MessageID, dq.addr, err)
+		}
+	}
+	_ = dq.Queue.Close()
+}
+
+// DurableQueues owns the durable queues of all module identities, stored as <identity>.queue in dir
+type DurableQueues struct {
+	mu             sync.Mutex
+	dir            string
+	maxEntries     int
+	maxAge         time.Duration
+	queues         map[string]*DurableQueue
+	collectors     sync.WaitGroup
+	dispatcher     *common.Dispatcher
+	datatypeMapper *common.DatatypeMapper
+	logger         *logging.Logger
+}
+
+func NewDurableQueues(dir string, maxEntries int, maxAge time.Duration, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper) *DurableQueues {
+	return &DurableQueues{
+		dir:            dir,
+		maxEntries:     maxEntries,
+		maxAge:         maxAge,
+		queues:         make(map[string]*DurableQueue),
+		dispatcher:     dispatcher,
+		datatypeMapper: datatypeMapper,
+		logger:         logging.NewCustomLogger(),
+	}
+}
+
+// Open returns the durable queue of identity, loading it from disk on first use
+func (d *DurableQueues) Open(identity string) (*DurableQueue, error) {
+	if !queueIdentityPattern.MatchString(identity) {
+		return nil, fmt.Errorf("invalid module identity %q", identity)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if dq, exists := d.queues[identity]; exists {
+		return dq, nil
+	}
+
+	if err := os.MkdirAll(d.dir, 0700); err != nil {
+		return nil, err
+	}
+	q, err := queue.Open(filepath.Join(d.dir, identity+".queue"), d.maxEntries, d.maxAge, enum.DurableQueueDedupWindow)
+	if err != nil {
+		return nil, err
+	}
+
+	dq := &DurableQueue{
+		Queue:          q,
+		addr:           queueAddr(identity),
+		subscription:   d.dispatcher.Subscribe(),
+		dispatcher:     d.dispatcher,
+		datatypeMapper: d.datatypeMapper,
+	}
+	d.queues[identity] = dq
+	d.collectors.Add(1)
+	go func() {
+		defer d.collectors.Done()
+		dq.collect(d.logger)
+	}()
+
+	return dq, nil
+}
+
+// Close stops collecting notifications and waits until every queue wrote the notifications it received and closed
+// its file. No queue may be opened afterwards.
+func (d *DurableQueues) Close() {
+	d.mu.Lock()
+	for _, dq := range d.queues {
+		d.dispatcher.Unsubscribe(dq.subscription)
+	}
+	d.mu.Unlock()
+
+	d.collectors.Wait()
+}
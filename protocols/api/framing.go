@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// headerSize is the size of the size and message type fields preceding every API message
+const headerSize = 4
+
+var (
+	ErrFrameTooShort = errors.New("message too short")
+	ErrFrameTooLarge = errors.New("message too large")
+)
+
+// ReadFrame reads exactly one API message from r and returns its type and body
+func ReadFrame(r io.Reader) (uint16, []byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint16(header[0:2])
+	messageType := binary.BigEndian.Uint16(header[2:4])
+
+	if size < headerSize {
+		return 0, nil, ErrFrameTooShort
+	}
+
+	body := make([]byte, size-headerSize)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return 0, nil, fmt.Errorf("failed to read message body: %w", err)
+	}
+
+	return messageType, body, nil
+}
+
+// WriteFrame writes body as one API message of the given type to w
+func WriteFrame(w io.Writer, messageType uint16, body []byte) error {
+	if len(body)+headerSize > math.MaxUint16 {
+		return ErrFrameTooLarge
+	}
+
+	frame := make([]byte, headerSize, headerSize+len(body))
+	binary.BigEndian.PutUint16(frame[0:2], uint16(len(body)+headerSize))
+	binary.BigEndian.PutUint16(frame[2:4], messageType)
+	frame = append(frame, body...)
+
+	_, err := w.Write(frame)
+	return err
+}
@@ -2,9 +2,11 @@ package api
 
 import (
 	"bytes"
-	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
@@ -12,160 +14,299 @@ import (
 )
 
 type Handler struct {
-	conn                net.Conn
-	logger              *logging.Logger
-	announceMsgChan     chan enum.AnnounceMsg
-	notificationMsgChan chan enum.NotificationMsg
-	datatypeMapper      *common.DatatypeMapper
+	conn            net.Conn
+	logger          *logging.Logger
+	announceMsgChan chan enum.AnnounceMsg
+	dispatcher      *common.Dispatcher
+	datatypeMapper  *common.DatatypeMapper
+	accessControl   *AccessControl
+	credential      *Credential
+	subscription    *common.Subscription
+	durableQueues   *DurableQueues
+	durableQueue    *DurableQueue
+	done            chan struct{}
+	writeMutex      sync.Mutex
 }
 
-func NewHandler(conn net.Conn, logger *logging.Logger, announceMsgChan chan enum.AnnounceMsg, notificationMsgChan chan enum.NotificationMsg, datatypeMapper *common.DatatypeMapper) *Handler {
-	return &Handler{conn: conn, logger: logger, announceMsgChan: announceMsgChan, notificationMsgChan: notificationMsgChan, datatypeMapper: datatypeMapper}
+func NewHandler(conn net.Conn, logger *logging.Logger, announceMsgChan chan enum.AnnounceMsg, dispatcher *common.Dispatcher, datatypeMapper *common.DatatypeMapper, accessControl *AccessControl, durableQueues *DurableQueues) *Handler {
+	return &Handler{conn: conn, logger: logger, announceMsgChan: announceMsgChan, dispatcher: dispatcher, datatypeMapper: datatypeMapper, accessControl: accessControl, durableQueues: durableQueues, done: make(chan struct{})}
 }
 
-// Handle handles incoming connections and dispatches messages based on type
+// AuthenticateUID binds the session to the credential configured for a unix uid, if any
+func (h *Handler) AuthenticateUID(uid uint32) {
+	if credential, ok := h.accessControl.AuthenticateUID(uid); ok {
+		h.credential = credential
+		h.logger.InfoF("Module %s authenticated by uid %d", credential.Name, uid)
+	}
+}
+
+// Handle reads messages from the connection until it is closed and dispatches them based on type
 func (h *Handler) Handle() {
 	h.logger.InfoF("Open connection with %s\n", h.conn.RemoteAddr())
+	defer h.close()
 
-	messageBuffer := make([]byte, 1024)
-	readSize, err := h.conn.Read(messageBuffer)
-
-	h.logger.DebugF("Reading from %s\n", h.conn.RemoteAddr())
+	for {
+		messageType, body, err := ReadFrame(h.conn)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				h.logger.Info("Connection closed by module")
+			} else if errors.Is(err, ErrFrameTooShort) {
+				h.sendResponse("Message too short.\n")
+			} else {
+				h.logger.ErrorF("Error reading from connection: %v\n", err)
+			}
+			return
+		}
 
-	if err != nil {
-		h.logger.ErrorF("Error reading from connection: %v\n", err)
-		return
-	}
+		h.logger.DebugF("Read message type %d from %s\n", messageType, h.conn.RemoteAddr())
 
-	if readSize == 0 {
-		h.sendResponse("Empty message.\n")
-		return
-	} else if readSize < 4 {
-		h.sendResponse("Message too short.\n")
-		return
-	}
+		if messageType != enum.GossipAuth && h.accessControl.Enabled() && h.credential == nil {
+			h.logger.ErrorF("Rejecting message type %d from unauthenticated module", messageType)
+			return
+		}
 
-	reader := bytes.NewReader(messageBuffer)
+		reader := bytes.NewReader(body)
 
-	var size uint16
-	var messageType uint16
+		// Handle message based on type
+		switch messageType {
+		case enum.GossipAuth:
+			err = h.authHandler(body)
+			if err != nil {
+				err = fmt.Errorf("error handling AUTH message: %w", err)
+			}
+		case enum.GossipResume:
+			err = h.resumeHandler(reader)
+			if err != nil {
+				err = fmt.Errorf("error handling RESUME message: %w", err)
+			}
+		case enum.GossipNotify:
+			err = h.notifyHandler(reader)
+			if err != nil {
+				err = fmt.Errorf("error handling NOTIFY message: %w", err)
+			}
+			h.datatypeMapper.Print()
+		case enum.GossipAnnounce:
+			err = h.announceHandler(reader)
+			if err != nil {
+				err = fmt.Errorf("error handling ANNOUNCE message: %w", err)
+			}
+		case enum.GossipValidation:
+			err = h.validationHandler(reader)
+			if err != nil {
+				err = fmt.Errorf("error handling VALIDATION message: %w", err)
+			}
+		default:
+			err = fmt.Errorf("unknown message type %d", messageType)
+		}
 
-	// Read the size
-	if err = binary.Read(reader, binary.BigEndian, &size); err != nil {
-		h.logger.ErrorF("Error reading size: %v\n", err)
-		return
+		if err != nil {
+			h.logger.ErrorF("%v", err)
+			return
+		}
 	}
+}
+
+// close ends the session and releases its subscription
+func (h *Handler) close() {
+	close(h.done)
 
-	if int(size) != readSize {
-		h.sendResponse("Wrong message size.\n")
-		return
+	if h.subscription != nil {
+		h.dispatcher.Unsubscribe(h.subscription)
+		h.datatypeMapper.Remove(h.conn.RemoteAddr())
 	}
 
-	// Read the message type
-	if err = binary.Read(reader, binary.BigEndian, &messageType); err != nil {
-		h.logger.ErrorF("Error reading message type: %v\n", err)
-		return
+	if err := h.conn.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		h.logger.ErrorF("Error closing connection: %v", err)
 	}
+	h.logger.Info("Connection closed")
+}
 
-	// Handle message based on type
-	switch messageType {
-	case enum.GossipNotify:
-		if err = h.notifyHandler(reader); err != nil {
-			h.logger.ErrorF("Error handling NOTIFY message: %v\n", err)
-		}
-		h.datatypeMapper.Print()
-	case enum.GossipAnnounce:
-		if err = h.announceHandler(reader); err != nil {
-			h.logger.ErrorF("Error handling ANNOUNCE message: %v\n", err)
-		}
-	case enum.GossipValidation:
-		if err = h.validationHandler(reader); err != nil {
-			h.logger.ErrorF("Error handling VALIDATION message: %v\n", err)
-		}
+// authHandler authenticates the session with the pre-shared token carried in the AUTH message
+func (h *Handler) authHandler(body []byte) error {
+	credential, ok := h.accessControl.AuthenticateToken(string(body))
+	if !ok {
+		return fmt.Errorf("invalid token")
 	}
+
+	h.credential = credential
+	h.logger.InfoF("Module %s authenticated by token", credential.Name)
+
+	return nil
 }
 
-// announceHandler handles AnnounceMsg
+// announceHandler handles AnnounceMsg. Modules that asked for the result learn whether the node took the message and
+// keep their session if it did not; the sessions of others are closed.
 func (h *Handler) announceHandler(reader *bytes.Reader) error {
-	defer func() {
-		if err := h.conn.Close(); err != nil {
-			h.logger.ErrorF("Error closing connection: %v", err)
-		}
-		h.logger.Info("Connection closed")
-	}()
-
 	var msg enum.AnnounceMsg
 	if err := h.unmarshallAnnounce(reader, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal announce message: %w", err)
 	}
 
-	select {
-	// Successfully sent the message
-	case h.announceMsgChan <- msg:
-	default:
-		return fmt.Errorf("no receiver available")
+	err := h.accessControl.Authorize(h.credential, OpAnnounce, msg.DataType)
+	if err == nil {
+		err = SubmitAnnounce(h.announceMsgChan, msg, nil)
+	}
+	if msg.Reserved&AnnounceRequestResult == 0 {
+		return err
 	}
 
-	return nil
+	if err != nil {
+		h.logger.ErrorF("Announce failed: %v", err)
+	}
+	h.writeMutex.Lock()
+	defer h.writeMutex.Unlock()
+	return sendAnnounceResult(h.conn, err)
 }
 
-// notifyHandler handles NotifyMsg
+// notifyHandler handles NotifyMsg. Notifications are written by a separate goroutine,
+// so the module can keep sending messages, e.g. GOSSIP VALIDATION, on the same connection.
 func (h *Handler) notifyHandler(reader *bytes.Reader) error {
-	defer func() {
-		if err := h.conn.Close(); err != nil {
-			h.logger.ErrorF("Error closing connection: %v", err)
-		}
-		h.logger.Info("Connection closed")
-	}()
-
 	var msg enum.NotifyMsg
 	if err := h.unmarshallNotify(reader, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal notify message: %w", err)
 	}
 
+	if err := h.accessControl.Authorize(h.credential, OpNotify, msg.DataType); err != nil {
+		return err
+	}
+
+	// Durable sessions receive their notifications through the queue of the module identity
+	if h.durableQueue != nil {
+		h.durableQueue.AddDatatype(msg.DataType)
+		return nil
+	}
+
 	h.datatypeMapper.Add(h.conn.RemoteAddr(), msg.DataType)
+	h.datatypeMapper.SetValidator(h.conn.RemoteAddr(), ValidatorIdentity(h.credential, h.conn.RemoteAddr()))
 
+	if h.subscription != nil {
+		h.dispatcher.AddDatatype(h.subscription, msg.DataType)
+		return nil
+	}
+
+	h.subscription = h.dispatcher.Subscribe(msg.DataType)
+	go h.deliverNotifications(h.subscription)
+
+	return nil
+}
+
+// deliverNotifications writes every notification of the subscription to the module
+func (h *Handler) deliverNotifications(subscription *common.Subscription) {
+	for notificationMsg := range subscription.C {
+		h.logger.InfoF("Got notification message type %d\n", notificationMsg.DataType)
+
+		h.writeMutex.Lock()
+		err := sendNotificationMessage(h.conn, notificationMsg, h.logger)
+		h.writeMutex.Unlock()
+
+		if errors.Is(err, ErrFrameTooLarge) {
+			// The payload does not fit into an API frame, the session itself is still intact
+			continue
+		}
+		if err != nil {
+			// Closing the connection also ends the read loop in Handle
+			_ = h.conn.Close()
+			return
+		}
+	}
+}
+
+// resumeHandler switches the session to the durable queue of the module identity. With a resume token,
+// every queued notification after the token is delivered again before new ones.
+func (h *Handler) resumeHandler(reader *bytes.Reader) error {
+	if h.durableQueues == nil {
+		return fmt.Errorf("durable queues are disabled")
+	}
+	if h.durableQueue != nil || h.subscription != nil {
+		return fmt.Errorf("RESUME must be sent once, before any NOTIFY")
+	}
+
+	var msg enum.ResumeMsg
+	if err := h.unmarshallResume(reader, &msg); err != nil {
+		return fmt.Errorf("failed to unmarshal resume message: %w", err)
+	}
+
+	// Authenticated modules always use the queue of their credential
+	identity := msg.Identity
+	if h.credential != nil {
+		identity = h.credential.Name
+	}
+
+	durableQueue, err := h.durableQueues.Open(identity)
+	if err != nil {
+		return err
+	}
+
+	cursor := durableQueue.Queue.Last()
+	if msg.Flags&1 == 1 {
+		cursor = durableQueue.Queue.Resume(msg.Sequence)
+	}
+
+	h.durableQueue = durableQueue
+	h.logger.InfoF("Module %s resumed after sequence %d", identity, cursor)
+	go h.deliverQueued(durableQueue, cursor)
+
+	return nil
+}
+
+// deliverQueued writes every queued notification after cursor to the module, in order, until the session ends
+func (h *Handler) deliverQueued(durableQueue *DurableQueue, cursor uint64) {
 	for {
-		// Wait for a message to be received on the channel
-		notificationMsg, ok := <-h.notificationMsgChan
-		if !ok {
-			// If the channel is closed, exit the loop
-			return fmt.Errorf("notification channel closed")
-		} else {
-			h.logger.InfoF("Got notification message type %d. Requested type %d\n", notificationMsg.DataType, msg.DataType)
-			if notificationMsg.DataType == msg.DataType {
-				sendNotificationMessage(h.conn, notificationMsg, h.logger)
+		// Fetch the channel first, so that no entry appended in between is missed
+		changed := durableQueue.Queue.Changed()
+
+		for _, entry := range durableQueue.Queue.After(cursor) {
+			notificationMsg := enum.NotificationMsg{MessageID: entry.MessageID, DataType: entry.Datatype, Data: entry.Data}
+
+			h.writeMutex.Lock()
+			err := sendQueuedNotificationMessage(h.conn, entry.Sequence, notificationMsg, h.logger)
+			h.writeMutex.Unlock()
+
+			if err != nil && !errors.Is(err, ErrFrameTooLarge) {
+				_ = h.conn.Close()
+				return
 			}
+			cursor = entry.Sequence
+		}
+
+		select {
+		case <-changed:
+		case <-h.done:
+			return
 		}
 	}
 }
 
 // validationHandler handles Validation
 func (h *Handler) validationHandler(reader *bytes.Reader) error {
-	defer func() {
-		if err := h.conn.Close(); err != nil {
-			h.logger.ErrorF("Error closing connection: %v", err)
-		}
-		h.logger.Info("Connection closed")
-	}()
-
 	var msg enum.ValidationMsg
 	if err := h.unmarshallValidation(reader, &msg); err != nil {
 		return fmt.Errorf("failed to unmarshal notify message: %w", err)
 	}
 
-	if msg.Reserved&1 == 1 {
-		h.datatypeMapper.AddInvalidMsgID(msg.MessageID)
+	if h.accessControl.Enabled() {
+		datatype, known := h.datatypeMapper.GetMessageDatatype(msg.MessageID)
+		if !known {
+			return fmt.Errorf("unknown message ID %d", msg.MessageID)
+		}
+		if err := h.accessControl.Authorize(h.credential, OpValidate, datatype); err != nil {
+			return err
+		}
 	}
 
+	h.datatypeMapper.AddValidation(ValidatorIdentity(h.credential, h.conn.RemoteAddr()), msg.MessageID, msg.Reserved&1 == 0)
+
 	return nil
 }
 
 func (h *Handler) sendResponse(s string) {
+	h.writeMutex.Lock()
+	defer h.writeMutex.Unlock()
+
 	response := []byte(s)
 	write, err := h.conn.Write(response)
 	if err != nil {
-		h.logger.ErrorF("Error writing response:", err)
+		h.logger.ErrorF("Error writing response: %v", err)
 	}
 	h.logger.ErrorF("Sent %d bytes. Message: %s", write, s)
 }
@@ -10,24 +10,24 @@ import (
 // unmarshallAnnounce parses the JSON data manually, and populates the AnnounceMsg struct
 func (h *Handler) unmarshallAnnounce(readBuffer *bytes.Reader, msg *enum.AnnounceMsg) error {
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.TTL); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Reserved); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.DataType); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	msgBuf := make([]byte, readBuffer.Len())
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msgBuf); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	} else {
 		msg.Data = string(msgBuf)
@@ -41,12 +41,12 @@ func (h *Handler) unmarshallAnnounce(readBuffer *bytes.Reader, msg *enum.Announc
 // unmarshallNotify parses the JSON data manually, and populates the NotifyMsg struct
 func (h *Handler) unmarshallNotify(readBuffer *bytes.Reader, msg *enum.NotifyMsg) error {
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Reserved); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.DataType); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
@@ -55,22 +55,49 @@ func (h *Handler) unmarshallNotify(readBuffer *bytes.Reader, msg *enum.NotifyMsg
 	return nil
 }
 
+// unmarshallResume parses the RESUME message and populates the ResumeMsg struct
+func (h *Handler) unmarshallResume(readBuffer *bytes.Reader, msg *enum.ResumeMsg) error {
+	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Flags); err != nil {
+		h.logger.ErrorF("Error reading message type: %v", err)
+		return err
+	}
+
+	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Reserved); err != nil {
+		h.logger.ErrorF("Error reading message type: %v", err)
+		return err
+	}
+
+	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Sequence); err != nil {
+		h.logger.ErrorF("Error reading message type: %v", err)
+		return err
+	}
+
+	// The identity takes the rest of the message
+	identity := make([]byte, readBuffer.Len())
+	_, _ = readBuffer.Read(identity)
+	msg.Identity = string(identity)
+
+	h.logger.InfoF("Received GOSSIP_RESUME message %v", *msg)
+
+	return nil
+}
+
 // marshallNotification parses the JSON data manually, and populates the NotificationMsg struct
 func (h *Handler) unmarshallNotification(readBuffer *bytes.Reader, msg *enum.NotificationMsg) error {
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.MessageID); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.DataType); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	msgBuf := make([]byte, readBuffer.Len())
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msgBuf); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	} else {
 		msg.Data = string(msgBuf)
@@ -84,12 +111,12 @@ func (h *Handler) unmarshallNotification(readBuffer *bytes.Reader, msg *enum.Not
 // unmarshallValidation parses the JSON data manually, and populates the ValidationMsg struct
 func (h *Handler) unmarshallValidation(readBuffer *bytes.Reader, msg *enum.ValidationMsg) error {
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.MessageID); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
 	if err := binary.Read(readBuffer, binary.BigEndian, &msg.Reserved); err != nil {
-		h.logger.ErrorF("Error reading message type:", err)
+		h.logger.ErrorF("Error reading message type: %v", err)
 		return err
 	}
 
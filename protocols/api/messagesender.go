@@ -3,28 +3,45 @@ package api
 import (
 	"bytes"
 	"encoding/binary"
-	"encoding/json"
 	"net"
 
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
 	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/libraries/logging"
 )
 
-func sendNotificationMessage(conn net.Conn, msg enum.NotificationMsg, logger *logging.Logger) {
-	msgBytes, err := json.Marshal(msg)
-	var writeBuffer bytes.Buffer
+func sendNotificationMessage(conn net.Conn, msg enum.NotificationMsg, logger *logging.Logger) error {
+	return writeNotification(conn, enum.GossipNotification, nil, msg, logger)
+}
+
+// sendQueuedNotificationMessage sends a notification of a durable queue with its sequence number, the module
+// resumes after it
+func sendQueuedNotificationMessage(conn net.Conn, sequence uint64, msg enum.NotificationMsg, logger *logging.Logger) error {
+	return writeNotification(conn, enum.GossipQueuedNotification, binary.BigEndian.AppendUint64(nil, sequence), msg, logger)
+}
+
+func writeNotification(conn net.Conn, messageType uint16, prefix []byte, msg enum.NotificationMsg, logger *logging.Logger) error {
+	var body bytes.Buffer
 
-	_ = binary.Write(&writeBuffer, binary.BigEndian, uint16(len(msgBytes)+4))
-	_ = binary.Write(&writeBuffer, binary.BigEndian, enum.GossipNotification)
-	_ = binary.Write(&writeBuffer, binary.BigEndian, msg.MessageID)
-	_ = binary.Write(&writeBuffer, binary.BigEndian, msg.DataType)
-	_ = binary.Write(&writeBuffer, binary.BigEndian, []byte(msg.Data))
+	body.Write(prefix)
+	_ = binary.Write(&body, binary.BigEndian, msg.MessageID)
+	_ = binary.Write(&body, binary.BigEndian, msg.DataType)
+	_ = binary.Write(&body, binary.BigEndian, []byte(msg.Data))
 
-	_, err = conn.Write(writeBuffer.Bytes())
+	err := WriteFrame(conn, messageType, body.Bytes())
 	if err != nil {
 		logger.InfoF("Failed to notification message: %v\n", err)
-		return
+		return err
 	} else {
 		logger.InfoF("Sent notification message to %s with data %s.\n", conn.RemoteAddr().String(), msg.Data)
 	}
+	return nil
+}
+
+// sendAnnounceResult tells a module whether its announce was taken, announceErr is nil if it was
+func sendAnnounceResult(conn net.Conn, announceErr error) error {
+	body := []byte{AnnounceAccepted}
+	if announceErr != nil {
+		body = append([]byte{AnnounceFailed}, announceErr.Error()...)
+	}
+	return WriteFrame(conn, enum.GossipAnnounceResult, body)
 }
@@ -0,0 +1,365 @@
+package api
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robfig/config"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/enum"
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+)
+
+// validationSectionPrefix prefixes config.ini sections that declare a validation policy, e.g. [validation:1]
+const validationSectionPrefix = "validation:"
+
+// PolicyMode decides how validations of subscribed modules are turned into a forwarding decision
+type PolicyMode int
+
+const (
+	// PolicyNone forwards every message right away, validations only suppress later notifications
+	PolicyNone PolicyMode = iota
+	// PolicyTimeout forwards a message once the timeout passed without any module reporting it invalid
+	PolicyTimeout
+	// PolicyAll forwards a message once every subscribed module reported it valid
+	PolicyAll
+	// PolicyQuorum forwards a message once a quorum of subscribed modules reported it valid
+	PolicyQuorum
+)
+
+func (mode PolicyMode) String() string {
+	switch mode {
+	case PolicyNone:
+		return "none"
+	case PolicyTimeout:
+		return "timeout"
+	case PolicyAll:
+		return "all"
+	case PolicyQuorum:
+		return "quorum"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePolicyMode parses the policy option of a [validation:<datatype>] section
+func ParsePolicyMode(value string) (PolicyMode, error) {
+	for _, mode := range []PolicyMode{PolicyNone, PolicyTimeout, PolicyAll, PolicyQuorum} {
+		if strings.EqualFold(strings.TrimSpace(value), mode.String()) {
+			return mode, nil
+		}
+	}
+	return PolicyNone, fmt.Errorf("unknown validation policy %q", value)
+}
+
+// PayloadValidator checks a payload before it is handed to any module
+type PayloadValidator interface {
+	Validate(payload []byte) error
+}
+
+// MaxSizeValidator rejects payloads larger than Limit bytes
+type MaxSizeValidator struct {
+	Limit int
+}
+
+func (v MaxSizeValidator) Validate(payload []byte) error {
+	if len(payload) > v.Limit {
+		return fmt.Errorf("payload of %d bytes exceeds %d bytes", len(payload), v.Limit)
+	}
+	return nil
+}
+
+// RegexValidator rejects payloads not matching Pattern
+type RegexValidator struct {
+	Pattern *regexp.Regexp
+}
+
+func (v RegexValidator) Validate(payload []byte) error {
+	if !v.Pattern.Match(payload) {
+		return fmt.Errorf("payload does not match %s", v.Pattern)
+	}
+	return nil
+}
+
+// ValidationPolicy is the validation policy of one datatype
+type ValidationPolicy struct {
+	Mode       PolicyMode
+	Timeout    time.Duration
+	Quorum     int
+	Validators []PayloadValidator
+}
+
+// pendingValidation collects the validations of one message until a decision is reached.
+// validators holds the modules that validated it already, each of them is counted once.
+// With awaitedOnly set, only the validations of awaited validators count.
+type pendingValidation struct {
+	policy      *ValidationPolicy
+	datatype    enum.Datatype
+	awaitedOnly bool
+	expected    int
+	validators  map[string]struct{}
+	valid       int
+	invalid     int
+	decided     bool
+	accepted    bool
+	done        chan struct{}
+}
+
+// record counts the validation of validator and reports whether a decision was reached
+func (p *pendingValidation) record(validator net.Addr, valid bool) bool {
+	if p.decided {
+		return false
+	}
+	key := common.AddrKey(validator)
+	if _, repeated := p.validators[key]; repeated {
+		return false
+	}
+	p.validators[key] = struct{}{}
+
+	if valid {
+		p.valid++
+	} else {
+		p.invalid++
+	}
+
+	switch p.policy.Mode {
+	case PolicyTimeout, PolicyAll:
+		if p.invalid > 0 {
+			p.decided, p.accepted = true, false
+		} else if p.valid >= p.expected {
+			p.decided, p.accepted = true, true
+		}
+	case PolicyQuorum:
+		if p.valid >= p.policy.Quorum {
+			p.decided, p.accepted = true, true
+		} else if p.expected-p.invalid < p.policy.Quorum {
+			p.decided, p.accepted = true, false
+		}
+	}
+	return p.decided
+}
+
+// PolicyEngine decides per datatype whether a received message is forwarded to other peers.
+// It learns about module validations through the DatatypeMapper validation listener.
+// awaitTimeout bounds the wait for awaited validators of datatypes without a policy.
+type PolicyEngine struct {
+	mu             sync.Mutex
+	policies       map[enum.Datatype]*ValidationPolicy
+	pending        map[uint16]*pendingValidation
+	datatypeMapper *common.DatatypeMapper
+	awaitTimeout   time.Duration
+}
+
+// NewPolicyEngine creates a PolicyEngine without any policy and registers it with datatypeMapper
+func NewPolicyEngine(datatypeMapper *common.DatatypeMapper) *PolicyEngine {
+	engine := &PolicyEngine{
+		policies:       make(map[enum.Datatype]*ValidationPolicy),
+		pending:        make(map[uint16]*pendingValidation),
+		datatypeMapper: datatypeMapper,
+		awaitTimeout:   enum.WebhookTimeout,
+	}
+	datatypeMapper.SetValidationListener(engine.Record)
+	return engine
+}
+
+// SetPolicy sets the validation policy of datatype
+func (e *PolicyEngine) SetPolicy(datatype enum.Datatype, policy *ValidationPolicy) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.policies[datatype] = policy
+}
+
+// LoadPolicyEngine reads every [validation:<datatype>] section of config.ini:
+//
+//	[validation:1]
+//	policy = quorum
+//	timeout = 2s
+//	quorum = 2
+//	max_size = 1024
+//	pattern = ^[a-z ]+$
+//	schema = configs/chat.schema.json
+func LoadPolicyEngine(configFile *config.Config, datatypeMapper *common.DatatypeMapper) (*PolicyEngine, error) {
+	engine := NewPolicyEngine(datatypeMapper)
+
+	for _, section := range configFile.Sections() {
+		if !strings.HasPrefix(section, validationSectionPrefix) {
+			continue
+		}
+
+		datatype, err := common.ParseUint16(strings.TrimPrefix(section, validationSectionPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid datatype in section %s: %w", section, err)
+		}
+
+		policy, err := loadPolicy(configFile, section)
+		if err != nil {
+			return nil, fmt.Errorf("invalid validation policy for datatype %d: %w", datatype, err)
+		}
+		engine.SetPolicy(enum.Datatype(datatype), policy)
+	}
+
+	return engine, nil
+}
+
+func loadPolicy(configFile *config.Config, section string) (*ValidationPolicy, error) {
+	policy := &ValidationPolicy{Mode: PolicyNone, Timeout: enum.ValidationTimeout}
+
+	if configFile.HasOption(section, "policy") {
+		value, err := configFile.RawString(section, "policy")
+		if err != nil {
+			return nil, err
+		}
+		if policy.Mode, err = ParsePolicyMode(value); err != nil {
+			return nil, err
+		}
+	}
+
+	if configFile.HasOption(section, "timeout") {
+		value, err := configFile.RawString(section, "timeout")
+		if err != nil {
+			return nil, err
+		}
+		if policy.Timeout, err = time.ParseDuration(strings.TrimSpace(value)); err != nil || policy.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid timeout %q", value)
+		}
+	}
+
+	if policy.Mode == PolicyQuorum {
+		quorum, err := configFile.Int(section, "quorum")
+		if err != nil || quorum < 1 {
+			return nil, fmt.Errorf("quorum policy needs a positive quorum")
+		}
+		policy.Quorum = quorum
+	}
+
+	if configFile.HasOption(section, "max_size") {
+		limit, err := configFile.Int(section, "max_size")
+		if err != nil || limit < 0 {
+			return nil, fmt.Errorf("invalid max_size")
+		}
+		policy.Validators = append(policy.Validators, MaxSizeValidator{Limit: limit})
+	}
+
+	if configFile.HasOption(section, "pattern") {
+		value, err := configFile.RawString(section, "pattern")
+		if err != nil {
+			return nil, err
+		}
+		pattern, err := regexp.Compile(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern: %w", err)
+		}
+		policy.Validators = append(policy.Validators, RegexValidator{Pattern: pattern})
+	}
+
+	if configFile.HasOption(section, "schema") {
+		path, err := configFile.RawString(section, "schema")
+		if err != nil {
+			return nil, err
+		}
+		raw, err := os.ReadFile(strings.TrimSpace(path))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+		schema, err := ParseSchema(raw)
+		if err != nil {
+			return nil, err
+		}
+		policy.Validators = append(policy.Validators, SchemaValidator{Schema: schema})
+	}
+
+	return policy, nil
+}
+
+// Decide runs the policy of datatype for a received message and reports whether it should be forwarded.
+// notify hands the message to the subscribed modules; it is called once the message passed the
+// payload validators, after the engine started to collect validations for it.
+func (e *PolicyEngine) Decide(msgID uint16, datatype enum.Datatype, payload []byte, notify func()) bool {
+	e.mu.Lock()
+	policy := e.policies[datatype]
+	e.mu.Unlock()
+
+	if policy != nil {
+		for _, validator := range policy.Validators {
+			if err := validator.Validate(payload); err != nil {
+				e.datatypeMapper.AddInvalidMsgID(msgID)
+				return false
+			}
+		}
+	}
+
+	// Without a policy only awaited validators like webhooks are waited for. A message one of them reports invalid is
+	// not forwarded, one they stay silent about is forwarded after awaitTimeout. Durable queues do not validate.
+	awaitedOnly := policy == nil || policy.Mode == PolicyNone
+	var expected int
+	if awaitedOnly {
+		expected = e.datatypeMapper.CountAwaited(datatype)
+		policy = &ValidationPolicy{Mode: PolicyTimeout, Timeout: e.awaitTimeout}
+	} else {
+		expected = e.datatypeMapper.CountValidators(datatype)
+	}
+	// Without subscribed validators there is nobody to wait for, the payload validators decide alone
+	if expected == 0 {
+		notify()
+		return true
+	}
+
+	pending := &pendingValidation{
+		policy:      policy,
+		datatype:    datatype,
+		awaitedOnly: awaitedOnly,
+		expected:    expected,
+		validators:  make(map[string]struct{}),
+		done:        make(chan struct{}),
+	}
+	e.mu.Lock()
+	e.pending[msgID] = pending
+	e.mu.Unlock()
+
+	defer func() {
+		e.mu.Lock()
+		delete(e.pending, msgID)
+		e.mu.Unlock()
+	}()
+
+	notify()
+
+	select {
+	case <-pending.done:
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		return pending.accepted
+	case <-time.After(policy.Timeout):
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if pending.decided {
+			return pending.accepted
+		}
+		// Silence only counts as approval for the timeout policy
+		return policy.Mode == PolicyTimeout && pending.invalid == 0
+	}
+}
+
+// Record counts the validation of a module for a message that is waiting for a decision. Only modules and webhooks
+// subscribed to the datatype of the message count, each of them once; validators are identified the same way
+// CountValidators does.
+func (e *PolicyEngine) Record(validator net.Addr, msgID uint16, valid bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	pending, exists := e.pending[msgID]
+	if !exists || !e.datatypeMapper.IsValidator(validator, pending.datatype) {
+		return
+	}
+	if _, awaited := validator.(common.AwaitedValidator); pending.awaitedOnly && !awaited {
+		return
+	}
+	if pending.record(validator, valid) {
+		close(pending.done)
+	}
+}
@@ -0,0 +1,182 @@
+package api
+
+import (
+	"net"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"gitlab.lrz.de/netintum/teaching/p2psec_projects_2024/Gossip-7/pkg/common"
+)
+
+func subscriber(i int) net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1000 + i}
+}
+
+func subscribedMapper(subscribers int) *common.DatatypeMapper {
+	datatypeMapper := common.NewMap()
+	for i := 0; i < subscribers; i++ {
+		datatypeMapper.Add(subscriber(i), 1)
+	}
+	return datatypeMapper
+}
+
+// TestPolicyEngineDecide checks the forwarding decision of every policy mode.
+func TestPolicyEngineDecide(t *testing.T) {
+	tests := []struct {
+		name        string
+		policy      *ValidationPolicy
+		validations []bool
+		forward     bool
+	}{
+		{"no policy", nil, nil, true},
+		{"timeout without answer", &ValidationPolicy{Mode: PolicyTimeout, Timeout: 50 * time.Millisecond}, nil, true},
+		{"timeout with invalid", &ValidationPolicy{Mode: PolicyTimeout, Timeout: time.Second}, []bool{false}, false},
+		{"all valid", &ValidationPolicy{Mode: PolicyAll, Timeout: time.Second}, []bool{true, true, true}, true},
+		{"all missing one", &ValidationPolicy{Mode: PolicyAll, Timeout: 50 * time.Millisecond}, []bool{true, true}, false},
+		{"quorum reached", &ValidationPolicy{Mode: PolicyQuorum, Timeout: time.Second, Quorum: 2}, []bool{false, true, true}, true},
+		{"quorum unreachable", &ValidationPolicy{Mode: PolicyQuorum, Timeout: time.Second, Quorum: 2}, []bool{false, false}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			datatypeMapper := subscribedMapper(3)
+			engine := NewPolicyEngine(datatypeMapper)
+			if test.policy != nil {
+				engine.SetPolicy(1, test.policy)
+			}
+
+			notified := false
+			forward := engine.Decide(7, 1, []byte("payload"), func() {
+				notified = true
+				go func() {
+					for i, valid := range test.validations {
+						datatypeMapper.AddValidation(subscriber(i), 7, valid)
+					}
+				}()
+			})
+
+			assert.True(t, notified)
+			assert.Equal(t, test.forward, forward)
+		})
+	}
+}
+
+// TestPolicyEngineCountsModulesOnce checks that repeated validations of one module and validations of pseudo
+// subscribers do not stand in for the other modules.
+func TestPolicyEngineCountsModulesOnce(t *testing.T) {
+	datatypeMapper := subscribedMapper(2)
+	datatypeMapper.Add(queueAddr("archive"), 1)
+	engine := NewPolicyEngine(datatypeMapper)
+	engine.SetPolicy(1, &ValidationPolicy{Mode: PolicyAll, Timeout: 50 * time.Millisecond})
+
+	forward := engine.Decide(7, 1, []byte("payload"), func() {
+		go func() {
+			datatypeMapper.AddValidation(subscriber(0), 7, true)
+			datatypeMapper.AddValidation(subscriber(0), 7, true)
+			datatypeMapper.AddValidation(queueAddr("archive"), 7, true)
+		}()
+	})
+	assert.False(t, forward)
+
+	// The durable queue is not waited for
+	forward = engine.Decide(8, 1, []byte("payload"), func() {
+		go func() {
+			datatypeMapper.AddValidation(subscriber(0), 8, true)
+			datatypeMapper.AddValidation(subscriber(1), 8, true)
+		}()
+	})
+	assert.True(t, forward)
+}
+
+// TestPolicyEngineIdentifiesModules checks that a module subscribed on several sessions counts once and that only
+// modules subscribed to the datatype count.
+func TestPolicyEngineIdentifiesModules(t *testing.T) {
+	datatypeMapper := subscribedMapper(1)
+	// The chat module subscribed on two sessions
+	for i := 1; i <= 2; i++ {
+		datatypeMapper.Add(subscriber(i), 1)
+		datatypeMapper.SetValidator(subscriber(i), credentialAddr("chat"))
+	}
+	datatypeMapper.Add(subscriber(3), 2)
+	engine := NewPolicyEngine(datatypeMapper)
+
+	engine.SetPolicy(1, &ValidationPolicy{Mode: PolicyAll, Timeout: time.Second})
+	forward := engine.Decide(7, 1, []byte("payload"), func() {
+		go func() {
+			datatypeMapper.AddValidation(credentialAddr("chat"), 7, true)
+			datatypeMapper.AddValidation(subscriber(0), 7, true)
+		}()
+	})
+	assert.True(t, forward)
+
+	// Neither a module of another datatype nor an unknown one helps reaching the quorum
+	engine.SetPolicy(1, &ValidationPolicy{Mode: PolicyQuorum, Timeout: 50 * time.Millisecond, Quorum: 2})
+	forward = engine.Decide(8, 1, []byte("payload"), func() {
+		go func() {
+			datatypeMapper.AddValidation(credentialAddr("chat"), 8, true)
+			datatypeMapper.AddValidation(subscriber(3), 8, true)
+			datatypeMapper.AddValidation(subscriber(9), 8, true)
+			datatypeMapper.AddValidation(credentialAddr("other"), 8, true)
+		}()
+	})
+	assert.False(t, forward)
+}
+
+// awaitedAddr stands in for a webhook
+type awaitedAddr string
+
+func (a awaitedAddr) Network() string   { return "awaited" }
+func (a awaitedAddr) String() string    { return string(a) }
+func (a awaitedAddr) AwaitedValidator() {}
+
+// TestPolicyEngineAwaitsWebhooks checks that awaited validators are waited for without a policy and count like modules
+// with one.
+func TestPolicyEngineAwaitsWebhooks(t *testing.T) {
+	datatypeMapper := subscribedMapper(1)
+	datatypeMapper.Add(awaitedAddr("archive"), 1)
+	engine := NewPolicyEngine(datatypeMapper)
+	engine.awaitTimeout = 50 * time.Millisecond
+
+	decide := func(msgID uint16, validations map[net.Addr]bool) bool {
+		return engine.Decide(msgID, 1, []byte("payload"), func() {
+			go func() {
+				for validator, valid := range validations {
+					datatypeMapper.AddValidation(validator, msgID, valid)
+				}
+			}()
+		})
+	}
+
+	// Without a policy, a module does not stand in for the webhook, which rejects the message
+	assert.False(t, decide(7, map[net.Addr]bool{subscriber(0): true, awaitedAddr("archive"): false}))
+	// A module rejecting it only suppresses later notifications
+	assert.True(t, decide(8, map[net.Addr]bool{subscriber(0): false, awaitedAddr("archive"): true}))
+	// A silent webhook is waited for until the timeout
+	assert.True(t, decide(9, nil))
+
+	engine.SetPolicy(1, &ValidationPolicy{Mode: PolicyAll, Timeout: 50 * time.Millisecond})
+	assert.False(t, decide(10, map[net.Addr]bool{subscriber(0): true}))
+	assert.True(t, decide(11, map[net.Addr]bool{subscriber(0): true, awaitedAddr("archive"): true}))
+}
+
+// TestPolicyEnginePayloadValidators checks that built-in validators reject before any module is notified.
+func TestPolicyEnginePayloadValidators(t *testing.T) {
+	schema, err := ParseSchema([]byte(`{"type": "object", "required": ["text"], "properties": {"text": {"type": "string"}}}`))
+	assert.NoError(t, err)
+
+	engine := NewPolicyEngine(subscribedMapper(0))
+	engine.SetPolicy(1, &ValidationPolicy{Mode: PolicyAll, Validators: []PayloadValidator{
+		MaxSizeValidator{Limit: 32},
+		RegexValidator{Pattern: regexp.MustCompile(`^\{`)},
+		SchemaValidator{Schema: schema},
+	}})
+
+	notify := func() {}
+	assert.True(t, engine.Decide(1, 1, []byte(`{"text": "hi"}`), notify))
+	assert.False(t, engine.Decide(2, 1, []byte(`{"text": 1}`), notify))
+	assert.False(t, engine.Decide(3, 1, []byte(`{"other": "hi"}`), notify))
+	assert.False(t, engine.Decide(4, 1, []byte(`{"text": "far too long for the size limit"}`), notify))
+}
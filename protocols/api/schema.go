@@ -0,0 +1,112 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+)
+
+// Schema is the subset of JSON Schema understood by the built-in validator:
+// type, required, properties, items and enum.
+type Schema struct {
+	Type       string             `json:"type,omitempty"`
+	Required   []string           `json:"required,omitempty"`
+	Properties map[string]*Schema `json:"properties,omitempty"`
+	Items      *Schema            `json:"items,omitempty"`
+	Enum       []interface{}      `json:"enum,omitempty"`
+}
+
+// ParseSchema parses a JSON schema document
+func ParseSchema(raw []byte) (*Schema, error) {
+	var schema Schema
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return &schema, nil
+}
+
+// SchemaValidator rejects payloads that are not JSON documents matching Schema
+type SchemaValidator struct {
+	Schema *Schema
+}
+
+func (v SchemaValidator) Validate(payload []byte) error {
+	var document interface{}
+	if err := json.Unmarshal(payload, &document); err != nil {
+		return fmt.Errorf("payload is not JSON: %w", err)
+	}
+	return v.Schema.check(document, "$")
+}
+
+func (s *Schema) check(value interface{}, path string) error {
+	if s.Type != "" && !hasType(value, s.Type) {
+		return fmt.Errorf("%s: expected %s", path, s.Type)
+	}
+
+	if len(s.Enum) > 0 {
+		found := false
+		for _, allowed := range s.Enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: value not allowed", path)
+		}
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, exists := typed[name]; !exists {
+				return fmt.Errorf("%s: missing property %s", path, name)
+			}
+		}
+		for name, property := range s.Properties {
+			if field, exists := typed[name]; exists {
+				if err := property.check(field, path+"."+name); err != nil {
+					return err
+				}
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range typed {
+				if err := s.Items.check(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func hasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "null":
+		return value == nil
+	default:
+		return false
+	}
+}